@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"blockchain"
+	"blockchain/nodeapi"
+	"blockchain/p2p"
+
+	"github.com/gorilla/mux"
+)
+
+type role int
+
+const (
+	roleNone role = iota
+	roleReader
+	roleSubmitter
+	rolePeer
+	roleAdmin
+)
+
+var roleNames = map[string]role{
+	"none":      roleNone,
+	"reader":    roleReader,
+	"submitter": roleSubmitter,
+	"peer":      rolePeer,
+	"admin":     roleAdmin,
+}
+
+func parseRole(s string) (role, error) {
+	r, ok := roleNames[s]
+	if !ok {
+		return roleNone, fmt.Errorf("unknown role: %s", s)
+	}
+	return r, nil
+}
+
+func (r role) String() string {
+	for name, value := range roleNames {
+		if value == r {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// defaultRoutePolicy is the minimum role per route, keyed by method and
+// path template. Routes not listed require admin.
+var defaultRoutePolicy = map[string]role{
+	"GET /openapi.yaml":                  roleNone,
+	"GET /healthz":                       roleNone,
+	"GET /readyz":                        roleNone,
+	"GET /status":                        roleNone,
+	"GET /nodes":                         roleNone,
+	"GET /chains":                        roleReader,
+	"GET /blocks":                        roleReader,
+	"GET /blocks/{height:[0-9]+}":        roleReader,
+	"GET /blocks/hash/{hash}":            roleReader,
+	"GET /headers":                       roleReader,
+	"GET /transactions/{txid}":           roleReader,
+	"GET /transactions/{txid}/proof":     roleReader,
+	"GET /addresses/{addr}/transactions": roleReader,
+	"GET /events":                        roleReader,
+	"POST /rpc":                          roleReader,
+	"GET /metrics":                       roleReader,
+	"GET /snapshot":                      roleReader,
+	"GET /p2p/peers":                     roleReader,
+	"POST /transactions":                 roleSubmitter,
+	"POST /transactions/relay":           rolePeer,
+	"POST /blocks/compact":               rolePeer,
+	"GET /webhooks":                      roleSubmitter,
+	"POST /webhooks":                     roleSubmitter,
+	"DELETE /webhooks/{id}":              roleSubmitter,
+	"POST /mine":                         roleAdmin,
+	"POST /nodes":                        roleAdmin,
+	"GET /nodes/resolve":                 roleAdmin,
+	"POST /admin/reindex":                roleAdmin,
+	// Node API calls on GRPC_PORT, by method path.
+	"POST /nodeapi.Node/GetBlock":          roleReader,
+	"POST /nodeapi.Node/StreamBlocks":      roleReader,
+	"POST /nodeapi.Node/StreamMempool":     roleReader,
+	"POST /nodeapi.Node/SubmitTransaction": roleSubmitter,
+}
+
+type apiKey struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	Role string `json:"role"`
+}
+
+// authConfig is read from the JSON file named by AUTH_CONFIG. API_KEYS may
+// add keys as a comma separated list of name:role:key entries, and
+// PEER_NODE_IDS the node IDs whose signed requests get the peer role.
+type authConfig struct {
+	AnonymousRole string            `json:"anonymous_role"`
+	Keys          []apiKey          `json:"keys"`
+	PeerNodes     []string          `json:"peer_nodes"`
+	Routes        map[string]string `json:"routes"`
+}
+
+type principal struct {
+	name string
+	role role
+	// nodeID is set when the request was signed by a peer node.
+	nodeID string
+}
+
+type authenticator struct {
+	anonymous principal
+	keys      map[[sha256.Size]byte]principal
+	nodes     map[string]bool
+	// nonces refuses replays of requests signed by the listed nodes;
+	// signatures of other nodes get nothing a replay could abuse.
+	nonces *p2p.Nonces
+	routes map[string]role
+}
+
+type principalKey struct{}
+
+type authenticationKey struct{}
+
+type authentication struct {
+	principal principal
+	valid     bool
+}
+
+func loadAuthConfig() (*authConfig, error) {
+	config := &authConfig{AnonymousRole: "reader"}
+	if path := os.Getenv("AUTH_CONFIG"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range blockchain.SplitList(os.Getenv("API_KEYS")) {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("API_KEYS entry must be name:role:key")
+		}
+		config.Keys = append(config.Keys, apiKey{Name: parts[0], Role: parts[1], Key: parts[2]})
+	}
+	config.PeerNodes = append(config.PeerNodes, blockchain.SplitList(os.Getenv("PEER_NODE_IDS"))...)
+	if role := os.Getenv("ANONYMOUS_ROLE"); role != "" {
+		config.AnonymousRole = role
+	}
+	return config, nil
+}
+
+func newAuthenticator(config *authConfig) (*authenticator, error) {
+	anonymous, err := parseRole(config.AnonymousRole)
+	if err != nil {
+		return nil, err
+	}
+	auth := &authenticator{
+		anonymous: principal{name: "anonymous", role: anonymous},
+		keys:      make(map[[sha256.Size]byte]principal),
+		nodes:     make(map[string]bool),
+		nonces:    p2p.NewNonces(),
+		routes:    make(map[string]role),
+	}
+	for _, id := range config.PeerNodes {
+		auth.nodes[id] = true
+	}
+	for _, key := range config.Keys {
+		r, err := parseRole(key.Role)
+		if err != nil {
+			return nil, err
+		}
+		if key.Key == "" {
+			return nil, fmt.Errorf("api key %s is empty", key.Name)
+		}
+		auth.keys[sha256.Sum256([]byte(key.Key))] = principal{name: key.Name, role: r}
+	}
+	for route, r := range defaultRoutePolicy {
+		auth.routes[route] = r
+	}
+	for route, name := range config.Routes {
+		r, err := parseRole(name)
+		if err != nil {
+			return nil, err
+		}
+		auth.routes[route] = r
+	}
+	return auth, nil
+}
+
+func credential(req *http.Request) string {
+	if key := req.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+	const prefix = "bearer "
+	authorization := req.Header.Get("Authorization")
+	if len(authorization) > len(prefix) && strings.ToLower(authorization[:len(prefix)]) == prefix {
+		return strings.TrimSpace(authorization[len(prefix):])
+	}
+	return ""
+}
+
+// authenticate identifies the caller of req by API key, or by node
+// signature when it has none. A valid signature of a node not listed in
+// PEER_NODE_IDS counts as anonymous, so that nodes may sign every request.
+func (auth *authenticator) authenticate(req *http.Request) (principal, bool) {
+	if credential(req) == "" && p2p.IsSigned(req) {
+		id, err := p2p.VerifyRequest(req)
+		if err != nil {
+			return principal{}, false
+		}
+		if !auth.nodes[id] {
+			return auth.anonymous, true
+		}
+		if err := auth.nonces.Check(req, id); err != nil {
+			return principal{}, false
+		}
+		return principal{name: "node:" + id, role: rolePeer, nodeID: id}, true
+	}
+	return auth.authenticateKey(req)
+}
+
+func (auth *authenticator) authenticateKey(req *http.Request) (principal, bool) {
+	token := credential(req)
+	if token == "" {
+		return auth.anonymous, true
+	}
+	// Keys are looked up by digest so the comparison does not leak the key.
+	digest := sha256.Sum256([]byte(token))
+	for keyDigest, p := range auth.keys {
+		if subtle.ConstantTimeCompare(keyDigest[:], digest[:]) == 1 {
+			return p, true
+		}
+	}
+	return principal{}, false
+}
+
+// authenticated returns the caller of req as found by authenticate, running
+// it once per request: the rate limiter authenticates first and keeps the
+// result in the returned request for authorization, so a node signature is
+// only verified once.
+func (auth *authenticator) authenticated(req *http.Request, authenticate func(*http.Request) (principal, bool)) (*http.Request, principal, bool) {
+	if a, ok := req.Context().Value(authenticationKey{}).(authentication); ok {
+		return req, a.principal, a.valid
+	}
+	p, valid := authenticate(req)
+	ctx := context.WithValue(req.Context(), authenticationKey{}, authentication{principal: p, valid: valid})
+	return req.WithContext(ctx), p, valid
+}
+
+func (auth *authenticator) requiredRole(req *http.Request) role {
+	route := mux.CurrentRoute(req)
+	if route == nil {
+		return roleAdmin
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return roleAdmin
+	}
+	return auth.routeRole(req.Method + " " + template)
+}
+
+func (auth *authenticator) routeRole(route string) role {
+	if r, ok := auth.routes[route]; ok {
+		return r
+	}
+	return roleAdmin
+}
+
+func (auth *authenticator) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req, p, ok := auth.authenticated(req, auth.authenticate)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="block-chain-go"`)
+			writeError(w, http.StatusUnauthorized, errUnauthorized, "invalid credentials", nil)
+			return
+		}
+		if required := auth.requiredRole(req); p.role < required {
+			if p == auth.anonymous {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="block-chain-go"`)
+				writeError(w, http.StatusUnauthorized, errUnauthorized, "authentication required", nil)
+				return
+			}
+			writeError(w, http.StatusForbidden, errForbidden, "requires role "+required.String(), nil)
+			return
+		}
+		// The signature covers the body by digest; a signed request replayed
+		// with another body is refused.
+		if p.nodeID != "" {
+			if err := p2p.CheckBody(req); err == p2p.ErrBodyDigest {
+				writeError(w, http.StatusUnauthorized, errUnauthorized, err.Error(), nil)
+				return
+			} else if err != nil {
+				writeDecodeError(w, err)
+				return
+			}
+		}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), principalKey{}, p)))
+	})
+}
+
+// interceptor applies the route policy to node API calls, which carry their
+// API key in the x-api-key or authorization metadata. Node signatures are
+// not accepted there, since streams are not read whole to check the body.
+func (auth *authenticator) interceptor(w http.ResponseWriter, req *http.Request) (*http.Request, error) {
+	req, p, ok := auth.authenticated(req, auth.authenticateKey)
+	if !ok {
+		return nil, &nodeapi.Error{Code: nodeapi.CodeUnauthenticated, Message: "invalid credentials"}
+	}
+	if required := auth.routeRole(req.Method + " " + req.URL.Path); p.role < required {
+		if p == auth.anonymous {
+			return nil, &nodeapi.Error{Code: nodeapi.CodeUnauthenticated, Message: "authentication required"}
+		}
+		return nil, &nodeapi.Error{Code: nodeapi.CodePermissionDenied, Message: "requires role " + required.String()}
+	}
+	return req.WithContext(context.WithValue(req.Context(), principalKey{}, p)), nil
+}
+
+// hasRole reports whether the authenticated caller of req has at least r.
+func hasRole(req *http.Request, r role) bool {
+	p, ok := req.Context().Value(principalKey{}).(principal)
+	return ok && p.role >= r
+}
+
+func loadAuthenticator() *authenticator {
+	config, err := loadAuthConfig()
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	auth, err := newAuthenticator(config)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	return auth
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"blockchain/p2p"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRolesAreOrdered(t *testing.T) {
+	roles := []string{"none", "reader", "submitter", "peer", "admin"}
+	for i := 1; i < len(roles); i++ {
+		lower, _ := parseRole(roles[i-1])
+		higher, _ := parseRole(roles[i])
+		if lower >= higher {
+			t.Errorf("role %s is not below %s", roles[i-1], roles[i])
+		}
+	}
+	if _, err := parseRole("root"); err == nil {
+		t.Error("unknown role parsed")
+	}
+}
+
+// newTestAuthRouter serves a few routes behind auth, recording the caller
+// of the last request and the body it read.
+func newTestAuthRouter(auth *authenticator) (http.Handler, *principal, *string) {
+	caller := new(principal)
+	body := new(string)
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		*caller, _ = req.Context().Value(principalKey{}).(principal)
+		data, _ := io.ReadAll(req.Body)
+		*body = string(data)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/blocks", handler).Methods("GET")
+	router.HandleFunc("/transactions", handler).Methods("POST")
+	router.HandleFunc("/transactions/relay", handler).Methods("POST")
+	router.HandleFunc("/unlisted", handler).Methods("GET")
+	router.Use(auth.middleware)
+	return router, caller, body
+}
+
+func TestRoutePolicy(t *testing.T) {
+	auth := newTestAuthenticator(t, &authConfig{Keys: []apiKey{
+		{Name: "reader", Key: "reader-key", Role: "reader"},
+		{Name: "submitter", Key: "submitter-key", Role: "submitter"},
+		{Name: "peer", Key: "peer-key", Role: "peer"},
+		{Name: "admin", Key: "admin-key", Role: "admin"},
+	}})
+	handler, _, _ := newTestAuthRouter(auth)
+	for _, test := range []struct {
+		method string
+		path   string
+		key    string
+		status int
+	}{
+		{"GET", "/blocks", "", http.StatusOK},
+		{"GET", "/blocks", "reader-key", http.StatusOK},
+		{"POST", "/transactions", "", http.StatusUnauthorized},
+		{"POST", "/transactions", "reader-key", http.StatusForbidden},
+		{"POST", "/transactions", "submitter-key", http.StatusOK},
+		{"POST", "/transactions", "admin-key", http.StatusOK},
+		{"POST", "/transactions/relay", "submitter-key", http.StatusForbidden},
+		{"POST", "/transactions/relay", "peer-key", http.StatusOK},
+		// Routes missing from the policy require admin.
+		{"GET", "/unlisted", "peer-key", http.StatusForbidden},
+		{"GET", "/unlisted", "admin-key", http.StatusOK},
+		// An invalid key is refused even where anonymous callers are not.
+		{"GET", "/blocks", "wrong-key", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.key != "" {
+			req.Header.Set("Authorization", "Bearer "+test.key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("%s %s with %q: status %d, want %d", test.method, test.path, test.key, w.Code, test.status)
+		}
+		if unauthorized := w.Header().Get("WWW-Authenticate") != ""; unauthorized != (test.status == http.StatusUnauthorized) {
+			t.Errorf("%s %s with %q: WWW-Authenticate %q", test.method, test.path, test.key, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func newTestIdentity(t *testing.T) *p2p.Identity {
+	identity, err := p2p.NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
+
+func signedRequest(identity *p2p.Identity, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/transactions/relay", strings.NewReader(body))
+	identity.SignRequest(req, []byte(body))
+	return req
+}
+
+func TestSignedRequests(t *testing.T) {
+	peer := newTestIdentity(t)
+	auth := newTestAuthenticator(t, &authConfig{PeerNodes: []string{peer.ID()}})
+	handler, caller, body := newTestAuthRouter(auth)
+	serve := func(req *http.Request) int {
+		*caller = principal{}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	req := signedRequest(peer, `{"amount":5}`)
+	if status := serve(req); status != http.StatusOK || caller.nodeID != peer.ID() || *body != `{"amount":5}` {
+		t.Fatalf("listed peer: status %d as %+v with body %q", status, *caller, *body)
+	}
+	// The same request sent again is refused.
+	if status := serve(signedRequest(peer, `{"amount":5}`)); status != http.StatusOK {
+		t.Fatalf("request signed again: status %d", status)
+	}
+	replay := httptest.NewRequest("POST", "/transactions/relay", strings.NewReader(`{"amount":5}`))
+	replay.Header = req.Header.Clone()
+	if status := serve(replay); status != http.StatusUnauthorized {
+		t.Fatalf("replayed request: status %d, want 401", status)
+	}
+
+	// A valid signature of another node is anonymous.
+	if status := serve(signedRequest(newTestIdentity(t), `{}`)); status != http.StatusUnauthorized {
+		t.Fatalf("unknown node: status %d, want 401", status)
+	}
+	other := httptest.NewRequest("GET", "/blocks", nil)
+	newTestIdentity(t).SignRequest(other, nil)
+	if status := serve(other); status != http.StatusOK || *caller != auth.anonymous {
+		t.Fatalf("unknown node on a reader route: status %d as %+v", status, *caller)
+	}
+
+	// The body must match the signed digest.
+	tampered := signedRequest(peer, `{"amount":5}`)
+	tampered.Body = httptest.NewRequest("POST", "/", strings.NewReader(`{"amount":500}`)).Body
+	if status := serve(tampered); status != http.StatusUnauthorized || *caller != (principal{}) {
+		t.Fatalf("tampered body: status %d, want 401", status)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"blockchain"
+	"blockchain/discovery"
+)
+
+var discoveryManager *discovery.Manager
+
+// maxLearning bounds the advertised addresses looked up at a time; more
+// are ignored.
+const maxLearning = 8
+
+var learning = make(chan struct{}, maxLearning)
+
+// peerTransport is used for every request to peers. It signs them with the
+// node identity and dials through discovery, which reaches discovered peers
+// only on public addresses.
+var peerTransport = identity.Transport(newPeerTransport())
+
+func newPeerTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialed instead of the peer, bypassing the check.
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if discoveryManager == nil {
+			return blockchain.PublicDialer(&net.Dialer{}).DialContext(ctx, network, address)
+		}
+		return discoveryManager.DialContext(ctx, network, address)
+	}
+	return transport
+}
+
+// startDiscovery keeps PEER_TARGET peers, found from the SEED_NODES urls and
+// by asking peers for theirs. The address book is kept in DATA_DIR, and
+// ADVERTISE_URL is the url peers are told to reach this node at.
+// DISCOVERY_ALLOW_PRIVATE=true allows discovered peers on the local network.
+func startDiscovery() {
+	book := discovery.NewAddrBook()
+	if dir := os.Getenv("DATA_DIR"); dir != "" {
+		var err error
+		if book, err = discovery.LoadAddrBook(filepath.Join(dir, "peers.json")); err != nil {
+			log.Fatal("Error: ", err)
+		}
+	}
+	config := discovery.Config{
+		Seeds:        blockchain.SplitList(os.Getenv("SEED_NODES")),
+		Self:         strings.TrimRight(os.Getenv("ADVERTISE_URL"), "/"),
+		NodeID:       identity.ID(),
+		NetworkID:    networkID,
+		Identity:     identity,
+		AllowPrivate: os.Getenv("DISCOVERY_ALLOW_PRIVATE") == "true",
+	}
+	if s := os.Getenv("PEER_TARGET"); s != "" {
+		target, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal("Error: ", err)
+		}
+		config.Target = target
+	}
+	if s := os.Getenv("DISCOVERY_INTERVAL"); s != "" {
+		interval, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatal("Error: ", err)
+		}
+		config.Interval = interval
+	}
+	discoveryManager = discovery.NewManager(blockChain, book, config)
+	discoveryManager.SetLogger(logger)
+	// PEER_TARGET=0 turns discovery off; GET /nodes still answers.
+	if os.Getenv("PEER_TARGET") != "0" {
+		discoveryManager.Start()
+	}
+}
+
+// listNodesHandler answers peer exchange with the peers this node knows to
+// work, and learns the address of the asking node when it advertises one
+// that resolves to where the request came from. The lookup runs after the
+// answer, so a slow name does not hold it up, and at most maxLearning run
+// at a time.
+func listNodesHandler(w http.ResponseWriter, req *http.Request) {
+	if advertised := req.Header.Get(discovery.AdvertiseHeader); advertised != "" {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		select {
+		case learning <- struct{}{}:
+			go func() {
+				defer func() { <-learning }()
+				if err := discoveryManager.Learn(advertised, host); err != nil {
+					logger.Debug("advertised address ignored", "address", advertised, "remote", host, "error", err)
+				}
+			}()
+		default:
+		}
+	}
+	writeJSON(w, http.StatusOK, discoveryManager.Addresses(discovery.MaxAddresses))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"net/http"
+	"time"
+
+	"blockchain"
+)
+
+// openAPISpec is embedded so that it is served whatever the working
+// directory of the process.
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+const (
+	errInvalidRequest   = "invalid_request"
+	errInvalidParameter = "invalid_parameter"
+	errNotFound         = "not_found"
+	errMethodNotAllowed = "method_not_allowed"
+	errConflict         = "conflict"
+	errUnauthorized     = "unauthorized"
+	errRateLimited      = "rate_limited"
+	errTooLarge         = "request_too_large"
+	errUnavailable      = "unavailable"
+	errForbidden        = "forbidden"
+	errInternal         = "internal_error"
+)
+
+type apiError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string, details interface{}) {
+	writeJSON(w, status, errorResponse{apiError{Code: code, Message: message, Details: details}})
+}
+
+func writeBadRequest(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusBadRequest, errInvalidRequest, err.Error(), nil)
+}
+
+// writeDecodeError reports a request body that could not be decoded,
+// distinguishing bodies cut off by the size limit.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	if errors.As(err, new(*http.MaxBytesError)) {
+		writeError(w, http.StatusRequestEntityTooLarge, errTooLarge, err.Error(), nil)
+		return
+	}
+	writeBadRequest(w, err)
+}
+
+func writeTransactionError(w http.ResponseWriter, err error) {
+	switch err {
+	case blockchain.ErrPoolFull:
+		writeError(w, http.StatusServiceUnavailable, errUnavailable, err.Error(), nil)
+		return
+	case blockchain.ErrDuplicateTransaction:
+		writeError(w, http.StatusConflict, errConflict, err.Error(), nil)
+		return
+	}
+	writeBadRequest(w, err)
+}
+
+func writeInvalidParameter(w http.ResponseWriter, name string, message string) {
+	writeError(w, http.StatusBadRequest, errInvalidParameter, message, map[string]string{"parameter": name})
+}
+
+func writeNotFound(w http.ResponseWriter, message string) {
+	writeError(w, http.StatusNotFound, errNotFound, message, nil)
+}
+
+func writeInternalError(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusInternalServerError, errInternal, err.Error(), nil)
+}
+
+func writeMineError(w http.ResponseWriter, err error) {
+	switch err {
+	case blockchain.ErrStaleBlock, blockchain.ErrNotInTurn:
+		writeError(w, http.StatusConflict, errConflict, err.Error(), nil)
+	case blockchain.ErrNotValidator:
+		writeError(w, http.StatusForbidden, errForbidden, err.Error(), nil)
+	default:
+		writeInternalError(w, err)
+	}
+}
+
+func notFoundHandler(w http.ResponseWriter, req *http.Request) {
+	writeNotFound(w, "no route for "+req.URL.Path)
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, req *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, req.Method+" is not allowed on "+req.URL.Path, nil)
+}
+
+func openAPIHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+	http.ServeContent(w, req, "openapi.yaml", time.Time{}, bytes.NewReader(openAPISpec))
+}
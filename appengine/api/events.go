@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"blockchain"
+	"blockchain/websocket"
+)
+
+const (
+	eventsKeepAlive = 30 * time.Second
+	// eventsWriteTimeout ends a WebSocket stream whose client stopped
+	// reading, releasing its subscription.
+	eventsWriteTimeout = 10 * time.Second
+)
+
+// websocketOrigins are the browser origins besides this host allowed to open
+// an event WebSocket, from WEBSOCKET_ORIGINS.
+var websocketOrigins = blockchain.SplitList(os.Getenv("WEBSOCKET_ORIGINS"))
+
+func eventFilter(req *http.Request) blockchain.EventFilter {
+	query := req.URL.Query()
+	return blockchain.EventFilter{
+		Types:     blockchain.SplitList(query.Get("types")),
+		Addresses: blockchain.SplitList(query.Get("address")),
+	}
+}
+
+// gapEvent is the last event of a stream whose subscription dropped events.
+// The stream ends with it, like the node API streams, so that the client
+// reconnects and catches up instead of missing blocks and payments.
+func gapEvent(sub *blockchain.Subscription) (blockchain.Event, bool) {
+	dropped := sub.Dropped()
+	return blockchain.Event{Type: blockchain.EventGap, Dropped: dropped}, dropped > 0
+}
+
+func eventsHandler(w http.ResponseWriter, req *http.Request) {
+	if websocket.IsWebSocketUpgrade(req) {
+		websocketEventsHandler(w, req)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, errInternal, "streaming not supported", nil)
+		return
+	}
+	sub := blockChain.Subscribe(eventFilter(req))
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			gap, dropped := gapEvent(sub)
+			if dropped {
+				event = gap
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				requestLogger(req).Warn("encoding event failed", "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+			if dropped {
+				return
+			}
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func websocketEventsHandler(w http.ResponseWriter, req *http.Request) {
+	conn, err := websocket.Upgrade(w, req, websocketOrigins)
+	if err != nil {
+		requestLogger(req).Warn("websocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+	sub := blockChain.Subscribe(eventFilter(req))
+	defer sub.Close()
+
+	// The client does not send anything meaningful; reading detects close.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			gap, dropped := gapEvent(sub)
+			if dropped {
+				event = gap
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				requestLogger(req).Warn("encoding event failed", "error", err)
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+			if err := conn.WriteText(data); err != nil || dropped {
+				return
+			}
+		case <-keepAlive.C:
+			conn.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+			if err := conn.WriteMessage(websocket.OpPing, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
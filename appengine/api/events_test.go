@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"blockchain"
+)
+
+// stalledWriter is a streaming ResponseWriter whose writes wait until
+// release is closed, like a client that stopped reading.
+type stalledWriter struct {
+	header  http.Header
+	writing chan struct{}
+	release chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	body    bytes.Buffer
+}
+
+func newStalledWriter() *stalledWriter {
+	return &stalledWriter{header: make(http.Header), writing: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (w *stalledWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *stalledWriter) WriteHeader(int) {}
+
+func (w *stalledWriter) Flush() {}
+
+func (w *stalledWriter) Write(b []byte) (int, error) {
+	w.once.Do(func() { close(w.writing) })
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.body.Write(b)
+}
+
+func TestEventsEndWithGapWhenEventsAreDropped(t *testing.T) {
+	w := newStalledWriter()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest("GET", "/events?address=gap-test", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		eventsHandler(w, req)
+		close(done)
+	}()
+
+	// Add transactions until the stream is stuck writing one, then
+	// overflow its subscription.
+	amount := int64(0)
+	add := func() {
+		amount++
+		transaction := &blockchain.Transaction{Sender: "alice", Recipient: "gap-test", Amount: amount}
+		if err := blockChain.AddTransaction(context.Background(), transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for stuck := false; !stuck; {
+		add()
+		select {
+		case <-w.writing:
+			stuck = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	for i := 0; i < 2*64; i++ {
+		add()
+	}
+	close(w.release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream kept going after dropping events")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	events := strings.Split(strings.TrimSpace(w.body.String()), "\n\n")
+	if last := events[len(events)-1]; !strings.HasPrefix(last, "event: gap\ndata: {\"type\":\"gap\",\"dropped\":") {
+		t.Fatalf("last event = %q, want a gap", last)
+	}
+}
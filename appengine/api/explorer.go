@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"blockchain"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultBlocksLimit  = 20
+	maxBlocksLimit      = 100
+	defaultHeadersLimit = 500
+	maxHeadersLimit     = 2000
+)
+
+type blocksPage struct {
+	Blocks     []blockchain.Block `json:"blocks"`
+	NextBefore *int               `json:"next_before,omitempty"`
+}
+
+func intQuery(req *http.Request, key string, def int) (int, error) {
+	s := req.URL.Query().Get(key)
+	if s == "" {
+		return def, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func listBlocksHandler(w http.ResponseWriter, req *http.Request) {
+	limit, err := intQuery(req, "limit", defaultBlocksLimit)
+	if err != nil || limit <= 0 {
+		writeInvalidParameter(w, "limit", "limit must be a positive integer")
+		return
+	}
+	if limit > maxBlocksLimit {
+		limit = maxBlocksLimit
+	}
+	before, err := intQuery(req, "before", blockChain.Height()+1)
+	if err != nil || before < 0 {
+		writeInvalidParameter(w, "before", "before must be a non-negative integer")
+		return
+	}
+
+	blocks := blockChain.ListBlocks(before, limit)
+	page := blocksPage{Blocks: blocks}
+	if n := len(blocks); n > 0 && blocks[n-1].Height > 0 {
+		next := blocks[n-1].Height
+		page.NextBefore = &next
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// listHeadersHandler serves block headers oldest first, which pruned nodes
+// keep for every block.
+func listHeadersHandler(w http.ResponseWriter, req *http.Request) {
+	limit, err := intQuery(req, "limit", defaultHeadersLimit)
+	if err != nil || limit <= 0 {
+		writeInvalidParameter(w, "limit", "limit must be a positive integer")
+		return
+	}
+	if limit > maxHeadersLimit {
+		limit = maxHeadersLimit
+	}
+	var headers []blockchain.Block
+	if locator := req.URL.Query().Get("locator"); locator != "" {
+		headers = blockChain.HeadersAfter(blockchain.SplitList(locator), limit)
+	} else {
+		from, err := intQuery(req, "from", 0)
+		if err != nil || from < 0 {
+			writeInvalidParameter(w, "from", "from must be a non-negative integer")
+			return
+		}
+		headers = blockChain.Headers(from, limit)
+	}
+	if headers == nil {
+		headers = []blockchain.Block{}
+	}
+	writeJSON(w, http.StatusOK, headers)
+}
+
+func getBlockHandler(w http.ResponseWriter, req *http.Request) {
+	height, err := strconv.Atoi(mux.Vars(req)["height"])
+	if err != nil {
+		writeInvalidParameter(w, "height", err.Error())
+		return
+	}
+	block, ok := blockChain.BlockByHeight(height)
+	if !ok {
+		writeNotFound(w, "block not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, block)
+}
+
+func getBlockByHashHandler(w http.ResponseWriter, req *http.Request) {
+	block, ok := blockChain.BlockByHash(mux.Vars(req)["hash"])
+	if !ok {
+		writeNotFound(w, "block not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, block)
+}
+
+func getTransactionHandler(w http.ResponseWriter, req *http.Request) {
+	transaction, ok := blockChain.Transaction(mux.Vars(req)["txid"])
+	if !ok {
+		writeNotFound(w, "transaction not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, transaction)
+}
+
+// getTransactionProofHandler serves the merkle proof light clients check
+// against their block headers.
+func getTransactionProofHandler(w http.ResponseWriter, req *http.Request) {
+	proof, ok := blockChain.TransactionProof(mux.Vars(req)["txid"])
+	if !ok {
+		writeNotFound(w, "transaction not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, proof)
+}
+
+func getAddressTransactionsHandler(w http.ResponseWriter, req *http.Request) {
+	transactions := blockChain.AddressTransactions(mux.Vars(req)["addr"])
+	if transactions == nil {
+		transactions = []blockchain.TransactionInfo{}
+	}
+	writeJSON(w, http.StatusOK, transactions)
+}
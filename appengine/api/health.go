@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"blockchain"
+	"blockchain/trace"
+)
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+var networkID = envString("NETWORK_ID", "mainnet")
+
+const (
+	defaultSyncTolerance = 2
+	peerPollInterval     = 30 * time.Second
+	peerPollTimeout      = 5 * time.Second
+	maxPeerResponseSize  = 8 << 20
+)
+
+var errWrongNetwork = errors.New("peer is on another network")
+
+type nodeStatus struct {
+	Version     string `json:"version"`
+	NetworkID   string `json:"network_id"`
+	NodeID      string `json:"node_id,omitempty"`
+	GenesisHash string `json:"genesis_hash"`
+	Height      int    `json:"height"`
+	TipHash     string `json:"tip_hash"`
+	Peers       int    `json:"peers"`
+	Mempool     int    `json:"mempool"`
+	Mining      bool   `json:"mining"`
+	// PrunedHeight is the last height whose transactions this node no
+	// longer serves, or -1 on a full node.
+	Pruned       bool `json:"pruned"`
+	PrunedHeight int  `json:"pruned_height"`
+}
+
+type check struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]interface{} `json:"checks"`
+}
+
+func envString(key string, def string) string {
+	if s := os.Getenv(key); s != "" {
+		return s
+	}
+	return def
+}
+
+func syncTolerance() int {
+	tolerance, err := strconv.Atoi(os.Getenv("SYNC_TOLERANCE"))
+	if err != nil {
+		return defaultSyncTolerance
+	}
+	return tolerance
+}
+
+func statusHandler(w http.ResponseWriter, req *http.Request) {
+	genesis, _ := blockChain.BlockByHeight(0)
+	prunedHeight := blockChain.PrunedHeight()
+	var nodeID string
+	if p2pNode != nil {
+		nodeID = p2pNode.ID()
+	}
+	writeJSON(w, http.StatusOK, nodeStatus{
+		Version:      version,
+		NetworkID:    networkID,
+		NodeID:       nodeID,
+		GenesisHash:  genesis.Hash,
+		Height:       blockChain.Height(),
+		TipHash:      blockChain.TipHash(),
+		Peers:        len(blockChain.Peers()),
+		Mempool:      len(blockChain.PendingTransactions()),
+		Mining:       blockChain.Mining(),
+		Pruned:       prunedHeight >= 0,
+		PrunedHeight: prunedHeight,
+	})
+}
+
+// runHealthChecks runs the checks; ready additionally requires the node to be
+// synced with its peers.
+func runHealthChecks(ready bool) (int, healthReport) {
+	healthy := true
+	storage := check{OK: true}
+	if err := blockChain.PingStore(); err != nil {
+		storage = check{Error: err.Error()}
+		healthy = false
+	}
+	sync := blockChain.SyncStatus(syncTolerance())
+	// Until the peers were asked once, their heights are not known and the
+	// node cannot tell whether it is behind.
+	polled := peerHeightsPolled.Load() || len(blockChain.Peers()) == 0
+	if ready && (!sync.Synced || !polled) {
+		healthy = false
+	}
+
+	report := healthReport{
+		Status: "ok",
+		Checks: map[string]interface{}{
+			"storage": storage,
+			"sync":    sync,
+			"peers":   map[string]bool{"polled": polled},
+			"mining":  map[string]bool{"active": blockChain.Mining()},
+		},
+	}
+	if !healthy {
+		report.Status = "unavailable"
+		return http.StatusServiceUnavailable, report
+	}
+	return http.StatusOK, report
+}
+
+// healthzHandler reports liveness: the process serves requests and can
+// write to its store.
+func healthzHandler(w http.ResponseWriter, req *http.Request) {
+	status, report := runHealthChecks(false)
+	writeJSON(w, status, report)
+}
+
+// readyzHandler reports whether the node should receive traffic.
+func readyzHandler(w http.ResponseWriter, req *http.Request) {
+	status, report := runHealthChecks(true)
+	writeJSON(w, status, report)
+}
+
+// peerHeightsPolled is set once every peer was asked for its height.
+var peerHeightsPolled atomic.Bool
+
+// watchPeerHeights asks every peer for its status now and then periodically,
+// so that readiness reflects how far behind the network this node is. New
+// peers are asked right away.
+func watchPeerHeights() {
+	client := &http.Client{Timeout: peerPollTimeout, Transport: peerTransport}
+	added := blockChain.Subscribe(blockchain.EventFilter{Types: []string{blockchain.EventPeer}})
+	ticker := time.NewTicker(peerPollInterval)
+	defer ticker.Stop()
+	for {
+		for _, node := range blockChain.Peers() {
+			pollPeer(client, node)
+		}
+		peerHeightsPolled.Store(true)
+	wait:
+		for {
+			select {
+			case event := <-added.C:
+				pollPeer(client, event.Peer)
+			case <-ticker.C:
+				break wait
+			}
+		}
+	}
+}
+
+// pollPeer records the height of node. A height above this node's only
+// counts once the headers up to it were fetched and verified, so a peer
+// cannot hold the node back by claiming blocks it does not have. A peer that
+// fails loses its height.
+func pollPeer(client *http.Client, node string) {
+	height, err := peerHeight(client, node)
+	if err != nil {
+		logger.Debug("peer height unknown", "peer", node, "error", err)
+		blockChain.ForgetPeerHeight(node)
+		return
+	}
+	blockChain.SetPeerHeight(node, height)
+}
+
+func peerHeight(client *http.Client, node string) (int, error) {
+	status, err := fetchPeerStatus(client, node)
+	if err != nil {
+		return 0, err
+	}
+	if status.NetworkID != networkID {
+		logger.Warn("peer is on another network", "peer", node, "network_id", status.NetworkID)
+		return 0, errWrongNetwork
+	}
+	if status.Height <= blockChain.Height() {
+		return status.Height, nil
+	}
+	var headers []blockchain.Block
+	path := "/headers?limit=" + strconv.Itoa(maxHeadersLimit) + "&locator=" + strings.Join(blockChain.Locator(), ",")
+	if err := peerGet(client, node, path, &headers); err != nil {
+		return 0, err
+	}
+	verified, err := blockChain.VerifyHeaders(headers)
+	if err != nil {
+		logger.Warn("peer sent invalid headers", "peer", node, "error", err)
+		return 0, err
+	}
+	if verified > status.Height {
+		verified = status.Height
+	}
+	return verified, nil
+}
+
+func fetchPeerStatus(client *http.Client, node string) (*nodeStatus, error) {
+	var status nodeStatus
+	if err := peerGet(client, node, "/status", &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func peerGet(client *http.Client, node string, path string, v interface{}) (err error) {
+	ctx, span := trace.Start(context.Background(), "GET "+strings.SplitN(path, "?", 2)[0], trace.SpanKindClient, trace.String("peer", node))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	req, err := http.NewRequest("GET", node+path, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	trace.Inject(ctx, req.Header)
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("http status code: %d", res.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, maxPeerResponseSize)).Decode(v)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+const requestIDHeader = "X-Request-Id"
+
+type loggerKey struct{}
+
+// newLogger builds the process logger from LOG_FORMAT (text or json) and
+// LOG_LEVEL (debug, info, warn or error).
+func newLogger() *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+	options := &slog.HandlerOptions{Level: level}
+	if strings.ToLower(os.Getenv("LOG_FORMAT")) == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, options))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, options))
+}
+
+// requestLogger returns the logger carrying the request ID of req.
+func requestLogger(req *http.Request) *slog.Logger {
+	if logger, ok := req.Context().Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(b []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	n, err := recorder.ResponseWriter.Write(b)
+	recorder.bytes += n
+	return n, err
+}
+
+func (recorder *statusRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (recorder *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := recorder.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	recorder.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// withRequestLogging assigns every request an ID, echoes it in the
+// response and logs one line per request.
+func withRequestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		id := req.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = uuid.NewV4().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		logger := slog.Default().With("request_id", id)
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, req.WithContext(context.WithValue(req.Context(), loggerKey{}, logger)))
+
+		// A handler that writes nothing answers 200.
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.Log(req.Context(), level, "request",
+			"method", req.Method,
+			"path", req.URL.Path,
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration", time.Since(start),
+			"remote", req.RemoteAddr)
+	})
+}
+
+func debugStateHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := blockChain.Dump(w); err != nil {
+		requestLogger(req).Error("dumping state failed", "error", err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLogs makes the default logger write JSON lines to the returned
+// buffer for the rest of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	buffer := new(bytes.Buffer)
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buffer, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return buffer
+}
+
+func logLines(t *testing.T, buffer *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	buffer := captureLogs(t)
+	handler := withRequestLogging(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requestLogger(req).Info("handling")
+	}))
+	long := strings.Repeat("x", 65)
+	for _, test := range []struct {
+		id   string
+		kept bool
+	}{{"abc-123", true}, {"", false}, {long, false}} {
+		buffer.Reset()
+		req := httptest.NewRequest("GET", "/blocks", nil)
+		req.Header.Set(requestIDHeader, test.id)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		id := w.Header().Get(requestIDHeader)
+		if kept := id == test.id; kept != test.kept || id == "" {
+			t.Errorf("request ID %q answered with %q", test.id, id)
+		}
+		for _, line := range logLines(t, buffer) {
+			if line["request_id"] != id {
+				t.Errorf("request ID %q logged %q as %v", id, line["msg"], line["request_id"])
+			}
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	buffer := captureLogs(t)
+	for _, test := range []struct {
+		handler http.HandlerFunc
+		status  int
+		level   string
+	}{
+		{func(w http.ResponseWriter, req *http.Request) {}, http.StatusOK, "INFO"},
+		{func(w http.ResponseWriter, req *http.Request) { w.Write([]byte("hello")) }, http.StatusOK, "INFO"},
+		{func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(http.StatusOK)
+		}, http.StatusNotFound, "INFO"},
+		{func(w http.ResponseWriter, req *http.Request) {
+			writeError(w, http.StatusServiceUnavailable, errUnavailable, "down", nil)
+		}, http.StatusServiceUnavailable, "ERROR"},
+	} {
+		buffer.Reset()
+		req := httptest.NewRequest("POST", "/transactions?x=1", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		withRequestLogging(test.handler).ServeHTTP(w, req)
+		lines := logLines(t, buffer)
+		line := lines[len(lines)-1]
+		if line["msg"] != "request" || line["level"] != test.level || line["method"] != "POST" ||
+			line["path"] != "/transactions" || line["status"] != float64(test.status) ||
+			line["remote"] != "192.0.2.1:1234" || line["duration"] == nil {
+			t.Errorf("access log %v, want status %d at %s", line, test.status, test.level)
+		}
+		if line["bytes"] != float64(w.Body.Len()) {
+			t.Errorf("access log bytes %v, response of %d bytes", line["bytes"], w.Body.Len())
+		}
+	}
+}
+
+func TestDebugState(t *testing.T) {
+	w := httptest.NewRecorder()
+	debugStateHandler(w, httptest.NewRequest("GET", "/debug/state", nil))
+	if content := w.Header().Get("Content-Type"); content != "application/json; charset=utf-8" {
+		t.Fatalf("Content-Type %q", content)
+	}
+	var state struct {
+		Chain        []json.RawMessage `json:"chain"`
+		Transactions []json.RawMessage `json:"current_transactions"`
+		Nodes        []string          `json:"nodes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Chain) != blockChain.Height()+1 {
+		t.Fatalf("state has %d blocks, chain %d", len(state.Chain), blockChain.Height()+1)
+	}
+}
@@ -3,74 +3,205 @@ package main
 import (
 	"encoding/json"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
+
 	"blockchain"
+	"blockchain/nodeapi"
 
 	"github.com/gorilla/mux"
 )
 
-var blockChain = blockchain.NewBlockChain()
+var logger = initLogger()
+
+var blockChain = newBlockChain()
+
 //var nodeIdentifire = uuid.Must(uuid.NewV4()).String()
 
+type acceptedTransaction struct {
+	TxID        string                 `json:"txid"`
+	Transaction blockchain.Transaction `json:"transaction"`
+}
+
+type consensusResult struct {
+	Replaced bool `json:"replaced"`
+	Height   int  `json:"height"`
+}
+
 func createTransactionHandler(w http.ResponseWriter, req *http.Request) {
 	decoder := json.NewDecoder(req.Body)
 	var transaction blockchain.Transaction
 	if err := decoder.Decode(&transaction); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, err)
+		return
+	}
+	if err := blockChain.AddTransaction(req.Context(), &transaction); err != nil {
+		writeTransactionError(w, err)
 		return
 	}
-	blockChain.AddTransaction(&transaction)
-	w.WriteHeader(http.StatusCreated)
-	blockChain.PrintDump()
+	txid := transaction.Hash()
+	w.Header().Set("Location", "/transactions/"+txid)
+	writeJSON(w, http.StatusCreated, acceptedTransaction{TxID: txid, Transaction: transaction})
 }
 
 func getMineHandler(w http.ResponseWriter, req *http.Request) {
-	timestamp := time.Now().Unix()
-	nonce := blockChain.ProofOfWork(timestamp)
-	block := blockChain.AddBlock(timestamp, nonce)
-	if block == nil {
-		w.WriteHeader(http.StatusBadRequest)
+	block, err := blockChain.Mine(req.Context(), time.Now().Unix())
+	if err != nil {
+		writeMineError(w, err)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	if err := json.NewEncoder(w).Encode(block); err != nil {
-		log.Println("Error:", err)
-	}
-	blockChain.PrintDump()
+	writeJSON(w, http.StatusOK, block)
 }
 
 func getChainsHandler(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, blockChain.Blocks())
+}
+
+func reindexHandler(w http.ResponseWriter, req *http.Request) {
+	if err := blockChain.Reindex(); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	if err := json.NewEncoder(w).Encode(blockChain.Chain); err != nil {
-		log.Println("Error:", err)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Warn("writing response failed", "error", err)
 	}
-	blockChain.PrintDump()
 }
 
 func registerNodesHandler(w http.ResponseWriter, req *http.Request) {
 	decoder := json.NewDecoder(req.Body)
 	var nodes []string
 	if err := decoder.Decode(&nodes); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
+	for _, node := range nodes {
+		if err := blockchain.ValidateNode(node); err != nil {
+			writeError(w, http.StatusBadRequest, errInvalidRequest, err.Error(), map[string]string{"node": node})
+			return
+		}
+	}
 	for _, node := range nodes {
 		blockChain.AddNode(node)
 	}
-	w.WriteHeader(http.StatusCreated)
-	blockChain.PrintDump()
+	writeJSON(w, http.StatusCreated, blockChain.Peers())
 }
 
 func consensusNodesHandler(w http.ResponseWriter, req *http.Request) {
-	replaced := blockChain.ResolveConflicts()
-	if replaced {
-		w.WriteHeader(http.StatusOK)
-	} else {
-		w.WriteHeader(http.StatusBadRequest)
+	replaced := blockChain.ResolveConflicts(req.Context())
+	writeJSON(w, http.StatusOK, consensusResult{Replaced: replaced, Height: blockChain.Height()})
+}
+
+func initLogger() *slog.Logger {
+	logger := newLogger()
+	slog.SetDefault(logger)
+	return logger
+}
+
+func newBlockChain() *blockchain.BlockChain {
+	blockChain, err := openBlockChain(newConsensusEngine(), newStore())
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	blockChain.SetLogger(logger)
+	blockChain.SetPeerTransport(peerTransport)
+	// Snapshots are off by default: taking one copies the index while
+	// the chain is locked.
+	interval := 0
+	if s := os.Getenv("SNAPSHOT_INTERVAL"); s != "" {
+		var err error
+		interval, err = strconv.Atoi(s)
+		if err != nil {
+			log.Fatal("Error: ", err)
+		}
+		blockChain.SetSnapshotInterval(interval)
+	}
+	if s := os.Getenv("PRUNE_DEPTH"); s != "" {
+		depth, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal("Error: ", err)
+		}
+		if interval <= 0 {
+			log.Fatal("Error: pruning requires snapshots, set SNAPSHOT_INTERVAL")
+		}
+		blockChain.SetPruneDepth(depth)
+	}
+	return blockChain
+}
+
+// openBlockChain bootstraps an empty store from BOOTSTRAP_SNAPSHOT, a file
+// or URL, when it is set; SNAPSHOT_HASH is the state hash it must have.
+func openBlockChain(engine blockchain.ConsensusEngine, store blockchain.Store) (*blockchain.BlockChain, error) {
+	source := os.Getenv("BOOTSTRAP_SNAPSHOT")
+	if source == "" {
+		return blockchain.NewBlockChain(engine, store)
+	}
+	chain, err := store.LoadChain()
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) > 0 {
+		return blockchain.NewBlockChain(engine, store)
+	}
+	snapshot, err := loadSnapshot(source)
+	if err != nil {
+		return nil, err
+	}
+	blockChain, err := blockchain.Bootstrap(engine, store, snapshot, os.Getenv("SNAPSHOT_HASH"))
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("bootstrapped from snapshot", "height", snapshot.Height, "state_hash", snapshot.StateHash)
+	return blockChain, nil
+}
+
+func newStore() blockchain.Store {
+	dir := os.Getenv("DATA_DIR")
+	if dir == "" {
+		return blockchain.NewMemoryStore()
+	}
+	store, err := blockchain.NewFileStore(dir)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	return store
+}
+
+func newConsensusEngine() blockchain.ConsensusEngine {
+	engine, err := blockchain.ConsensusEngineFromEnv()
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	return engine
+}
+
+// serveNodeAPI serves the node API on GRPC_PORT with the same API keys,
+// route policy and quotas as the HTTP API.
+func serveNodeAPI(auth *authenticator, limiter *rateLimiter) {
+	port := os.Getenv("GRPC_PORT")
+	if port == "" {
+		return
+	}
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatal("Error: ", err)
 	}
-	blockChain.PrintDump()
+	server := nodeapi.NewServer(blockChain)
+	server.Intercept(limiter.interceptor)
+	server.Intercept(auth.interceptor)
+	go func() {
+		logger.Error("node API server stopped", "error", server.Serve(listener))
+	}()
 }
 
 func listenAddress() string {
@@ -83,10 +214,45 @@ func listenAddress() string {
 
 func init() {
 	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+	router.HandleFunc("/openapi.yaml", openAPIHandler).Methods("GET")
 	router.HandleFunc("/transactions", createTransactionHandler).Methods("POST")
+	router.HandleFunc("/transactions/relay", relayTransactionHandler).Methods("POST")
+	router.HandleFunc("/blocks/compact", compactBlockHandler).Methods("POST")
 	router.HandleFunc("/mine", getMineHandler).Methods("POST")
 	router.HandleFunc("/chains", getChainsHandler).Methods("GET")
 	router.HandleFunc("/nodes", registerNodesHandler).Methods("POST")
+	router.HandleFunc("/nodes", listNodesHandler).Methods("GET")
 	router.HandleFunc("/nodes/resolve", consensusNodesHandler).Methods("GET")
-	http.Handle("/", router)
+	router.HandleFunc("/p2p/peers", listP2PPeersHandler).Methods("GET")
+	router.HandleFunc("/admin/reindex", reindexHandler).Methods("POST")
+	router.HandleFunc("/admin/snapshot", createSnapshotHandler).Methods("POST")
+	router.HandleFunc("/snapshot", getSnapshotHandler).Methods("GET")
+	router.HandleFunc("/events", eventsHandler).Methods("GET")
+	router.HandleFunc("/rpc", rpcHandler).Methods("POST")
+	router.HandleFunc("/webhooks", createWebhookHandler).Methods("POST")
+	router.HandleFunc("/webhooks", listWebhooksHandler).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", deleteWebhookHandler).Methods("DELETE")
+	router.HandleFunc("/blocks", listBlocksHandler).Methods("GET")
+	router.HandleFunc("/blocks/{height:[0-9]+}", getBlockHandler).Methods("GET")
+	router.HandleFunc("/blocks/hash/{hash}", getBlockByHashHandler).Methods("GET")
+	router.HandleFunc("/headers", listHeadersHandler).Methods("GET")
+	router.HandleFunc("/transactions/{txid}", getTransactionHandler).Methods("GET")
+	router.HandleFunc("/transactions/{txid}/proof", getTransactionProofHandler).Methods("GET")
+	router.HandleFunc("/addresses/{addr}/transactions", getAddressTransactionsHandler).Methods("GET")
+	router.HandleFunc("/debug/state", debugStateHandler).Methods("GET")
+	router.HandleFunc("/metrics", metricsHandler).Methods("GET")
+	router.HandleFunc("/healthz", healthzHandler).Methods("GET")
+	router.HandleFunc("/readyz", readyzHandler).Methods("GET")
+	router.HandleFunc("/status", statusHandler).Methods("GET")
+	auth := loadAuthenticator()
+	limiter := loadRateLimiter(auth)
+	router.Use(newTracingMiddleware(), newMetricsMiddleware(), limiter.middleware, auth.middleware)
+	http.Handle("/", withRequestLogging(router))
+	initTracing()
+	serveNodeAPI(auth, limiter)
+	serveP2P()
+	startDiscovery()
+	go watchPeerHeights()
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"blockchain/metrics"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	httpRequestDuration = metrics.NewHistogramVec(
+		"http_request_duration_seconds",
+		"Latency of HTTP requests by route.",
+		metrics.DefBuckets,
+		"method", "route", "status")
+
+	_ = metrics.NewGaugeFunc(
+		"blockchain_height",
+		"Height of the chain tip.",
+		func() float64 { return float64(blockChain.Height()) })
+	_ = metrics.NewGaugeFunc(
+		"blockchain_mempool_size",
+		"Number of transactions waiting in the pool.",
+		func() float64 { return float64(len(blockChain.PendingTransactions())) })
+	_ = metrics.NewGaugeFunc(
+		"blockchain_peers",
+		"Number of known peers.",
+		func() float64 { return float64(len(blockChain.Peers())) })
+)
+
+// newMetricsMiddleware records request latency labelled with the route
+// template, so that /blocks/1 and /blocks/2 share a series.
+func newMetricsMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, req)
+
+			httpRequestDuration.WithLabelValues(req.Method, routeTemplate(req), strconv.Itoa(recorder.status)).
+				Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, req *http.Request) {
+	metrics.Handler().ServeHTTP(w, req)
+}
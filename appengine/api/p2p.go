@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"blockchain"
+	"blockchain/p2p"
+)
+
+// p2pNode is nil unless P2P_PORT is set.
+var p2pNode *p2p.Node
+
+// identity is the node key. It authenticates the node on the peer protocol
+// and signs its HTTP requests to peers, which give the peer role to the node
+// IDs in their PEER_NODE_IDS.
+var identity = newIdentity()
+
+// serveP2P runs the native peer protocol on P2P_PORT and keeps connections
+// to the peers listed in P2P_PEERS, each a host:port or id@host:port to pin
+// the peer's node ID. Connections use mutual TLS with the node identity; when
+// P2P_ALLOWED_PEERS lists node IDs, no other peer is accepted. At most
+// P2P_MAX_INBOUND peers may connect in at a time.
+func serveP2P() {
+	port := os.Getenv("P2P_PORT")
+	if port == "" {
+		return
+	}
+	config := p2p.Config{
+		NetworkID:    networkID,
+		UserAgent:    "blockchain/" + version,
+		Identity:     identity,
+		AllowedPeers: blockchain.SplitList(os.Getenv("P2P_ALLOWED_PEERS")),
+	}
+	if s := os.Getenv("P2P_MAX_INBOUND"); s != "" {
+		var err error
+		if config.MaxInbound, err = strconv.Atoi(s); err != nil {
+			log.Fatal("Error: ", err)
+		}
+	}
+	p2pNode = p2p.NewNode(blockChain, config)
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	go func() {
+		logger.Error("p2p server stopped", "error", p2pNode.Serve(listener))
+	}()
+	for _, addr := range blockchain.SplitList(os.Getenv("P2P_PEERS")) {
+		id, _, err := p2p.ParseAddr(addr)
+		if err != nil {
+			log.Fatal("Error: ", err)
+		}
+		if id == "" {
+			logger.Warn("peer identity not pinned", "addr", addr)
+		}
+		p2pNode.ConnectPersistent(addr)
+	}
+	logger.Info("p2p listening", "port", port, "node_id", p2pNode.ID())
+}
+
+// newIdentity reads the node key from P2P_KEY_FILE, by default node.key in
+// DATA_DIR. Without either the node gets a new identity on every start,
+// which peers pinning or listing its node ID no longer accept.
+func newIdentity() *p2p.Identity {
+	path := os.Getenv("P2P_KEY_FILE")
+	if path == "" && os.Getenv("DATA_DIR") != "" {
+		path = filepath.Join(os.Getenv("DATA_DIR"), "node.key")
+	}
+	var identity *p2p.Identity
+	var err error
+	if path == "" {
+		identity, err = p2p.NewIdentity()
+		if err == nil {
+			logger.Warn("node identity is not persisted, set DATA_DIR or P2P_KEY_FILE to keep it across restarts",
+				"node_id", identity.ID())
+		}
+	} else {
+		identity, err = p2p.LoadIdentity(path)
+	}
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	return identity
+}
+
+func listP2PPeersHandler(w http.ResponseWriter, req *http.Request) {
+	peers := []p2p.PeerInfo{}
+	if p2pNode != nil {
+		peers = p2pNode.Peers()
+	}
+	writeJSON(w, http.StatusOK, peers)
+}
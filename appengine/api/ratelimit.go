@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"blockchain/nodeapi"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultMaxBodySize = 64 << 10
+	bucketIdleTimeout  = 10 * time.Minute
+)
+
+type quota struct {
+	rate        float64 // tokens per second
+	burst       float64
+	maxBodySize int64
+}
+
+// failedAuthQuota limits requests with an invalid API key per client IP, on
+// top of the other quotas, to slow down guessing keys.
+var failedAuthQuota = quota{rate: 1.0 / 6, burst: 10}
+
+// defaultRouteQuotas overrides the global quota for expensive or abusable
+// routes, keyed like defaultRoutePolicy.
+var defaultRouteQuotas = map[string]quota{
+	"POST /transactions":       {rate: 2, burst: 10, maxBodySize: 4 << 10},
+	"POST /transactions/relay": {rate: 50, burst: 200, maxBodySize: 4 << 10},
+	"POST /blocks/compact":     {rate: 2, burst: 10, maxBodySize: 4 << 20},
+	"POST /mine":               {rate: 0.2, burst: 1},
+	"POST /rpc":                {rate: 5, burst: 20, maxBodySize: 1 << 20},
+	"GET /nodes":               {rate: 1, burst: 5},
+	"GET /nodes/resolve":       {rate: 0.2, burst: 2},
+	"GET /chains":              {rate: 1, burst: 5},
+	"GET /snapshot":            {rate: 0.1, burst: 2},
+	"GET /events":              {rate: 0.5, burst: 5},
+	// Node API calls on GRPC_PORT, by method path.
+	"POST /nodeapi.Node/SubmitTransaction": {rate: 2, burst: 10, maxBodySize: 4 << 10},
+	"POST /nodeapi.Node/StreamBlocks":      {rate: 0.5, burst: 5},
+	"POST /nodeapi.Node/StreamMempool":     {rate: 0.5, burst: 5},
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take removes one token and returns how long to wait when none is left.
+func (b *bucket) take(q quota, now time.Time) (bool, time.Duration) {
+	b.tokens = math.Min(q.burst, b.tokens+now.Sub(b.last).Seconds()*q.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if q.rate <= 0 {
+		return false, time.Hour
+	}
+	return false, time.Duration((1 - b.tokens) / q.rate * float64(time.Second))
+}
+
+type rateLimiter struct {
+	mu        sync.Mutex
+	auth      *authenticator
+	global    quota
+	routes    map[string]quota
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	// trustedProxies is the number of proxies in front of the node, each
+	// appending the address it got the request from to X-Forwarded-For.
+	trustedProxies int
+}
+
+func newRateLimiter(auth *authenticator, global quota, trustedProxies int) *rateLimiter {
+	limiter := &rateLimiter{
+		auth:           auth,
+		global:         global,
+		routes:         make(map[string]quota),
+		buckets:        make(map[string]*bucket),
+		trustedProxies: trustedProxies,
+	}
+	for route, q := range defaultRouteQuotas {
+		if q.maxBodySize == 0 {
+			q.maxBodySize = global.maxBodySize
+		}
+		limiter.routes[route] = q
+	}
+	return limiter
+}
+
+// clientKey identifies the caller p by API key when its credentials are
+// valid and by IP otherwise. The limiter runs before authorization, so that
+// requests refused with 401 or 403 are limited too.
+func (limiter *rateLimiter) clientKey(req *http.Request, p principal, valid bool) string {
+	if valid && p != limiter.auth.anonymous {
+		return "key:" + p.name
+	}
+	return "ip:" + limiter.clientIP(req)
+}
+
+// clientIP is the address the request came from. Behind trusted proxies it
+// is the entry the outermost proxy appended to X-Forwarded-For; the entries
+// before it come from the client and may be anything.
+func (limiter *rateLimiter) clientIP(req *http.Request) string {
+	if limiter.trustedProxies > 0 {
+		var hops []string
+		for _, header := range req.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(header, ",")...)
+		}
+		if len(hops) >= limiter.trustedProxies {
+			return strings.TrimSpace(hops[len(hops)-limiter.trustedProxies])
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return host
+}
+
+func (limiter *rateLimiter) routeQuota(req *http.Request) (string, quota) {
+	if route := mux.CurrentRoute(req); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return limiter.quotaOf(req.Method + " " + template)
+		}
+	}
+	return "", limiter.global
+}
+
+func (limiter *rateLimiter) quotaOf(route string) (string, quota) {
+	if q, ok := limiter.routes[route]; ok {
+		return route, q
+	}
+	return "", limiter.global
+}
+
+func (limiter *rateLimiter) allow(key string, q quota) (bool, time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	now := time.Now()
+	if now.Sub(limiter.lastSweep) > bucketIdleTimeout {
+		for k, b := range limiter.buckets {
+			if now.Sub(b.last) > bucketIdleTimeout {
+				delete(limiter.buckets, k)
+			}
+		}
+		limiter.lastSweep = now
+	}
+	b, ok := limiter.buckets[key]
+	if !ok {
+		b = &bucket{tokens: q.burst, last: now}
+		limiter.buckets[key] = b
+	}
+	return b.take(q, now)
+}
+
+// take charges a request of p on route to its client and returns how long
+// to wait when it is over a quota. Every request counts against the client's
+// global quota, and requests on listed routes against the route's too.
+func (limiter *rateLimiter) take(req *http.Request, p principal, valid bool, route string, q quota) (bool, time.Duration) {
+	client := limiter.clientKey(req, p, valid)
+	if !valid {
+		if ok, wait := limiter.allow(client+" auth", failedAuthQuota); !ok {
+			return false, wait
+		}
+	}
+	if route != "" {
+		if ok, wait := limiter.allow(client+" "+route, q); !ok {
+			return false, wait
+		}
+	}
+	return limiter.allow(client, limiter.global)
+}
+
+func (limiter *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req, p, valid := limiter.auth.authenticated(req, limiter.auth.authenticate)
+		route, q := limiter.routeQuota(req)
+		if ok, wait := limiter.take(req, p, valid, route, q); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, errRateLimited, "rate limit exceeded", nil)
+			return
+		}
+		if req.Body != nil {
+			req.Body = http.MaxBytesReader(w, req.Body, q.maxBodySize)
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// interceptor applies the quotas and body size limits to node API calls.
+func (limiter *rateLimiter) interceptor(w http.ResponseWriter, req *http.Request) (*http.Request, error) {
+	req, p, valid := limiter.auth.authenticated(req, limiter.auth.authenticateKey)
+	route, q := limiter.quotaOf(req.Method + " " + req.URL.Path)
+	if ok, wait := limiter.take(req, p, valid, route, q); !ok {
+		return nil, &nodeapi.Error{
+			Code:    nodeapi.CodeResourceExhausted,
+			Message: fmt.Sprintf("rate limit exceeded, retry after %ds", int(math.Ceil(wait.Seconds()))),
+		}
+	}
+	req.Body = http.MaxBytesReader(w, req.Body, q.maxBodySize)
+	return req, nil
+}
+
+func envFloat(key string, def float64) float64 {
+	s := os.Getenv(key)
+	if s == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	return f
+}
+
+// loadRateLimiter reads the global quota and TRUST_PROXY, the number of
+// proxies in front of the node ("true" for one).
+func loadRateLimiter(auth *authenticator) *rateLimiter {
+	global := quota{
+		rate:        envFloat("RATE_LIMIT", 10),
+		burst:       envFloat("RATE_BURST", 20),
+		maxBodySize: int64(envFloat("MAX_BODY_SIZE", defaultMaxBodySize)),
+	}
+	trustedProxies := 0
+	switch s := os.Getenv("TRUST_PROXY"); s {
+	case "", "false":
+	case "true":
+		trustedProxies = 1
+	default:
+		var err error
+		if trustedProxies, err = strconv.Atoi(s); err != nil {
+			log.Fatal("Error: ", err)
+		}
+	}
+	return newRateLimiter(auth, global, trustedProxies)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func newTestAuthenticator(t *testing.T, config *authConfig) *authenticator {
+	if config.AnonymousRole == "" {
+		config.AnonymousRole = "reader"
+	}
+	auth, err := newAuthenticator(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
+
+// newTestRouter serves GET /blocks and POST /mine behind the limiter and the
+// authenticator, like the API.
+func newTestRouter(limiter *rateLimiter) http.Handler {
+	ok := func(w http.ResponseWriter, req *http.Request) {}
+	router := mux.NewRouter()
+	router.HandleFunc("/blocks", ok).Methods("GET")
+	router.HandleFunc("/mine", ok).Methods("POST")
+	router.Use(limiter.middleware, limiter.auth.middleware)
+	return router
+}
+
+func send(handler http.Handler, method string, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestBucketRefills(t *testing.T) {
+	q := quota{rate: 2, burst: 3}
+	start := time.Now()
+	b := &bucket{tokens: q.burst, last: start}
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.take(q, start); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, wait := b.take(q, start)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("take on an empty bucket = %v, %v, want false, 500ms", ok, wait)
+	}
+	if ok, _ := b.take(q, start.Add(500*time.Millisecond)); !ok {
+		t.Fatal("bucket did not refill")
+	}
+	// Refilling stops at the burst.
+	b.take(q, start.Add(time.Hour))
+	if b.tokens != q.burst-1 {
+		t.Fatalf("tokens = %v after an hour, want %v", b.tokens, q.burst-1)
+	}
+}
+
+func TestRateLimitedRequestGets429(t *testing.T) {
+	limiter := newRateLimiter(newTestAuthenticator(t, &authConfig{}), quota{rate: 0.5, burst: 1, maxBodySize: 1024}, 0)
+	handler := newTestRouter(limiter)
+	if w := send(handler, "GET", "/blocks", nil); w.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	w := send(handler, "GET", "/blocks", nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("second request: status %d, Retry-After %q, want 429 and 2", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestForwardedForUsesTrustedHop(t *testing.T) {
+	global := quota{rate: 0.001, burst: 1, maxBodySize: 1024}
+	for _, test := range []struct {
+		proxies  int
+		first    string
+		second   string
+		distinct bool
+	}{
+		// The entries a client sends itself are ignored.
+		{1, "1.1.1.1, 203.0.113.7", "2.2.2.2, 203.0.113.7", false},
+		{1, "203.0.113.7", "203.0.113.8", true},
+		{2, "1.1.1.1, 203.0.113.7, 10.0.0.1", "2.2.2.2, 203.0.113.7, 10.0.0.2", false},
+		// Without trusted proxies the header is not looked at.
+		{0, "203.0.113.7", "203.0.113.8", false},
+	} {
+		limiter := newRateLimiter(newTestAuthenticator(t, &authConfig{}), global, test.proxies)
+		handler := newTestRouter(limiter)
+		send(handler, "GET", "/blocks", map[string]string{"X-Forwarded-For": test.first})
+		w := send(handler, "GET", "/blocks", map[string]string{"X-Forwarded-For": test.second})
+		if distinct := w.Code == http.StatusOK; distinct != test.distinct {
+			t.Errorf("%d proxies, %q then %q: second status %d", test.proxies, test.first, test.second, w.Code)
+		}
+	}
+}
+
+func TestQuotasPerKeyAndRoute(t *testing.T) {
+	auth := newTestAuthenticator(t, &authConfig{Keys: []apiKey{
+		{Name: "alice", Key: "alice-key", Role: "admin"},
+		{Name: "bob", Key: "bob-key", Role: "admin"},
+	}})
+	limiter := newRateLimiter(auth, quota{rate: 100, burst: 100, maxBodySize: 1024}, 0)
+	handler := newTestRouter(limiter)
+	alice := map[string]string{"X-Api-Key": "alice-key"}
+
+	// POST /mine allows a burst of one per caller.
+	if w := send(handler, "POST", "/mine", alice); w.Code != http.StatusOK {
+		t.Fatalf("first mine: status %d", w.Code)
+	}
+	if w := send(handler, "POST", "/mine", alice); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second mine: status %d, want 429", w.Code)
+	}
+	// Other routes and other keys from the same IP have their own buckets.
+	if w := send(handler, "GET", "/blocks", alice); w.Code != http.StatusOK {
+		t.Fatalf("blocks after mine: status %d", w.Code)
+	}
+	if w := send(handler, "POST", "/mine", map[string]string{"X-Api-Key": "bob-key"}); w.Code != http.StatusOK {
+		t.Fatalf("mine with another key: status %d", w.Code)
+	}
+	// An anonymous caller is limited by IP and refused by role.
+	if w := send(handler, "POST", "/mine", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous mine: status %d, want 401", w.Code)
+	}
+}
+
+func TestInvalidKeysLimitedPerIP(t *testing.T) {
+	limiter := newRateLimiter(newTestAuthenticator(t, &authConfig{}), quota{rate: 100, burst: 100, maxBodySize: 1024}, 0)
+	handler := newTestRouter(limiter)
+	for i := 0; i < int(failedAuthQuota.burst); i++ {
+		if w := send(handler, "GET", "/blocks", map[string]string{"X-Api-Key": "guess"}); w.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: status %d, want 401", i+1, w.Code)
+		}
+	}
+	if w := send(handler, "GET", "/blocks", map[string]string{"X-Api-Key": "guess"}); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("guess past the limit: status %d, want 429", w.Code)
+	}
+}
+
+func TestAuthenticatesOnce(t *testing.T) {
+	auth := newTestAuthenticator(t, &authConfig{})
+	calls := 0
+	count := func(req *http.Request) (principal, bool) {
+		calls++
+		return auth.authenticate(req)
+	}
+	req := httptest.NewRequest("GET", "/blocks", nil)
+	req, _, _ = auth.authenticated(req, count)
+	auth.authenticated(req, count)
+	if calls != 1 {
+		t.Fatalf("authenticate ran %d times, want 1", calls)
+	}
+}
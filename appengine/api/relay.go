@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+
+	"blockchain"
+	"blockchain/relay"
+)
+
+// relayer announces blocks and transactions signed with the node identity,
+// and with PEER_API_KEY, a key with the peer role on the other nodes, when
+// set.
+var relayer = newRelay()
+
+var orphans = newOrphanResolver(blockChain.ResolveConflicts)
+
+func newRelay() *relay.Relay {
+	relayer := relay.NewRelay(blockChain)
+	relayer.SetAPIKey(os.Getenv("PEER_API_KEY"))
+	relayer.SetTransport(peerTransport)
+	return relayer
+}
+
+type relayedTransaction struct {
+	TxID  string `json:"txid"`
+	Added bool   `json:"added"`
+}
+
+// relayTransactionHandler accepts a transaction from a peer's pool. Unlike
+// POST /transactions it keeps the timestamp, so the txid stays the same.
+func relayTransactionHandler(w http.ResponseWriter, req *http.Request) {
+	var transaction blockchain.Transaction
+	if err := json.NewDecoder(req.Body).Decode(&transaction); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	added, err := blockChain.AddRelayedTransaction(req.Context(), &transaction)
+	if err != nil {
+		writeTransactionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, relayedTransaction{TxID: transaction.Hash(), Added: added})
+}
+
+// compactBlockHandler rebuilds a block a peer announced. Transactions that
+// are not in the pool are asked for in the response.
+func compactBlockHandler(w http.ResponseWriter, req *http.Request) {
+	var compact blockchain.CompactBlock
+	if err := json.NewDecoder(req.Body).Decode(&compact); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	result := relay.Result{Hash: compact.Header.Hash}
+	missing, err := blockChain.AcceptCompactBlock(req.Context(), &compact)
+	switch err {
+	case nil:
+		result.Status = relay.StatusAccepted
+	case blockchain.ErrKnownBlock:
+		result.Status = relay.StatusKnown
+	case blockchain.ErrMissingTransactions:
+		result.Status = relay.StatusMissing
+		result.Missing = missing
+	case blockchain.ErrOrphanBlock:
+		result.Status = relay.StatusOrphan
+		caller, _ := req.Context().Value(principalKey{}).(principal)
+		orphans.resolveInBackground(caller.name, compact.Header.Hash)
+	default:
+		writeBadRequest(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// orphanResolver catches up with peers after announced blocks did not
+// extend the tip. Each peer has at most one resolve in flight, so that a
+// burst of announcements from one peer starts one resolve and cannot hold
+// back the orphans of the others, and a block a resolve is already looking
+// for does not start another.
+type orphanResolver struct {
+	mu        sync.Mutex
+	resolve   func(ctx context.Context) bool
+	resolving map[string]bool
+	hashes    map[string]bool
+}
+
+func newOrphanResolver(resolve func(ctx context.Context) bool) *orphanResolver {
+	return &orphanResolver{
+		resolve:   resolve,
+		resolving: make(map[string]bool),
+		hashes:    make(map[string]bool),
+	}
+}
+
+// resolveInBackground resolves the chain for the orphan block hash announced
+// by peer, reporting whether a resolve was started.
+func (resolver *orphanResolver) resolveInBackground(peer string, hash string) bool {
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	if resolver.resolving[peer] || resolver.hashes[hash] {
+		return false
+	}
+	resolver.resolving[peer] = true
+	resolver.hashes[hash] = true
+	go func() {
+		resolver.resolve(context.Background())
+		resolver.mu.Lock()
+		delete(resolver.resolving, peer)
+		delete(resolver.hashes, hash)
+		resolver.mu.Unlock()
+	}()
+	return true
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestOrphanResolverOnePerPeerAndBlock(t *testing.T) {
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	resolver := newOrphanResolver(func(ctx context.Context) bool {
+		started <- struct{}{}
+		<-release
+		return false
+	})
+	for _, test := range []struct {
+		peer  string
+		hash  string
+		start bool
+	}{
+		{"node:a", "h1", true},
+		// Another orphan from the same peer waits for its resolve.
+		{"node:a", "h2", false},
+		// The same orphan from another peer is already being resolved.
+		{"node:b", "h1", false},
+		{"node:b", "h2", true},
+	} {
+		if start := resolver.resolveInBackground(test.peer, test.hash); start != test.start {
+			t.Fatalf("%s announcing %s started a resolve: %v, want %v", test.peer, test.hash, start, test.start)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		<-started
+	}
+	close(release)
+
+	// Once done, the peer may start another.
+	deadline := time.Now().Add(5 * time.Second)
+	for !resolver.resolveInBackground("node:a", "h1") {
+		if time.Now().After(deadline) {
+			t.Fatal("no resolve started after the first ended")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	<-started
+}
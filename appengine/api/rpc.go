@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"blockchain"
+	"blockchain/trace"
+)
+
+const jsonRPCVersion = "2.0"
+
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcNotFound       = -32001
+	rpcMiningFailed   = -32002
+	rpcUnauthorized   = -32003
+	rpcPoolFull       = -32004
+	rpcDuplicate      = -32005
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcMethod struct {
+	role role
+	call func(ctx context.Context, params json.RawMessage) (interface{}, *rpcError)
+}
+
+var rpcMethods map[string]rpcMethod
+
+func init() {
+	rpcMethods = map[string]rpcMethod{
+		"getblock":        {roleReader, rpcGetBlock},
+		"getblockcount":   {roleReader, rpcGetBlockCount},
+		"sendtransaction": {roleSubmitter, rpcSendTransaction},
+		"getbalance":      {roleReader, rpcGetBalance},
+		"getmempool":      {roleReader, rpcGetMempool},
+		"addpeer":         {roleAdmin, rpcAddPeer},
+		"mine":            {roleAdmin, rpcMine},
+	}
+}
+
+func invalidParams(message string) *rpcError {
+	return &rpcError{Code: rpcInvalidParams, Message: message}
+}
+
+// parseParams decodes positional params into targets in order. A single
+// target may also be given as a by-name params object.
+func parseParams(params json.RawMessage, targets ...interface{}) *rpcError {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		if len(targets) == 0 {
+			return nil
+		}
+		return invalidParams("missing params")
+	}
+	if params[0] == '{' && len(targets) == 1 {
+		if err := json.Unmarshal(params, targets[0]); err != nil {
+			return invalidParams(err.Error())
+		}
+		return nil
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(params, &list); err != nil {
+		return invalidParams("params must be an array")
+	}
+	if len(list) != len(targets) {
+		return invalidParams("expected " + strconv.Itoa(len(targets)) + " params")
+	}
+	for i, raw := range list {
+		if err := json.Unmarshal(raw, targets[i]); err != nil {
+			return invalidParams(err.Error())
+		}
+	}
+	return nil
+}
+
+func rpcGetBlock(ctx context.Context, params json.RawMessage) (interface{}, *rpcError) {
+	var id interface{}
+	if err := parseParams(params, &id); err != nil {
+		return nil, err
+	}
+	var block *blockchain.Block
+	var ok bool
+	switch v := id.(type) {
+	case float64:
+		block, ok = blockChain.BlockByHeight(int(v))
+	case string:
+		block, ok = blockChain.BlockByHash(v)
+	default:
+		return nil, invalidParams("block height or hash expected")
+	}
+	if !ok {
+		return nil, &rpcError{Code: rpcNotFound, Message: "block not found"}
+	}
+	return block, nil
+}
+
+func rpcGetBlockCount(ctx context.Context, params json.RawMessage) (interface{}, *rpcError) {
+	if err := parseParams(params); err != nil {
+		return nil, err
+	}
+	return blockChain.Height() + 1, nil
+}
+
+func rpcSendTransaction(ctx context.Context, params json.RawMessage) (interface{}, *rpcError) {
+	var transaction blockchain.Transaction
+	if err := parseParams(params, &transaction); err != nil {
+		return nil, err
+	}
+	if err := blockChain.AddTransaction(ctx, &transaction); err != nil {
+		switch err {
+		case blockchain.ErrPoolFull:
+			return nil, &rpcError{Code: rpcPoolFull, Message: err.Error()}
+		case blockchain.ErrDuplicateTransaction:
+			return nil, &rpcError{Code: rpcDuplicate, Message: err.Error()}
+		}
+		return nil, invalidParams(err.Error())
+	}
+	return transaction.Hash(), nil
+}
+
+func rpcGetBalance(ctx context.Context, params json.RawMessage) (interface{}, *rpcError) {
+	var address string
+	if err := parseParams(params, &address); err != nil {
+		return nil, err
+	}
+	return blockChain.Balance(address), nil
+}
+
+func rpcGetMempool(ctx context.Context, params json.RawMessage) (interface{}, *rpcError) {
+	if err := parseParams(params); err != nil {
+		return nil, err
+	}
+	transactions := blockChain.PendingTransactions()
+	if transactions == nil {
+		transactions = []blockchain.Transaction{}
+	}
+	return transactions, nil
+}
+
+func rpcAddPeer(ctx context.Context, params json.RawMessage) (interface{}, *rpcError) {
+	var node string
+	if err := parseParams(params, &node); err != nil {
+		return nil, err
+	}
+	if err := blockchain.ValidateNode(node); err != nil {
+		return nil, invalidParams(err.Error())
+	}
+	blockChain.AddNode(node)
+	return true, nil
+}
+
+func rpcMine(ctx context.Context, params json.RawMessage) (interface{}, *rpcError) {
+	if err := parseParams(params); err != nil {
+		return nil, err
+	}
+	block, err := blockChain.Mine(ctx, time.Now().Unix())
+	if err != nil {
+		return nil, &rpcError{Code: rpcMiningFailed, Message: err.Error()}
+	}
+	return block, nil
+}
+
+func handleRPC(req *http.Request, request *rpcRequest) *rpcResponse {
+	response := &rpcResponse{JSONRPC: jsonRPCVersion, ID: request.ID}
+	if response.ID == nil {
+		response.ID = json.RawMessage("null")
+	}
+	if request.JSONRPC != jsonRPCVersion || request.Method == "" {
+		response.Error = &rpcError{Code: rpcInvalidRequest, Message: "invalid request"}
+		return response
+	}
+	method, ok := rpcMethods[request.Method]
+	if !ok {
+		response.Error = &rpcError{Code: rpcMethodNotFound, Message: "method not found"}
+		return response
+	}
+	if !hasRole(req, method.role) {
+		response.Error = &rpcError{Code: rpcUnauthorized, Message: "requires role " + method.role.String()}
+		return response
+	}
+	ctx, span := trace.Start(req.Context(), "rpc "+request.Method, trace.SpanKindInternal)
+	defer span.End()
+	response.Result, response.Error = method.call(ctx, request.Params)
+	if response.Error != nil {
+		span.RecordError(errors.New(response.Error.Message))
+	}
+	return response
+}
+
+func handleRPCMessage(req *http.Request, raw json.RawMessage) *rpcResponse {
+	var request rpcRequest
+	if err := json.Unmarshal(raw, &request); err != nil {
+		return &rpcResponse{
+			JSONRPC: jsonRPCVersion,
+			Error:   &rpcError{Code: rpcInvalidRequest, Message: "invalid request"},
+			ID:      json.RawMessage("null"),
+		}
+	}
+	response := handleRPC(req, &request)
+	if request.ID == nil {
+		// Notifications get no response.
+		return nil
+	}
+	return response
+}
+
+func rpcHandler(w http.ResponseWriter, req *http.Request) {
+	var body json.RawMessage
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusOK, &rpcResponse{
+			JSONRPC: jsonRPCVersion,
+			Error:   &rpcError{Code: rpcParseError, Message: "parse error"},
+			ID:      json.RawMessage("null"),
+		})
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		if response := handleRPCMessage(req, body); response != nil {
+			writeJSON(w, http.StatusOK, response)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+		writeJSON(w, http.StatusOK, &rpcResponse{
+			JSONRPC: jsonRPCVersion,
+			Error:   &rpcError{Code: rpcInvalidRequest, Message: "invalid request"},
+			ID:      json.RawMessage("null"),
+		})
+		return
+	}
+	var responses []*rpcResponse
+	for _, raw := range batch {
+		if response := handleRPCMessage(req, raw); response != nil {
+			responses = append(responses, response)
+		}
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, responses)
+}
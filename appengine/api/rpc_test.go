@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestRPC serves POST /rpc behind an authenticator with a key per role.
+func newTestRPC(t *testing.T) http.Handler {
+	auth := newTestAuthenticator(t, &authConfig{Keys: []apiKey{
+		{Name: "reader", Key: "reader-key", Role: "reader"},
+		{Name: "submitter", Key: "submitter-key", Role: "submitter"},
+		{Name: "admin", Key: "admin-key", Role: "admin"},
+	}})
+	router := mux.NewRouter()
+	router.HandleFunc("/rpc", rpcHandler).Methods("POST")
+	router.Use(auth.middleware)
+	return router
+}
+
+// callRPC posts body with key and returns the status and the raw answer.
+func callRPC(handler http.Handler, key string, body string) (int, string) {
+	req := httptest.NewRequest("POST", "/rpc", strings.NewReader(body))
+	req.Header.Set("X-Api-Key", key)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w.Code, w.Body.String()
+}
+
+func decodeRPC(t *testing.T, body string) rpcResponse {
+	var response rpcResponse
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		t.Fatalf("response %q: %v", body, err)
+	}
+	return response
+}
+
+func TestRPCErrors(t *testing.T) {
+	handler := newTestRPC(t)
+	for _, test := range []struct {
+		body string
+		code int
+	}{
+		{`{"jsonrpc":"2.0","method":"getblockcount"`, rpcParseError},
+		{`[]`, rpcInvalidRequest},
+		{`{"jsonrpc":"1.0","method":"getblockcount","id":1}`, rpcInvalidRequest},
+		{`{"jsonrpc":"2.0","id":1}`, rpcInvalidRequest},
+		{`{"jsonrpc":"2.0","method":"nosuchmethod","id":1}`, rpcMethodNotFound},
+		{`{"jsonrpc":"2.0","method":"getblock","params":[true],"id":1}`, rpcInvalidParams},
+		{`{"jsonrpc":"2.0","method":"getblock","params":[1000000],"id":1}`, rpcNotFound},
+	} {
+		status, body := callRPC(handler, "reader-key", test.body)
+		response := decodeRPC(t, body)
+		if status != http.StatusOK || response.Error == nil || response.Error.Code != test.code {
+			t.Errorf("%s: status %d, response %s, want error %d", test.body, status, body, test.code)
+		}
+	}
+}
+
+func TestRPCBatch(t *testing.T) {
+	handler := newTestRPC(t)
+	_, body := callRPC(handler, "reader-key", `[
+		{"jsonrpc":"2.0","method":"getblockcount","id":1},
+		{"jsonrpc":"2.0","method":"getblockcount"},
+		{"jsonrpc":"2.0","method":"nosuchmethod","id":"b"},
+		42
+	]`)
+	var responses []rpcResponse
+	if err := json.Unmarshal([]byte(body), &responses); err != nil {
+		t.Fatalf("response %q: %v", body, err)
+	}
+	if len(responses) != 3 {
+		t.Fatalf("%d responses, want 3 without the notification: %s", len(responses), body)
+	}
+	if responses[0].Error != nil || string(responses[0].ID) != "1" {
+		t.Errorf("first response = %s", body)
+	}
+	if responses[1].Error == nil || responses[1].Error.Code != rpcMethodNotFound || string(responses[1].ID) != `"b"` {
+		t.Errorf("second response = %s", body)
+	}
+	if responses[2].Error == nil || responses[2].Error.Code != rpcInvalidRequest || string(responses[2].ID) != "null" {
+		t.Errorf("third response = %s", body)
+	}
+}
+
+func TestRPCNotificationsGetNoResponse(t *testing.T) {
+	handler := newTestRPC(t)
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","method":"getblockcount"}`,
+		`[{"jsonrpc":"2.0","method":"getblockcount"},{"jsonrpc":"2.0","method":"nosuchmethod"}]`,
+	} {
+		if status, answer := callRPC(handler, "reader-key", body); status != http.StatusNoContent || answer != "" {
+			t.Errorf("%s: status %d, response %q, want 204 and nothing", body, status, answer)
+		}
+	}
+}
+
+func TestRPCMethodRoles(t *testing.T) {
+	handler := newTestRPC(t)
+	for _, test := range []struct {
+		key    string
+		method string
+		params string
+		denied bool
+	}{
+		{"reader-key", "getblockcount", "[]", false},
+		{"reader-key", "sendtransaction", `[{"sender":"a","recipient":"b","amount":1}]`, true},
+		{"reader-key", "addpeer", `["http://8.8.8.8"]`, true},
+		{"reader-key", "mine", "[]", true},
+		{"submitter-key", "addpeer", `["not a url"]`, true},
+		{"admin-key", "addpeer", `["not a url"]`, false},
+	} {
+		_, body := callRPC(handler, test.key, `{"jsonrpc":"2.0","method":"`+test.method+`","params":`+test.params+`,"id":1}`)
+		response := decodeRPC(t, body)
+		if denied := response.Error != nil && response.Error.Code == rpcUnauthorized; denied != test.denied {
+			t.Errorf("%s with %s: response %s, denied %v", test.method, test.key, body, test.denied)
+		}
+	}
+}
+
+func TestRPCDuplicateTransaction(t *testing.T) {
+	handler := newTestRPC(t)
+	request := `{"jsonrpc":"2.0","method":"sendtransaction","params":[{"sender":"alice","recipient":"rpc-test","amount":7}],"id":1}`
+	if _, body := callRPC(handler, "submitter-key", request); decodeRPC(t, body).Error != nil {
+		t.Fatalf("first send: %s", body)
+	}
+	_, body := callRPC(handler, "submitter-key", request)
+	if response := decodeRPC(t, body); response.Error == nil || response.Error.Code != rpcDuplicate {
+		t.Fatalf("second send: %s, want error %d", body, rpcDuplicate)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"blockchain"
+)
+
+const (
+	snapshotFetchTimeout = 5 * time.Minute
+	maxSnapshotSize      = 1 << 30
+)
+
+var snapshotClient = &http.Client{Timeout: snapshotFetchTimeout}
+
+type snapshotSummary struct {
+	Height    int    `json:"height"`
+	BlockHash string `json:"block_hash"`
+	StateHash string `json:"state_hash"`
+}
+
+func loadSnapshot(source string) (*blockchain.Snapshot, error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		res, err := snapshotClient.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return nil, fmt.Errorf("http status code: %d", res.StatusCode)
+		}
+		r = res.Body
+	} else {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		r = file
+	}
+	defer r.Close()
+	limited := &io.LimitedReader{R: r, N: maxSnapshotSize + 1}
+	var snapshot blockchain.Snapshot
+	if err := json.NewDecoder(limited).Decode(&snapshot); err != nil {
+		if limited.N == 0 {
+			return nil, fmt.Errorf("snapshot is larger than %d bytes", maxSnapshotSize)
+		}
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// getSnapshotHandler serves the latest saved snapshot for other nodes to
+// bootstrap from.
+func getSnapshotHandler(w http.ResponseWriter, req *http.Request) {
+	snapshot, err := blockChain.LatestSnapshot()
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if snapshot == nil {
+		writeNotFound(w, "no snapshot has been saved")
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+func createSnapshotHandler(w http.ResponseWriter, req *http.Request) {
+	snapshot, err := blockChain.SaveSnapshot()
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, snapshotSummary{
+		Height:    snapshot.Height,
+		BlockHash: snapshot.BlockHash,
+		StateHash: snapshot.StateHash,
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"blockchain/trace"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultOTLPEndpoint = "http://localhost:4318"
+
+// initTracing installs the span exporter named by TRACE_EXPORTER: stdout,
+// or otlp to send to the collector at OTEL_EXPORTER_OTLP_ENDPOINT.
+func initTracing() {
+	switch os.Getenv("TRACE_EXPORTER") {
+	case "":
+	case "stdout":
+		trace.SetExporter(trace.NewWriterExporter(os.Stdout))
+	case "otlp":
+		endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if endpoint == "" {
+			endpoint = defaultOTLPEndpoint
+		}
+		service := os.Getenv("OTEL_SERVICE_NAME")
+		if service == "" {
+			service = "blockchain-node"
+		}
+		trace.SetExporter(trace.NewOTLPExporter(endpoint, service))
+	default:
+		logger.Warn("unknown trace exporter", "exporter", os.Getenv("TRACE_EXPORTER"))
+	}
+}
+
+func routeTemplate(req *http.Request) string {
+	if route := mux.CurrentRoute(req); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unknown"
+}
+
+// newTracingMiddleware starts a server span per request, continuing the
+// trace of a peer that sent a traceparent header.
+func newTracingMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			route := routeTemplate(req)
+			ctx, span := trace.Start(trace.Extract(req.Context(), req.Header), req.Method+" "+route, trace.SpanKindServer,
+				trace.String("http.method", req.Method),
+				trace.String("http.route", route),
+				trace.String("request_id", w.Header().Get(requestIDHeader)))
+			defer span.End()
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, req.WithContext(ctx))
+			span.SetAttributes(trace.Int("http.status_code", recorder.status))
+			if recorder.status >= http.StatusInternalServerError {
+				span.RecordError(errors.New(http.StatusText(recorder.status)))
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"blockchain/webhook"
+
+	"github.com/gorilla/mux"
+)
+
+var webhooks = newWebhookManager()
+
+// newWebhookManager keeps the webhooks in DATA_DIR when it is set.
+// WEBHOOK_ALLOW_PRIVATE=true allows webhook urls on the local network.
+func newWebhookManager() *webhook.Manager {
+	config := webhook.Config{AllowPrivate: os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true"}
+	if dir := os.Getenv("DATA_DIR"); dir != "" {
+		config.Path = filepath.Join(dir, "webhooks.json")
+	}
+	manager, err := webhook.NewManager(blockChain, config)
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	return manager
+}
+
+func createWebhookHandler(w http.ResponseWriter, req *http.Request) {
+	var hook webhook.Webhook
+	if err := json.NewDecoder(req.Body).Decode(&hook); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	hook, err := webhooks.Register(hook)
+	switch {
+	case errors.Is(err, webhook.ErrInvalidURL), errors.Is(err, webhook.ErrMissingAddress),
+		errors.Is(err, webhook.ErrPrivateURL), errors.Is(err, webhook.ErrUnresolvedURL):
+		writeBadRequest(w, err)
+		return
+	case err != nil:
+		writeInternalError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/webhooks/"+hook.ID)
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+func listWebhooksHandler(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, webhooks.List())
+}
+
+func deleteWebhookHandler(w http.ResponseWriter, req *http.Request) {
+	err := webhooks.Unregister(mux.Vars(req)["id"])
+	switch {
+	case err == webhook.ErrNotFound:
+		writeNotFound(w, err.Error())
+		return
+	case err != nil:
+		writeInternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
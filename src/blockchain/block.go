@@ -2,10 +2,13 @@ package blockchain
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 )
 
+var ErrMalformedHash = errors.New("hash is not 32 hex encoded bytes")
+
 type Block struct {
 	Height       int           `json:"height"`
 	Timestamp    int64         `json:"timestamp"`
@@ -13,55 +16,96 @@ type Block struct {
 	Hash         string        `json:"hash"`
 	PreviousHash string        `json:"previous_hash"`
 	MerkleHash   string        `json:"merkle_hash"`
+	Signer       string        `json:"signer,omitempty"`
+	Signature    string        `json:"signature,omitempty"`
 	Transactions []Transaction `json:"transactions"`
+	// Pruned marks a header whose transactions are not stored. The state
+	// they produced comes from a snapshot.
+	Pruned bool `json:"pruned,omitempty"`
 }
 
-const BlockDifficulty = 5
+const nonceSize = 8
 
-func NewBlock(timestamp int64, nonce int, previousHash string, merkleHash string, transactions []Transaction) *Block {
+func NewBlock(height int, timestamp int64, previousHash string, transactions []Transaction) (*Block, error) {
 	block := &Block{
+		Height:       height,
 		Timestamp:    timestamp,
-		Nonce:        nonce,
 		PreviousHash: previousHash,
-		MerkleHash:   merkleHash,
+		MerkleHash:   CalcMerkleHash(transactions),
 		Transactions: transactions,
 	}
-	if !block.IsValid() {
-		return nil
+	hash, err := block.CalcHash()
+	if err != nil {
+		return nil, err
 	}
+	block.Hash = hash
+	return block, nil
+}
 
-	return block
+// Header returns a copy of block without its transactions.
+func (block *Block) Header() Block {
+	header := *block
+	header.Transactions = nil
+	header.Pruned = true
+	return header
 }
 
-func (block *Block) hash() string {
-	hashSeed := struct {
-		Timestamp    int64  `json:"timestamp"`
-		Nonce        int    `json:"nonce"`
-		PreviousHash string `json:"previous_hash"`
-		MerkleHash   string `json:"merkle_hash"`
-	}{
-		Timestamp:    block.Timestamp,
-		Nonce:        block.Nonce,
-		PreviousHash: block.PreviousHash,
-		MerkleHash:   block.MerkleHash,
+func decodeHash(hash string) ([]byte, error) {
+	bytes, err := hex.DecodeString(hash)
+	if err != nil || len(bytes) != sha256.Size {
+		return nil, ErrMalformedHash
 	}
+	return bytes, nil
+}
 
-	marshal, err := json.Marshal(hashSeed)
+func headerPrefix(timestamp int64, previousHash string, merkleHash string) ([]byte, error) {
+	previous, err := decodeHash(previousHash)
 	if err != nil {
-		return ""
+		return nil, err
 	}
-	bytes := sha256.Sum256([]byte(marshal))
+	merkle, err := decodeHash(merkleHash)
+	if err != nil {
+		return nil, err
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(timestamp))
+	return append(append(previous, merkle...), buf[:]...), nil
+}
+
+func putNonce(header []byte, nonce int) {
+	binary.BigEndian.PutUint64(header[len(header)-nonceSize:], uint64(nonce))
+}
 
-	return hex.EncodeToString(bytes[:])
+func (block *Block) header() ([]byte, error) {
+	prefix, err := headerPrefix(block.Timestamp, block.PreviousHash, block.MerkleHash)
+	if err != nil {
+		return nil, err
+	}
+	header := append(prefix, make([]byte, nonceSize)...)
+	putNonce(header, block.Nonce)
+	return header, nil
 }
 
-func (block *Block) IsValid() bool {
-	hash := block.hash()
-	for i := 0; i < BlockDifficulty; i++ {
-		if hash[i:i+1] != "0" {
+func meetsDifficulty(hash []byte, difficulty int) bool {
+	for i := 0; i < difficulty; i++ {
+		nibble := hash[i/2]
+		if i%2 == 0 {
+			nibble >>= 4
+		}
+		if nibble&0x0f != 0 {
 			return false
 		}
 	}
-	block.Hash = hash
 	return true
 }
+
+// CalcHash hashes the header; it fails when the previous or merkle hash is
+// malformed.
+func (block *Block) CalcHash() (string, error) {
+	header, err := block.header()
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(header)
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -1,56 +1,327 @@
 package blockchain
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
-	"os"
+	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"blockchain/trace"
 )
 
 type BlockChain struct {
 	Chain           []Block       `json:"chain"`
 	TransactionPool []Transaction `json:"current_transactions"`
 	Nodes           []string      `json:"nodes"`
+	engine          ConsensusEngine
+	store           Store
+	index           *Index
+	genesisHash     string
+	events          *EventBus
+	logger          *slog.Logger
+	peerClient      *http.Client
+	peerHeights     map[string]int
+	mining          int32
+	// pooled counts the transactions of the pool by txid.
+	pooled map[string]int
+	// prunedHeight is the height of the last block without transactions,
+	// or -1 when the chain is complete.
+	prunedHeight int
+	pruneDepth   int
+	// snapshotHeight is the height of the saved snapshot, or -1.
+	snapshotHeight   int
+	snapshotInterval int
+	mu               sync.RWMutex
 }
 
+const MaxTransactionPool = 10000
+
+// maxChainSize bounds the answer of a peer to GET /chains.
+const maxChainSize = 1 << 30
+
 const GenesisTimestamp = int64(0)
 const GenesisPreviousHash = "0000000000000000000000000000000000000000000000000000000000000000"
 
-func NewBlockChain() *BlockChain {
-	blockChain := &BlockChain{}
-	nonce := blockChain.ProofOfWork(GenesisTimestamp)
-	blockChain.AddBlock(GenesisTimestamp, nonce)
-	return blockChain
+var (
+	ErrStaleBlock      = errors.New("chain tip changed while mining")
+	ErrGenesisMismatch = errors.New("stored chain has a different genesis block")
+	ErrInvalidNode     = errors.New("node must be an http or https url")
+	ErrPoolFull        = errors.New("transaction pool is full")
+)
+
+// GenesisBlock returns the fixed first block of every chain.
+func GenesisBlock() *Block {
+	block, err := NewBlock(0, GenesisTimestamp, GenesisPreviousHash, nil)
+	if err != nil {
+		// The genesis fields are constants.
+		panic(err)
+	}
+	return block
+}
+
+func NewBlockChain(engine ConsensusEngine, store Store) (*BlockChain, error) {
+	genesis := GenesisBlock()
+	blockChain := &BlockChain{
+		engine:         engine,
+		store:          store,
+		index:          NewIndex(),
+		genesisHash:    genesis.Hash,
+		events:         NewEventBus(),
+		logger:         slog.Default(),
+		peerClient:     http.DefaultClient,
+		peerHeights:    make(map[string]int),
+		pooled:         make(map[string]int),
+		prunedHeight:   -1,
+		snapshotHeight: -1,
+	}
+
+	chain, err := store.LoadChain()
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		if err := blockChain.appendBlock(genesis); err != nil {
+			return nil, err
+		}
+		return blockChain, blockChain.store.SaveIndex(blockChain.index)
+	}
+	if chain[0].Hash != genesis.Hash {
+		return nil, ErrGenesisMismatch
+	}
+	blockChain.Chain = chain
+	for i := range chain {
+		if chain[i].Pruned {
+			blockChain.prunedHeight = i
+		}
+	}
+	snapshot, err := store.LoadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	if snapshot != nil {
+		blockChain.snapshotHeight = snapshot.Height
+	}
+
+	index, err := store.LoadIndex()
+	if err != nil || index == nil || index.version != indexVersion || index.Tip() != blockChain.previousHash() {
+		return blockChain, blockChain.reindex()
+	}
+	blockChain.index = index
+	return blockChain, nil
+}
+
+func (blockChain *BlockChain) SetLogger(logger *slog.Logger) {
+	blockChain.logger = logger
+}
+
+// SetPeerTransport sets the transport the chain is fetched from peers with,
+// such as one signing the requests with the node identity.
+func (blockChain *BlockChain) SetPeerTransport(transport http.RoundTripper) {
+	blockChain.peerClient = &http.Client{Transport: transport}
 }
 
-func (blockChain *BlockChain) AddBlock(timestamp int64, nonce int) *Block {
-	merkleHash := CalcMerkleHash(blockChain.TransactionPool)
-	block := NewBlock(
+func (blockChain *BlockChain) Mine(ctx context.Context, timestamp int64) (block *Block, err error) {
+	ctx, span := trace.Start(ctx, "blockchain.Mine", trace.SpanKindInternal)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	start := time.Now()
+	atomic.AddInt32(&blockChain.mining, 1)
+	defer atomic.AddInt32(&blockChain.mining, -1)
+	blockChain.mu.RLock()
+	transactions := append([]Transaction(nil), blockChain.TransactionPool...)
+	block, err = NewBlock(
+		len(blockChain.Chain),
 		timestamp,
-		nonce,
 		blockChain.previousHash(),
-		merkleHash,
-		blockChain.TransactionPool,
+		transactions,
 	)
-	if block == nil {
-		return nil
+	blockChain.mu.RUnlock()
+	if err != nil {
+		return nil, err
 	}
+	span.SetAttributes(trace.Int("height", block.Height), trace.Int("transactions", len(transactions)))
 
-	blockChain.TransactionPool = nil
-	blockChain.appendBlock(block)
+	_, sealSpan := trace.Start(ctx, "consensus.Seal", trace.SpanKindInternal)
+	err = blockChain.engine.Seal(block)
+	sealSpan.RecordError(err)
+	sealSpan.End()
+	if err != nil {
+		blockChain.logger.Warn("sealing failed", "height", block.Height, "error", err)
+		return nil, err
+	}
+	span.SetAttributes(trace.String("hash", block.Hash))
 
-	return block
+	blockChain.mu.Lock()
+	defer blockChain.mu.Unlock()
+	if block.PreviousHash != blockChain.previousHash() {
+		blockChain.logger.Info("mined block is stale", "height", block.Height, "hash", block.Hash)
+		return nil, ErrStaleBlock
+	}
+	if err := blockChain.appendBlock(block); err != nil {
+		return nil, err
+	}
+	blockChain.TransactionPool = blockChain.TransactionPool[len(transactions):]
+	for i := range transactions {
+		blockChain.unpool(transactions[i].Hash())
+	}
+	blockChain.saveIndex()
+	blockChain.events.Publish(Event{Type: EventBlock, Block: block})
+	blockChain.maybeSnapshot()
+	blocksMinedTotal.Inc()
+	miningDuration.Observe(time.Since(start).Seconds())
+	blockChain.logger.Info("block mined",
+		"height", block.Height,
+		"hash", block.Hash,
+		"transactions", len(block.Transactions),
+		"duration", time.Since(start))
+
+	return block, nil
+}
+
+// saveIndex persists the index once the blocks it covers are committed. A
+// failure is only logged: the stored index then lags the chain, and
+// NewBlockChain rebuilds it on the next start.
+func (blockChain *BlockChain) saveIndex() {
+	if err := blockChain.store.SaveIndex(blockChain.index); err != nil {
+		blockChain.logger.Warn("saving index failed", "height", len(blockChain.Chain)-1, "error", err)
+	}
 }
 
-func (blockChain *BlockChain) appendBlock(block *Block) {
-	block.Height = len(blockChain.Chain)
+func (blockChain *BlockChain) appendBlock(block *Block) error {
+	if err := blockChain.store.AppendBlock(block); err != nil {
+		return err
+	}
 	blockChain.Chain = append(blockChain.Chain, *block)
+	blockChain.index.addBlock(block)
+	return nil
+}
+
+// Reindex rebuilds the secondary indexes from the chain and persists them.
+func (blockChain *BlockChain) Reindex() error {
+	blockChain.mu.Lock()
+	defer blockChain.mu.Unlock()
+	return blockChain.reindex()
+}
+
+func (blockChain *BlockChain) reindex() error {
+	index, err := blockChain.buildIndex(blockChain.Chain)
+	if err != nil {
+		return err
+	}
+	blockChain.index = index
+	return blockChain.store.SaveIndex(blockChain.index)
 }
 
-func (blockChain *BlockChain) AddTransaction(transaction *Transaction) {
+// buildIndex indexes chain, a prefix of the chain. The state of pruned
+// blocks comes from the stored snapshot, which must cover all of them.
+func (blockChain *BlockChain) buildIndex(chain []Block) (*Index, error) {
+	if blockChain.prunedHeight < 0 {
+		return BuildIndex(chain), nil
+	}
+	snapshot, err := blockChain.store.LoadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil || snapshot.Index == nil ||
+		snapshot.Height < blockChain.prunedHeight || snapshot.Height >= len(chain) ||
+		chain[snapshot.Height].Hash != snapshot.BlockHash {
+		return nil, ErrIndexUnrecoverable
+	}
+	index := snapshot.Index
+	for i := snapshot.Height + 1; i < len(chain); i++ {
+		index.addBlock(&chain[i])
+	}
+	return index, nil
+}
+
+func (blockChain *BlockChain) AddTransaction(ctx context.Context, transaction *Transaction) (err error) {
+	_, span := trace.Start(ctx, "blockchain.AddTransaction", trace.SpanKindInternal)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	if err := transaction.Validate(); err != nil {
+		return err
+	}
+	blockChain.mu.Lock()
+	defer blockChain.mu.Unlock()
+	if len(blockChain.TransactionPool) >= MaxTransactionPool {
+		return ErrPoolFull
+	}
 	transaction.Timestamp = time.Now().Unix()
+	txid := transaction.Hash()
+	if _, onChain := blockChain.index.transactions[txid]; onChain || blockChain.pooled[txid] > 0 {
+		return ErrDuplicateTransaction
+	}
+	blockChain.pool(transaction)
+	pooled := *transaction
+	span.SetAttributes(trace.String("txid", pooled.Hash()))
+	blockChain.events.Publish(Event{Type: EventTransaction, Transaction: &pooled})
+	blockChain.logger.Info("transaction accepted", "txid", pooled.Hash(), "pool_size", len(blockChain.TransactionPool))
+	return nil
+}
+
+func (blockChain *BlockChain) pool(transaction *Transaction) {
 	blockChain.TransactionPool = append(blockChain.TransactionPool, *transaction)
+	blockChain.pooled[transaction.Hash()]++
+}
+
+func (blockChain *BlockChain) unpool(txid string) {
+	if blockChain.pooled[txid]--; blockChain.pooled[txid] <= 0 {
+		delete(blockChain.pooled, txid)
+	}
+}
+
+// reconcilePool drops the pooled transactions that the chain now includes
+// and pools again those of removed blocks that it no longer does, so that
+// they are mined on the new chain.
+func (blockChain *BlockChain) reconcilePool(removed []Block) {
+	pool := blockChain.TransactionPool[:0]
+	for _, transaction := range blockChain.TransactionPool {
+		txid := transaction.Hash()
+		if _, included := blockChain.index.transactions[txid]; included {
+			blockChain.unpool(txid)
+		} else {
+			pool = append(pool, transaction)
+		}
+	}
+	blockChain.TransactionPool = pool
+	for i := range removed {
+		for j := range removed[i].Transactions {
+			transaction := &removed[i].Transactions[j]
+			txid := transaction.Hash()
+			if _, included := blockChain.index.transactions[txid]; included || blockChain.pooled[txid] > 0 {
+				continue
+			}
+			if len(blockChain.TransactionPool) >= MaxTransactionPool {
+				return
+			}
+			blockChain.pool(transaction)
+		}
+	}
+}
+
+func (blockChain *BlockChain) PendingTransactions() []Transaction {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	return append([]Transaction(nil), blockChain.TransactionPool...)
+}
+
+func (blockChain *BlockChain) Subscribe(filter EventFilter) *Subscription {
+	return blockChain.events.Subscribe(filter)
+}
+
+func (blockChain *BlockChain) Listen(listener func(Event)) {
+	blockChain.events.Listen(listener)
 }
 
 func (blockChain *BlockChain) lastBlock() *Block {
@@ -68,81 +339,225 @@ func (blockChain *BlockChain) previousHash() string {
 	return GenesisPreviousHash
 }
 
-func (blockChain *BlockChain) ProofOfWork(timestamp int64) int {
-	nonce := 0
-	merkleHash := CalcMerkleHash(blockChain.TransactionPool)
-	for !blockChain.validNonce(timestamp, merkleHash, nonce) {
-		nonce++
+func (blockChain *BlockChain) Blocks() []Block {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	return append([]Block(nil), blockChain.Chain...)
+}
+
+func ValidateNode(node string) error {
+	u, err := url.Parse(node)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidNode
 	}
-	return nonce
+	return nil
 }
 
-func (blockChain *BlockChain) validNonce(timestamp int64, merkleHash string, nonce int) bool {
-	block := NewBlock(
-		timestamp,
-		nonce,
-		blockChain.previousHash(),
-		merkleHash,
-		blockChain.TransactionPool,
-	)
-	return block != nil
+func (blockChain *BlockChain) Peers() []string {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	return append([]string{}, blockChain.Nodes...)
 }
 
 func (blockChain *BlockChain) AddNode(node string) {
+	blockChain.mu.Lock()
+	defer blockChain.mu.Unlock()
+	if contains(blockChain.Nodes, node) {
+		return
+	}
 	blockChain.Nodes = append(blockChain.Nodes, node)
+	blockChain.events.Publish(Event{Type: EventPeer, Peer: node})
+	blockChain.logger.Info("peer added", "peer", node)
+}
+
+// RemoveNode forgets node and the height it advertised.
+func (blockChain *BlockChain) RemoveNode(node string) {
+	blockChain.mu.Lock()
+	defer blockChain.mu.Unlock()
+	for i, other := range blockChain.Nodes {
+		if other == node {
+			blockChain.Nodes = append(blockChain.Nodes[:i], blockChain.Nodes[i+1:]...)
+			delete(blockChain.peerHeights, node)
+			blockChain.logger.Info("peer removed", "peer", node)
+			return
+		}
+	}
 }
 
-func (blockChain *BlockChain) isValidChain(chain []Block) bool {
-	lastBlock := chain[0]
+func (blockChain *BlockChain) isValidChain(ctx context.Context, chain []Block) (valid bool) {
+	_, span := trace.Start(ctx, "blockchain.ValidateChain", trace.SpanKindInternal, trace.Int("blocks", len(chain)))
+	defer func() {
+		span.SetAttributes(trace.Bool("valid", valid))
+		span.End()
+	}()
+	if len(chain) == 0 || chain[0].Hash != blockChain.genesisHash || chain[0].Height != 0 {
+		return false
+	}
 	for i := 1; i < len(chain); i++ {
-		block := chain[i]
-		if block.PreviousHash != lastBlock.Hash || !block.IsValid() {
+		if validateBlock(blockChain.engine, &chain[i-1], &chain[i]) != nil {
 			return false
 		}
-		lastBlock = block
 	}
-	return true
+	return NewIndex().checkNewTransactions(chain, 0) == nil
 }
 
-func (blockChain *BlockChain) ResolveConflicts() bool {
-	neighbours := blockChain.Nodes
-	maxLength := len(blockChain.Nodes)
-	var newChain []Block
+func (blockChain *BlockChain) ResolveConflicts(ctx context.Context) (replaced bool) {
+	ctx, span := trace.Start(ctx, "blockchain.ResolveConflicts", trace.SpanKindInternal)
+	defer func() {
+		span.SetAttributes(trace.Bool("replaced", replaced))
+		span.End()
+	}()
+	blockChain.mu.RLock()
+	nodes := append([]string(nil), blockChain.Nodes...)
+	blockChain.mu.RUnlock()
 
-	for _, node := range neighbours {
-		res, err := http.Get(node + "/chains")
-		if res.StatusCode != http.StatusOK {
-			fmt.Fprintf(os.Stderr, "http status code: %d\n", res.StatusCode)
-		}
+	var newChain []Block
+	for _, node := range nodes {
+		chain, err := fetchChain(ctx, blockChain.peerClient, node)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, err.Error())
+			blockChain.peerFailed(node, err)
 			continue
 		}
-		decoder := json.NewDecoder(res.Body)
-		var chain []Block
-		if err := decoder.Decode(&chain); err != nil {
-			fmt.Fprintf(os.Stderr, err.Error())
+		current := newChain
+		if current == nil {
+			current = blockChain.Blocks()
+		}
+		if !blockChain.engine.ChooseFork(current, chain) {
+			blockChain.SetPeerHeight(node, len(chain)-1)
 			continue
 		}
-
-		if len(chain) > maxLength && blockChain.isValidChain(chain) {
-			newChain = chain
+		if !blockChain.isValidChain(ctx, chain) {
+			blockChain.logger.Warn("peer sent invalid chain", "peer", node, "height", len(chain)-1)
+			peerFailuresTotal.Inc()
+			blockChain.ForgetPeerHeight(node)
+			continue
 		}
+		blockChain.SetPeerHeight(node, len(chain)-1)
+		newChain = chain
 	}
 
 	if newChain == nil {
 		return false
 	}
 
-	blockChain.Chain = newChain
+	blockChain.mu.Lock()
+	defer blockChain.mu.Unlock()
+	if !blockChain.engine.ChooseFork(blockChain.Chain, newChain) {
+		return false
+	}
+	if err := blockChain.replaceChain(newChain); err != nil {
+		blockChain.logger.Error("chain replacement failed", "error", err)
+		return false
+	}
+	consensusReplacementsTotal.Inc()
 	return true
 }
 
-func (blockChain *BlockChain) PrintDump() {
-	m, err := json.MarshalIndent(blockChain, "", "  ")
+func (blockChain *BlockChain) peerFailed(node string, err error) {
+	blockChain.logger.Warn("peer failed", "peer", node, "error", err)
+	peerFailuresTotal.Inc()
+}
+
+func forkPoint(a []Block, b []Block) int {
+	height := 0
+	for height < len(a) && height < len(b) && a[height].Hash == b[height].Hash {
+		height++
+	}
+	return height
+}
+
+// replaceChain switches to chain, rolling back the blocks after the fork point
+// and appending the new ones.
+func (blockChain *BlockChain) replaceChain(chain []Block) error {
+	fork := forkPoint(blockChain.Chain, chain)
+	if fork <= blockChain.prunedHeight {
+		return ErrPrunedBlock
+	}
+	for i := fork; i < len(chain); i++ {
+		if chain[i].Pruned {
+			return ErrPrunedBlock
+		}
+	}
+	if err := blockChain.store.ReplaceBlocks(fork, chain[fork:]); err != nil {
+		return err
+	}
+	reorg := &Reorg{
+		ForkHeight: fork,
+		Removed:    append([]Block(nil), blockChain.Chain[fork:]...),
+		Added:      chain[fork:],
+	}
+	for height := len(blockChain.Chain) - 1; height >= fork; height-- {
+		blockChain.index.removeBlock(&blockChain.Chain[height])
+	}
+	blockChain.Chain = append(blockChain.Chain[:fork], chain[fork:]...)
+	for i := fork; i < len(chain); i++ {
+		blockChain.index.addBlock(&chain[i])
+	}
+	blockChain.reconcilePool(reorg.Removed)
+	blockChain.saveIndex()
+
+	blockChain.logger.Info("chain replaced",
+		"fork_height", fork,
+		"removed", len(reorg.Removed),
+		"added", len(reorg.Added),
+		"height", len(blockChain.Chain)-1)
+	if len(reorg.Removed) > 0 {
+		blockChain.events.Publish(Event{Type: EventReorg, Reorg: reorg})
+	}
+	for i := range reorg.Added {
+		blockChain.events.Publish(Event{Type: EventBlock, Block: &reorg.Added[i]})
+	}
+	if fork <= blockChain.snapshotHeight {
+		// The saved snapshot is on a removed block, where the index could
+		// not be rebuilt from it.
+		if _, err := blockChain.saveSnapshot(); err != nil {
+			blockChain.logger.Error("saving snapshot failed", "error", err)
+		}
+	} else {
+		blockChain.maybeSnapshot()
+	}
+	return nil
+}
+
+// fetchChain requests the chain of a peer, propagating the trace context so
+// that the peer's handling joins the same trace.
+func fetchChain(ctx context.Context, client *http.Client, node string) (chain []Block, err error) {
+	ctx, span := trace.Start(ctx, "GET /chains", trace.SpanKindClient, trace.String("peer", node))
+	defer func() {
+		span.RecordError(err)
+		span.SetAttributes(trace.Int("blocks", len(chain)))
+		span.End()
+	}()
+	req, err := http.NewRequest("GET", node+"/chains", nil)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return nil, err
 	}
-	fmt.Println(string(m))
+	req = req.WithContext(ctx)
+	trace.Inject(ctx, req.Header)
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return decodeChain(res)
+}
+
+func decodeChain(res *http.Response) ([]Block, error) {
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status code: %d", res.StatusCode)
+	}
+	var chain []Block
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxChainSize)).Decode(&chain); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// Dump writes the chain, transaction pool and peers as indented JSON.
+func (blockChain *BlockChain) Dump(w io.Writer) error {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(blockChain)
 }
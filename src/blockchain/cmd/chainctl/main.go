@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"blockchain"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(store *blockchain.FileStore, args []string) error
+}
+
+var commands = []command{
+	{"reindex", "rebuild the transaction, address and block indexes", reindex},
+	{"export", "[-from height] file: write the chain to file, - for stdout", export},
+	{"import", "file: validate and append the blocks of file, - for stdin", importChain},
+	{"snapshot", "file: write a snapshot of the state at the tip to file", snapshot},
+	{"verify-snapshot", "file: check a snapshot against the stored chain", verifySnapshot},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: chainctl [-data dir] <command> [args]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", cmd.name, cmd.usage)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	dir := flag.String("data", "data", "data directory of the node")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flag.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		usage()
+	}
+
+	store, err := blockchain.NewFileStore(*dir)
+	if err == nil {
+		err = cmd.run(store, flag.Args()[1:])
+		store.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func reindex(store *blockchain.FileStore, args []string) error {
+	blockChain, err := openChain(store)
+	if err != nil {
+		return err
+	}
+	if err := blockChain.Reindex(); err != nil {
+		return err
+	}
+	fmt.Printf("reindexed %d blocks\n", blockChain.Height()+1)
+	return nil
+}
+
+func openChain(store *blockchain.FileStore) (*blockchain.BlockChain, error) {
+	// The engine is configured like the node's, so that imported blocks
+	// are verified against its rules.
+	engine, err := blockchain.ConsensusEngineFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return blockchain.NewBlockChain(engine, store)
+}
+
+func export(store *blockchain.FileStore, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	from := flags.Int("from", 0, "first block height to export")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: chainctl export [-from height] file")
+	}
+	blockChain, err := openChain(store)
+	if err != nil {
+		return err
+	}
+
+	out := io.Writer(os.Stdout)
+	if name := flags.Arg(0); name != "-" {
+		file, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	writer, err := blockchain.NewChainWriter(out)
+	if err != nil {
+		return err
+	}
+	exported, err := blockChain.Export(writer, *from)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d blocks\n", exported)
+	return nil
+}
+
+func importChain(store *blockchain.FileStore, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: chainctl import file")
+	}
+	blockChain, err := openChain(store)
+	if err != nil {
+		return err
+	}
+
+	in := io.Reader(os.Stdin)
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+	reader, err := blockchain.NewChainReader(in)
+	if err != nil {
+		return err
+	}
+	imported, err := blockChain.Import(reader)
+	fmt.Fprintf(os.Stderr, "imported %d blocks, height %d\n", imported, blockChain.Height())
+	return err
+}
+
+func snapshot(store *blockchain.FileStore, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: chainctl snapshot file")
+	}
+	blockChain, err := openChain(store)
+	if err != nil {
+		return err
+	}
+	snapshot, err := blockChain.Snapshot()
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(file).Encode(snapshot); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("snapshot at height %d, state hash %s\n", snapshot.Height, snapshot.StateHash)
+	return nil
+}
+
+func verifySnapshot(store *blockchain.FileStore, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: chainctl verify-snapshot file")
+	}
+	blockChain, err := openChain(store)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var snapshot blockchain.Snapshot
+	if err := json.NewDecoder(file).Decode(&snapshot); err != nil {
+		return err
+	}
+	if err := blockChain.VerifySnapshot(&snapshot); err != nil {
+		return err
+	}
+	fmt.Printf("snapshot at height %d matches the chain, state hash %s\n", snapshot.Height, snapshot.StateHash)
+	return nil
+}
@@ -0,0 +1,195 @@
+// Command p2psim runs nodes over the simulated network of package p2p and
+// checks that they converge: relay along a line of nodes, a late joiner
+// syncing the chain, and a partition healing onto the longer branch.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"time"
+
+	"blockchain"
+	"blockchain/p2p"
+)
+
+type simulation struct {
+	network *p2p.SimNetwork
+	chains  map[string]*blockchain.BlockChain
+	params  blockchain.PowParams
+	timeout time.Duration
+	secure  bool
+}
+
+func (sim *simulation) addNode(name string) error {
+	engine, err := blockchain.NewProofOfWork(sim.params)
+	if err != nil {
+		return err
+	}
+	chain, err := blockchain.NewBlockChain(engine, blockchain.NewMemoryStore())
+	if err != nil {
+		return err
+	}
+	config := p2p.Config{NetworkID: "simnet", UserAgent: "p2psim/" + name, PingInterval: time.Second}
+	if sim.secure {
+		if config.Identity, err = p2p.NewIdentity(); err != nil {
+			return err
+		}
+	}
+	node := p2p.NewNode(chain, config)
+	sim.chains[name] = chain
+	sim.network.AddNode(name, node)
+	return nil
+}
+
+// mine seals a block on the named node, retrying when a relayed block made
+// it stale.
+func (sim *simulation) mine(name string) error {
+	for {
+		_, err := sim.chains[name].Mine(context.Background(), time.Now().Unix())
+		if err != blockchain.ErrStaleBlock {
+			return err
+		}
+	}
+}
+
+func (sim *simulation) submit(name string, i int) error {
+	transaction := &blockchain.Transaction{
+		Sender:    fmt.Sprintf("sender-%d", i%7),
+		Recipient: fmt.Sprintf("recipient-%d", i%5),
+		Amount:    int64(i + 1),
+	}
+	return sim.chains[name].AddTransaction(context.Background(), transaction)
+}
+
+func (sim *simulation) converge(step string) error {
+	start := time.Now()
+	if err := sim.network.WaitConverged(sim.timeout); err != nil {
+		for name, tip := range sim.network.Tips() {
+			fmt.Printf("  %s height %d tip %s\n", name, sim.chains[name].Height(), tip)
+		}
+		return fmt.Errorf("%s: %v", step, err)
+	}
+	any := sim.chains["n0"]
+	fmt.Printf("%-10s converged in %v at height %d\n", step, time.Since(start).Round(time.Millisecond), any.Height())
+	return nil
+}
+
+func run(nodes int, blocks int, transactions int, sim *simulation) error {
+	if nodes < 2 {
+		return errors.New("need at least 2 nodes")
+	}
+	names := make([]string, nodes)
+	for i := range names {
+		names[i] = fmt.Sprintf("n%d", i)
+		if err := sim.addNode(names[i]); err != nil {
+			return err
+		}
+	}
+	defer sim.network.Close()
+	// A line is the worst case for relay: every block crosses every link.
+	for i := 1; i < nodes; i++ {
+		if err := sim.network.Link(names[i-1], names[i]); err != nil {
+			return err
+		}
+	}
+
+	// Relay: transactions and blocks start at random nodes.
+	for i := 0; i < transactions; i++ {
+		if err := sim.submit(names[rand.Intn(nodes)], i); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < blocks; i++ {
+		if err := sim.mine(names[rand.Intn(nodes)]); err != nil {
+			return err
+		}
+		if err := sim.network.WaitConverged(sim.timeout); err != nil {
+			return fmt.Errorf("block %d: %v", i, err)
+		}
+	}
+	if err := sim.converge("relay"); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if pending := len(sim.chains[name].PendingTransactions()); pending > 0 && blocks > 0 {
+			return fmt.Errorf("%s still has %d pooled transactions", name, pending)
+		}
+	}
+
+	// Sync: a new node catches up through getblocks.
+	late := fmt.Sprintf("n%d", nodes)
+	if err := sim.addNode(late); err != nil {
+		return err
+	}
+	if err := sim.network.Link(late, names[0]); err != nil {
+		return err
+	}
+	if err := sim.converge("sync"); err != nil {
+		return err
+	}
+
+	// Partition: both halves mine, the longer side wins when healed.
+	left, right := names[nodes/2-1], names[nodes/2]
+	sim.network.Unlink(left, right)
+	for i := 0; i < 2; i++ {
+		if err := sim.mine(names[0]); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if err := sim.mine(names[nodes-1]); err != nil {
+			return err
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	winner := sim.chains[names[nodes-1]].TipHash()
+	if err := sim.network.Link(left, right); err != nil {
+		return err
+	}
+	if err := sim.converge("partition"); err != nil {
+		return err
+	}
+	if tip := sim.chains["n0"].TipHash(); tip != winner {
+		return fmt.Errorf("partition: converged on %s instead of the longer branch %s", tip, winner)
+	}
+	return nil
+}
+
+func main() {
+	nodes := flag.Int("nodes", 5, "number of nodes in the line")
+	blocks := flag.Int("blocks", 20, "blocks to mine during relay")
+	transactions := flag.Int("txs", 50, "transactions to submit during relay")
+	difficulty := flag.Int("difficulty", 1, "proof of work difficulty")
+	latency := flag.Duration("latency", 0, "one way latency per frame")
+	timeout := flag.Duration("timeout", 30*time.Second, "how long to wait for convergence")
+	secure := flag.Bool("tls", false, "give nodes identities and encrypt the links")
+	verbose := flag.Bool("v", false, "log node activity")
+	flag.Parse()
+
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	params := blockchain.DefaultPowParams
+	params.Difficulty = *difficulty
+	sim := &simulation{
+		network: p2p.NewSimNetwork(),
+		chains:  make(map[string]*blockchain.BlockChain),
+		params:  params,
+		timeout: *timeout,
+		secure:  *secure,
+	}
+	sim.network.SetLatency(*latency)
+	if err := run(*nodes, *blocks, *transactions, sim); err != nil {
+		fmt.Fprintln(os.Stderr, "FAIL:", err)
+		os.Exit(1)
+	}
+	fmt.Println("PASS")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"blockchain"
+	"blockchain/spv"
+)
+
+func report(client *spv.Client, addresses []string, confirmations int) {
+	tip := client.Tip()
+	fmt.Printf("height %d tip %s\n", tip.Height, tip.Hash)
+	for _, address := range addresses {
+		fmt.Printf("%s balance %d\n", address, client.Balance(address, confirmations))
+		for _, payment := range client.Payments(address) {
+			fmt.Printf("  %s %s -> %s %d (%d confirmations)\n",
+				payment.TxID, payment.Transaction.Sender, payment.Transaction.Recipient,
+				payment.Transaction.Amount, payment.Confirmations)
+		}
+	}
+}
+
+func main() {
+	peers := flag.String("peers", "http://localhost:8080", "comma separated full nodes to sync from")
+	watch := flag.String("watch", "", "comma separated addresses to track")
+	interval := flag.Duration("interval", 0, "keep syncing at this interval instead of exiting")
+	confirmations := flag.Int("confirmations", 1, "confirmations a payment needs to count in the balance")
+	flag.Parse()
+
+	// The engine is configured like the full node's, so that headers are
+	// checked against its rules.
+	engine, err := blockchain.ConsensusEngineFromEnv()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	client := spv.NewClient(engine, blockchain.SplitList(*peers))
+	addresses := blockchain.SplitList(*watch)
+	for _, address := range addresses {
+		client.Watch(address)
+	}
+
+	for {
+		if err := client.Sync(context.Background()); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			if *interval == 0 {
+				os.Exit(1)
+			}
+		}
+		report(client, addresses, *confirmations)
+		if *interval == 0 {
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
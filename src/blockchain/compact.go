@@ -0,0 +1,258 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+
+	"blockchain/trace"
+)
+
+// maxRelayClockSkew is how far in the future a relayed transaction's
+// timestamp may be.
+const maxRelayClockSkew = 2 * time.Hour
+
+// shortIDSize is the number of hash bytes in a short transaction id. Six
+// bytes keep collisions within a pool rare and fit exactly in a JSON number.
+const shortIDSize = 6
+
+var (
+	ErrKnownBlock          = errors.New("block is already in the chain")
+	ErrOrphanBlock         = errors.New("block does not extend the chain tip")
+	ErrCompactBlock        = errors.New("compact block is malformed")
+	ErrMissingTransactions = errors.New("compact block transactions are missing")
+)
+
+// PrefilledTransaction is a transaction sent in full within a compact block,
+// at its position in the block.
+type PrefilledTransaction struct {
+	Index       int         `json:"index"`
+	Transaction Transaction `json:"transaction"`
+}
+
+// CompactBlock announces a block by its header and a short id for each
+// transaction, so that peers can rebuild it from their transaction pools.
+// Transactions a peer asked for are sent in full as Prefilled.
+type CompactBlock struct {
+	Header    Block                  `json:"header"`
+	ShortIDs  []uint64               `json:"short_ids"`
+	Prefilled []PrefilledTransaction `json:"prefilled,omitempty"`
+}
+
+// ShortID derives the short id of a transaction in the block with
+// blockHash. Keying it with the block hash means a collision found for one
+// block does not carry over to the next.
+func ShortID(blockHash string, transaction *Transaction) uint64 {
+	h := sha256.New()
+	h.Write([]byte(blockHash))
+	h.Write(transaction.hashBytes())
+	var buf [8]byte
+	copy(buf[8-shortIDSize:], h.Sum(nil))
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+func NewCompactBlock(block *Block) *CompactBlock {
+	compact := &CompactBlock{
+		Header:   *block,
+		ShortIDs: make([]uint64, len(block.Transactions)),
+	}
+	compact.Header.Transactions = nil
+	for i := range block.Transactions {
+		compact.ShortIDs[i] = ShortID(block.Hash, &block.Transactions[i])
+	}
+	return compact
+}
+
+// Prefill adds the transactions of block at indexes in full.
+func (compact *CompactBlock) Prefill(block *Block, indexes []int) error {
+	for _, i := range indexes {
+		if i < 0 || i >= len(block.Transactions) {
+			return ErrCompactBlock
+		}
+		compact.Prefilled = append(compact.Prefilled, PrefilledTransaction{Index: i, Transaction: block.Transactions[i]})
+	}
+	return nil
+}
+
+// reconstruct rebuilds the block from the prefilled transactions and pool.
+// It returns the indexes of transactions that were not found; short ids
+// matching more than one pooled transaction count as not found.
+func (compact *CompactBlock) reconstruct(pool []Transaction) (*Block, []int, error) {
+	if compact.Header.Pruned || len(compact.Header.Transactions) > 0 {
+		return nil, nil, ErrCompactBlock
+	}
+	transactions := make([]Transaction, len(compact.ShortIDs))
+	found := make([]bool, len(compact.ShortIDs))
+	for _, prefilled := range compact.Prefilled {
+		if prefilled.Index < 0 || prefilled.Index >= len(transactions) {
+			return nil, nil, ErrCompactBlock
+		}
+		transactions[prefilled.Index] = prefilled.Transaction
+		found[prefilled.Index] = true
+	}
+
+	wanted := make(map[uint64][]int)
+	for i, id := range compact.ShortIDs {
+		if !found[i] {
+			wanted[id] = append(wanted[id], i)
+		}
+	}
+	matches := make(map[uint64]int)
+	candidates := make(map[uint64]*Transaction)
+	for i := range pool {
+		id := ShortID(compact.Header.Hash, &pool[i])
+		if _, ok := wanted[id]; ok {
+			matches[id]++
+			candidates[id] = &pool[i]
+		}
+	}
+	var missing []int
+	for id, indexes := range wanted {
+		if matches[id] != 1 || len(indexes) != 1 {
+			missing = append(missing, indexes...)
+			continue
+		}
+		transactions[indexes[0]] = *candidates[id]
+	}
+	if len(missing) > 0 {
+		sort.Ints(missing)
+		return nil, missing, nil
+	}
+
+	block := compact.Header
+	block.Transactions = transactions
+	if block.MerkleHash != CalcMerkleHash(block.Transactions) {
+		// A pooled transaction collided with a short id; ask for every
+		// transaction that was not sent in full.
+		for i := range found {
+			if !found[i] {
+				missing = append(missing, i)
+			}
+		}
+		return nil, missing, nil
+	}
+	return &block, nil, nil
+}
+
+// AcceptCompactBlock rebuilds a block announced by a peer from the
+// transaction pool and appends it. When transactions are missing it returns
+// their indexes with ErrMissingTransactions, for the peer to send them as
+// prefilled transactions. A block that does not extend the tip returns
+// ErrOrphanBlock once its seal is verified; the chain must then be resolved
+// with the peers.
+func (blockChain *BlockChain) AcceptCompactBlock(ctx context.Context, compact *CompactBlock) (missing []int, err error) {
+	ctx, span := trace.Start(ctx, "blockchain.AcceptCompactBlock", trace.SpanKindInternal,
+		trace.String("hash", compact.Header.Hash),
+		trace.Int("height", compact.Header.Height),
+		trace.Int("transactions", len(compact.ShortIDs)),
+		trace.Int("prefilled", len(compact.Prefilled)))
+	defer func() {
+		span.SetAttributes(trace.Int("missing", len(missing)))
+		span.RecordError(err)
+		span.End()
+	}()
+	blockChain.mu.RLock()
+	_, known := blockChain.index.blocks[compact.Header.Hash]
+	tip := blockChain.previousHash()
+	block, missing, err := compact.reconstruct(blockChain.TransactionPool)
+	blockChain.mu.RUnlock()
+	switch {
+	case known:
+		return nil, ErrKnownBlock
+	case compact.Header.PreviousHash != tip:
+		// Resolving fetches the chains of all peers, which anyone could
+		// trigger with a made up header if it were not sealed.
+		header := compact.Header.Header()
+		if err := blockChain.engine.VerifySeal(&header); err != nil {
+			return nil, err
+		}
+		return nil, ErrOrphanBlock
+	case err != nil:
+		return nil, err
+	case len(missing) > 0:
+		compactMissingTotal.Add(float64(len(missing)))
+		return missing, ErrMissingTransactions
+	}
+	compactReconstructedTotal.Add(float64(len(block.Transactions) - len(compact.Prefilled)))
+	return nil, blockChain.AddBlock(ctx, block)
+}
+
+// AddBlock appends a block received from a peer on top of the tip and drops
+// its transactions from the pool.
+func (blockChain *BlockChain) AddBlock(ctx context.Context, block *Block) (err error) {
+	_, span := trace.Start(ctx, "blockchain.AddBlock", trace.SpanKindInternal,
+		trace.String("hash", block.Hash), trace.Int("height", block.Height))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	if block.Pruned {
+		return ErrPrunedBlock
+	}
+	blockChain.mu.Lock()
+	defer blockChain.mu.Unlock()
+	if _, known := blockChain.index.blocks[block.Hash]; known {
+		return ErrKnownBlock
+	}
+	if block.PreviousHash != blockChain.previousHash() {
+		return ErrOrphanBlock
+	}
+	if err := validateBlock(blockChain.engine, blockChain.lastBlock(), block); err != nil {
+		return err
+	}
+	if err := blockChain.index.checkNewTransactions([]Block{*block}, block.Height); err != nil {
+		return err
+	}
+	if err := blockChain.appendBlock(block); err != nil {
+		return err
+	}
+	blockChain.reconcilePool(nil)
+	blockChain.saveIndex()
+	blockChain.events.Publish(Event{Type: EventBlock, Block: block})
+	blockChain.maybeSnapshot()
+	blockChain.logger.Info("block received",
+		"height", block.Height,
+		"hash", block.Hash,
+		"transactions", len(block.Transactions),
+		"pool_size", len(blockChain.TransactionPool))
+	return nil
+}
+
+// AddRelayedTransaction adds a transaction relayed by a peer to the pool,
+// keeping its timestamp so that it has the same txid on every node. It
+// returns false for transactions already pooled or in the chain.
+func (blockChain *BlockChain) AddRelayedTransaction(ctx context.Context, transaction *Transaction) (added bool, err error) {
+	_, span := trace.Start(ctx, "blockchain.AddRelayedTransaction", trace.SpanKindInternal)
+	defer func() {
+		span.SetAttributes(trace.Bool("added", added))
+		span.RecordError(err)
+		span.End()
+	}()
+	if err := transaction.Validate(); err != nil {
+		return false, err
+	}
+	if transaction.Timestamp <= 0 || transaction.Timestamp > time.Now().Add(maxRelayClockSkew).Unix() {
+		return false, ErrInvalidTimestamp
+	}
+	txid := transaction.Hash()
+	span.SetAttributes(trace.String("txid", txid))
+	blockChain.mu.Lock()
+	defer blockChain.mu.Unlock()
+	if _, ok := blockChain.index.transactions[txid]; ok {
+		return false, nil
+	}
+	if blockChain.pooled[txid] > 0 {
+		return false, nil
+	}
+	if len(blockChain.TransactionPool) >= MaxTransactionPool {
+		return false, ErrPoolFull
+	}
+	blockChain.pool(transaction)
+	pooled := *transaction
+	blockChain.events.Publish(Event{Type: EventTransaction, Transaction: &pooled})
+	blockChain.logger.Debug("relayed transaction accepted", "txid", txid, "pool_size", len(blockChain.TransactionPool))
+	return true, nil
+}
@@ -0,0 +1,99 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAcceptCompactBlockVerifiesOrphanSeal(t *testing.T) {
+	chain := newTestChain(t)
+	ctx := context.Background()
+	forged := &CompactBlock{Header: Block{Height: 9, PreviousHash: "unknown", Hash: "forged"}}
+	if _, err := chain.AcceptCompactBlock(ctx, forged); err == nil || err == ErrOrphanBlock {
+		t.Fatalf("AcceptCompactBlock of an unsealed orphan: error = %v, want a seal error", err)
+	}
+
+	peer := newTestChain(t)
+	mineBlocks(t, peer, GenesisTimestamp+1, GenesisTimestamp+2)
+	blocks := peer.Blocks()
+	if _, err := chain.AcceptCompactBlock(ctx, NewCompactBlock(&blocks[2])); err != ErrOrphanBlock {
+		t.Fatalf("AcceptCompactBlock of a sealed orphan: error = %v, want %v", err, ErrOrphanBlock)
+	}
+	if _, err := chain.AcceptCompactBlock(ctx, NewCompactBlock(&blocks[1])); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newBlockWithTransactions mines on a new chain a block with n transactions
+// and returns it with the transactions.
+func newBlockWithTransactions(t *testing.T, n int) (*Block, []Transaction) {
+	peer := newTestChain(t)
+	for i := 0; i < n; i++ {
+		transaction := &Transaction{Sender: "alice", Recipient: "bob", Amount: int64(i + 1)}
+		if err := peer.AddTransaction(context.Background(), transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+	block, err := peer.Mine(context.Background(), GenesisTimestamp+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block, block.Transactions
+}
+
+func TestAcceptCompactBlockAsksForMissingTransactions(t *testing.T) {
+	block, transactions := newBlockWithTransactions(t, 3)
+	chain := newTestChain(t)
+	ctx := context.Background()
+	for _, i := range []int{0, 2} {
+		if _, err := chain.AddRelayedTransaction(ctx, &transactions[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	compact := NewCompactBlock(block)
+	missing, err := chain.AcceptCompactBlock(ctx, compact)
+	if err != ErrMissingTransactions || len(missing) != 1 || missing[0] != 1 {
+		t.Fatalf("AcceptCompactBlock = %v, %v, want [1], %v", missing, err, ErrMissingTransactions)
+	}
+	if err := compact.Prefill(block, missing); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.AcceptCompactBlock(ctx, compact); err != nil {
+		t.Fatal(err)
+	}
+	if chain.TipHash() != block.Hash || len(chain.PendingTransactions()) != 0 {
+		t.Fatalf("tip %s with %d pooled, want %s with none", chain.TipHash(), len(chain.PendingTransactions()), block.Hash)
+	}
+	if _, err := chain.AcceptCompactBlock(ctx, NewCompactBlock(block)); err != ErrKnownBlock {
+		t.Fatalf("announced again: error = %v, want %v", err, ErrKnownBlock)
+	}
+}
+
+func TestReconstructShortIDCollision(t *testing.T) {
+	block, transactions := newBlockWithTransactions(t, 3)
+	other := Transaction{Sender: "carol", Recipient: "dave", Amount: 9}
+	pool := append([]Transaction{other}, transactions...)
+
+	// A pooled transaction with the short id of another is caught by the
+	// merkle hash, and everything not prefilled is asked for.
+	compact := NewCompactBlock(block)
+	compact.ShortIDs[1] = ShortID(block.Hash, &other)
+	compact.Prefill(block, []int{0})
+	if rebuilt, missing, err := compact.reconstruct(pool); rebuilt != nil || err != nil || len(missing) != 2 || missing[0] != 1 || missing[1] != 2 {
+		t.Fatalf("reconstruct with a colliding transaction = %v, %v, %v, want [1 2]", rebuilt != nil, missing, err)
+	}
+
+	// Two transactions of the block with one short id are both asked for.
+	compact = NewCompactBlock(block)
+	compact.ShortIDs[2] = compact.ShortIDs[0]
+	if _, missing, err := compact.reconstruct(pool); err != nil || len(missing) != 2 || missing[0] != 0 || missing[1] != 2 {
+		t.Fatalf("reconstruct with a repeated short id = %v, %v, want [0 2]", missing, err)
+	}
+
+	// Prefilled transactions out of range are malformed.
+	compact = NewCompactBlock(block)
+	compact.Prefilled = []PrefilledTransaction{{Index: 3}}
+	if _, _, err := compact.reconstruct(pool); err != ErrCompactBlock {
+		t.Fatalf("reconstruct with a prefilled index out of range: error = %v, want %v", err, ErrCompactBlock)
+	}
+}
@@ -0,0 +1,65 @@
+package blockchain
+
+import "errors"
+
+var (
+	ErrInvalidHash         = errors.New("block hash mismatch")
+	ErrInvalidSeal         = errors.New("invalid block seal")
+	ErrInvalidHeight       = errors.New("block height does not follow its parent")
+	ErrInvalidPreviousHash = errors.New("block does not link to its parent")
+	ErrInvalidMerkleHash   = errors.New("block merkle hash mismatch")
+)
+
+type ConsensusEngine interface {
+	// Seal fills in the consensus fields of block and its hash.
+	Seal(block *Block) error
+	// VerifySeal checks the consensus fields of block.
+	VerifySeal(block *Block) error
+	// ChooseFork reports whether candidate should replace current.
+	ChooseFork(current []Block, candidate []Block) bool
+}
+
+func verifyHash(block *Block) error {
+	hash, err := block.CalcHash()
+	if err != nil {
+		return err
+	}
+	if block.Hash != hash {
+		return ErrInvalidHash
+	}
+	return nil
+}
+
+// validateBlock checks that block extends parent and is sealed. The merkle
+// hash of a pruned block cannot be checked; its header is still verified.
+func validateBlock(engine ConsensusEngine, parent *Block, block *Block) error {
+	if block.Height != parent.Height+1 {
+		return ErrInvalidHeight
+	}
+	if block.PreviousHash != parent.Hash {
+		return ErrInvalidPreviousHash
+	}
+	if !block.Pruned && block.MerkleHash != CalcMerkleHash(block.Transactions) {
+		return ErrInvalidMerkleHash
+	}
+	seen := make(map[string]bool, len(block.Transactions))
+	for i := range block.Transactions {
+		txid := block.Transactions[i].Hash()
+		if seen[txid] {
+			return ErrDuplicateTransaction
+		}
+		seen[txid] = true
+	}
+	return engine.VerifySeal(block)
+}
+
+// ValidateHeader checks that header extends parent and is sealed, without
+// looking at transactions, as a light client does.
+func ValidateHeader(engine ConsensusEngine, parent *Block, header *Block) error {
+	h := header.Header()
+	return validateBlock(engine, parent, &h)
+}
+
+func longestChain(current []Block, candidate []Block) bool {
+	return len(candidate) > len(current)
+}
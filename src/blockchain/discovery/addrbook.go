@@ -0,0 +1,457 @@
+package discovery
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"blockchain"
+)
+
+// The book is split into buckets like Bitcoin's address manager. Where an
+// address lands depends on a secret key, so an attacker cannot aim at a
+// bucket, and one source or network group only reaches a few buckets, so a
+// flood of addresses from one place cannot push out all the others.
+const (
+	newBuckets         = 256
+	triedBuckets       = 64
+	bucketSize         = 64
+	bucketsPerSource   = 32
+	bucketsPerGroup    = 8
+	maxFailures        = 10
+	horizon            = 30 * 24 * time.Hour
+	retryAfterFailure  = 10 * time.Minute
+	maxAddressesPerAdd = 1000
+)
+
+// Address is a peer the book knows about. Source is the peer that told us,
+// or "seed" for the configured seeds. Group and SourceGroup are the network
+// groups of their hosts, set when the addresses were resolved.
+type Address struct {
+	URL         string    `json:"url"`
+	Source      string    `json:"source"`
+	Group       string    `json:"group,omitempty"`
+	SourceGroup string    `json:"source_group,omitempty"`
+	Added       time.Time `json:"added"`
+	LastAttempt time.Time `json:"last_attempt,omitempty"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	Failures    int       `json:"failures"`
+	Tried       bool      `json:"tried"`
+	bucket      int
+}
+
+// terrible reports an address not worth keeping: failing for long or not
+// seen working within the horizon.
+func (address *Address) terrible(now time.Time) bool {
+	if address.Failures >= maxFailures && address.LastSuccess.Before(now.Add(-time.Hour)) {
+		return true
+	}
+	last := address.LastSuccess
+	if last.IsZero() {
+		last = address.Added
+	}
+	return now.Sub(last) > horizon
+}
+
+type bookFile struct {
+	Key       string    `json:"key"`
+	Addresses []Address `json:"addresses"`
+}
+
+// AddrBook keeps the addresses of peers in new and tried buckets. Addresses
+// move to tried once a connection to them worked.
+type AddrBook struct {
+	mu      sync.Mutex
+	key     []byte
+	path    string
+	entries map[string]*Address
+	new     [newBuckets]map[string]*Address
+	tried   [triedBuckets]map[string]*Address
+}
+
+// NewAddrBook returns an empty in-memory book.
+func NewAddrBook() *AddrBook {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return newAddrBook(key)
+}
+
+func newAddrBook(key []byte) *AddrBook {
+	book := &AddrBook{key: key, entries: make(map[string]*Address)}
+	for i := range book.new {
+		book.new[i] = make(map[string]*Address)
+	}
+	for i := range book.tried {
+		book.tried[i] = make(map[string]*Address)
+	}
+	return book
+}
+
+// LoadAddrBook reads the book saved at path, or starts an empty one saved
+// there from now on.
+func LoadAddrBook(path string) (*AddrBook, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		book := NewAddrBook()
+		book.path = path
+		return book, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file bookFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(file.Key)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("discovery: invalid address book key")
+	}
+	book := newAddrBook(key)
+	book.path = path
+	// Tried addresses go first so that they keep their place.
+	sort.SliceStable(file.Addresses, func(i, j int) bool {
+		return file.Addresses[i].Tried && !file.Addresses[j].Tried
+	})
+	for i := range file.Addresses {
+		address := file.Addresses[i]
+		if blockchain.ValidateNode(address.URL) != nil {
+			continue
+		}
+		if address.Tried {
+			book.insertTried(&address, time.Now())
+		} else {
+			book.insertNew(&address, time.Now())
+		}
+	}
+	return book, nil
+}
+
+// Save writes the book atomically to the path it was loaded from; an
+// in-memory book is not saved.
+func (book *AddrBook) Save() error {
+	book.mu.Lock()
+	file := bookFile{Key: hex.EncodeToString(book.key), Addresses: make([]Address, 0, len(book.entries))}
+	for _, address := range book.entries {
+		file.Addresses = append(file.Addresses, *address)
+	}
+	path := book.path
+	book.mu.Unlock()
+	if path == "" {
+		return nil
+	}
+	sort.Slice(file.Addresses, func(i, j int) bool { return file.Addresses[i].URL < file.Addresses[j].URL })
+
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(out)
+	if err := json.NewEncoder(writer).Encode(file); err != nil {
+		out.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// group is the network an address belongs to: the group of its IP, or the
+// host name when it is not an IP address. Host names are only grouped by
+// name for addresses added without resolving them, such as seeds; one name
+// per host would otherwise let a single host fill every bucket.
+func group(address string) string {
+	u, err := url.Parse(address)
+	if err != nil {
+		return address
+	}
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); ip != nil {
+		return ipGroup(ip)
+	}
+	return host
+}
+
+// ipGroup is the /16 of an IPv4 address or the /32 of an IPv6 address.
+func ipGroup(ip net.IP) string {
+	switch {
+	case ip.IsLoopback() || ip.IsPrivate():
+		return "local"
+	case ip.To4() != nil:
+		return ip.Mask(net.CIDRMask(16, 32)).String()
+	default:
+		return ip.Mask(net.CIDRMask(32, 128)).String()
+	}
+}
+
+func (address *Address) group() string {
+	if address.Group != "" {
+		return address.Group
+	}
+	return group(address.URL)
+}
+
+func (address *Address) sourceGroup() string {
+	if address.SourceGroup != "" {
+		return address.SourceGroup
+	}
+	return group(address.Source)
+}
+
+func (book *AddrBook) hash(parts ...string) uint64 {
+	h := sha256.New()
+	h.Write(book.key)
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// newBucket spreads the addresses of one source group over at most
+// bucketsPerSource buckets.
+func (book *AddrBook) newBucket(address *Address) int {
+	sourceGroup := address.sourceGroup()
+	slot := book.hash(sourceGroup, address.group()) % bucketsPerSource
+	return int(book.hash(sourceGroup, strconv.FormatUint(slot, 10)) % newBuckets)
+}
+
+// triedBucket spreads the addresses of one network group over at most
+// bucketsPerGroup buckets.
+func (book *AddrBook) triedBucket(address *Address) int {
+	slot := book.hash(address.URL) % bucketsPerGroup
+	return int(book.hash(address.group(), strconv.FormatUint(slot, 10)) % triedBuckets)
+}
+
+// insertNew places address in its new bucket, evicting a terrible or the
+// oldest address when the bucket is full.
+func (book *AddrBook) insertNew(address *Address, now time.Time) {
+	address.Tried = false
+	address.bucket = book.newBucket(address)
+	bucket := book.new[address.bucket]
+	if len(bucket) >= bucketSize {
+		var oldest *Address
+		for _, other := range bucket {
+			if other.terrible(now) {
+				oldest = other
+				break
+			}
+			if oldest == nil || other.Added.Before(oldest.Added) {
+				oldest = other
+			}
+		}
+		delete(bucket, oldest.URL)
+		delete(book.entries, oldest.URL)
+	}
+	bucket[address.URL] = address
+	book.entries[address.URL] = address
+}
+
+// insertTried places address in its tried bucket. When the bucket is full
+// the address that worked least recently goes back to new.
+func (book *AddrBook) insertTried(address *Address, now time.Time) {
+	address.Tried = true
+	address.bucket = book.triedBucket(address)
+	bucket := book.tried[address.bucket]
+	if len(bucket) >= bucketSize {
+		var oldest *Address
+		for _, other := range bucket {
+			if oldest == nil || other.LastSuccess.Before(oldest.LastSuccess) {
+				oldest = other
+			}
+		}
+		delete(bucket, oldest.URL)
+		book.insertNew(oldest, now)
+	}
+	bucket[address.URL] = address
+	book.entries[address.URL] = address
+}
+
+func (book *AddrBook) remove(address *Address) {
+	if address.Tried {
+		delete(book.tried[address.bucket], address.URL)
+	} else {
+		delete(book.new[address.bucket], address.URL)
+	}
+	delete(book.entries, address.URL)
+}
+
+// Add records addresses learned from source and returns how many were new.
+// Invalid addresses are skipped, and at most maxAddressesPerAdd are read.
+// groups holds the network groups of the resolved addresses and source; when
+// it is nil, addresses are grouped by host name, and otherwise addresses
+// missing from it are skipped.
+func (book *AddrBook) Add(addresses []string, source string, groups map[string]string) int {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	now := time.Now()
+	if len(addresses) > maxAddressesPerAdd {
+		addresses = addresses[:maxAddressesPerAdd]
+	}
+	added := 0
+	for _, address := range addresses {
+		address = strings.TrimRight(address, "/")
+		if blockchain.ValidateNode(address) != nil || address == source {
+			continue
+		}
+		if _, ok := book.entries[address]; ok {
+			continue
+		}
+		if _, ok := groups[address]; groups != nil && !ok {
+			continue
+		}
+		book.insertNew(&Address{
+			URL:         address,
+			Source:      source,
+			Group:       groups[address],
+			SourceGroup: groups[source],
+			Added:       now,
+		}, now)
+		added++
+	}
+	return added
+}
+
+// Has reports whether address is in the book.
+func (book *AddrBook) Has(address string) bool {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	_, ok := book.entries[address]
+	return ok
+}
+
+// Attempt records that a connection to address is being tried.
+func (book *AddrBook) Attempt(address string) {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	if entry, ok := book.entries[address]; ok {
+		entry.LastAttempt = time.Now()
+	}
+}
+
+// Good records a working connection to address, moving it to tried. An
+// unknown address, such as one added by hand, is added first.
+func (book *AddrBook) Good(address string) {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	now := time.Now()
+	entry, ok := book.entries[address]
+	if !ok {
+		entry = &Address{URL: address, Source: address, Added: now}
+	}
+	entry.LastSuccess = now
+	entry.Failures = 0
+	if ok && entry.Tried {
+		return
+	}
+	if ok {
+		book.remove(entry)
+	}
+	book.insertTried(entry, now)
+}
+
+// Failed records a failed connection; terrible addresses are forgotten.
+func (book *AddrBook) Failed(address string) {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	entry, ok := book.entries[address]
+	if !ok {
+		return
+	}
+	entry.Failures++
+	if entry.terrible(time.Now()) {
+		book.remove(entry)
+	}
+}
+
+// Select picks a random address to connect to that is not in exclude and
+// was not tried recently, or "" when there is none. Tried and new addresses
+// are equally likely, so new ones are explored without trusting them more.
+func (book *AddrBook) Select(exclude map[string]bool) string {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	now := time.Now()
+	candidates := func(buckets []map[string]*Address) []*Address {
+		var list []*Address
+		for _, bucket := range buckets {
+			for _, address := range bucket {
+				if exclude[address.URL] || now.Sub(address.LastAttempt) < retryAfterFailure*time.Duration(address.Failures) {
+					continue
+				}
+				list = append(list, address)
+			}
+		}
+		return list
+	}
+	tried, fresh := candidates(book.tried[:]), candidates(book.new[:])
+	if len(tried) == 0 && len(fresh) == 0 {
+		return ""
+	}
+	list := fresh
+	if len(fresh) == 0 || (len(tried) > 0 && randomInt(2) == 0) {
+		list = tried
+	}
+	return list[randomInt(len(list))].URL
+}
+
+// GoodAddresses returns up to limit tried addresses that did not fail since,
+// those that worked most recently first.
+func (book *AddrBook) GoodAddresses(limit int) []string {
+	book.mu.Lock()
+	var tried []Address
+	for _, bucket := range book.tried {
+		for _, address := range bucket {
+			if address.Failures == 0 {
+				tried = append(tried, *address)
+			}
+		}
+	}
+	book.mu.Unlock()
+	sort.Slice(tried, func(i, j int) bool { return tried[i].LastSuccess.After(tried[j].LastSuccess) })
+	addresses := make([]string, 0, limit)
+	for _, address := range tried {
+		if len(addresses) == limit {
+			break
+		}
+		addresses = append(addresses, address.URL)
+	}
+	return addresses
+}
+
+// Len returns the number of new and tried addresses.
+func (book *AddrBook) Len() (fresh int, tried int) {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	for _, address := range book.entries {
+		if address.Tried {
+			tried++
+		} else {
+			fresh++
+		}
+	}
+	return fresh, tried
+}
+
+func randomInt(n int) int {
+	i, _ := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	return int(i.Int64())
+}
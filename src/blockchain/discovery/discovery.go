@@ -0,0 +1,474 @@
+// Package discovery finds peers for a node: it bootstraps from seed nodes,
+// asks peers for the peers they know with GET /nodes, keeps what it learns
+// in an address book and keeps the chain connected to a target number of
+// peers.
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"blockchain"
+	"blockchain/p2p"
+	"blockchain/trace"
+)
+
+const (
+	DefaultTarget   = 8
+	DefaultInterval = time.Minute
+	requestTimeout  = 10 * time.Second
+	// maxPeerFailures is how many exchanges in a row a discovered peer may
+	// fail before it is dropped.
+	maxPeerFailures  = 3
+	maxResponseSize  = 1 << 20
+	maxAttemptsRatio = 3
+	// maxLookups is how many addresses are resolved at a time.
+	maxLookups = 16
+	// MaxAddresses bounds the answer to GET /nodes.
+	MaxAddresses = 1000
+)
+
+// AdvertiseHeader carries Self on the requests of the manager, so that the
+// peers it asks learn about it in turn. Peers only learn it when its host
+// resolves to the address the request came from.
+const AdvertiseHeader = "X-Advertise-Url"
+
+var (
+	ErrWrongNetwork   = errors.New("discovery: peer is on another network")
+	ErrWrongGenesis   = errors.New("discovery: peer has another genesis block")
+	ErrSelf           = errors.New("discovery: address is this node")
+	ErrNotAdvertiser  = errors.New("discovery: advertised address does not resolve to the caller")
+	ErrInvalidAddress = errors.New("discovery: invalid address")
+)
+
+type Config struct {
+	// Seeds are node URLs asked for peers when the address book is empty.
+	Seeds []string
+	// Self is the URL this node is reachable at. It is advertised to peers
+	// and never dialed.
+	Self string
+	// NodeID, when set, finds this node behind addresses other than Self.
+	NodeID    string
+	NetworkID string
+	// Target is the number of peers to keep.
+	Target   int
+	Interval time.Duration
+	// Identity, when set, signs the requests to peers.
+	Identity *p2p.Identity
+	// AllowPrivate allows discovered peers on loopback, private and
+	// link-local addresses, which are refused by default so that peers
+	// cannot point the node at its own network. Peers added by hand are
+	// always allowed.
+	AllowPrivate bool
+}
+
+// Manager keeps the peers of a chain. Peers it added itself are dropped
+// when they stop answering; peers added by hand are kept.
+type Manager struct {
+	chain   *blockchain.BlockChain
+	book    *AddrBook
+	config  Config
+	genesis string
+	client  *http.Client
+	dialer  *net.Dialer
+	public  *net.Dialer
+	logger  *slog.Logger
+
+	mu         sync.Mutex
+	discovered map[string]int
+	quit       chan struct{}
+	closeOnce  sync.Once
+}
+
+func NewManager(chain *blockchain.BlockChain, book *AddrBook, config Config) *Manager {
+	if config.Target == 0 {
+		config.Target = DefaultTarget
+	}
+	if config.Interval == 0 {
+		config.Interval = DefaultInterval
+	}
+	genesis, _ := chain.BlockByHeight(0)
+	manager := &Manager{
+		chain:      chain,
+		book:       book,
+		config:     config,
+		genesis:    genesis.Hash,
+		dialer:     &net.Dialer{Timeout: requestTimeout},
+		public:     blockchain.PublicDialer(&net.Dialer{Timeout: requestTimeout}),
+		logger:     slog.Default(),
+		discovered: make(map[string]int),
+		quit:       make(chan struct{}),
+	}
+	var transport http.RoundTripper = &http.Transport{DialContext: manager.DialContext}
+	if config.Identity != nil {
+		transport = config.Identity.Transport(transport)
+	}
+	manager.client = &http.Client{Timeout: requestTimeout, Transport: transport}
+	return manager
+}
+
+// DialContext dials address, a host and port, for a request to a peer.
+// Seeds and peers added by hand are dialed on any address; discovered peers
+// and any other address only on public ones unless config.AllowPrivate is
+// set, also when a name resolves to a private address after the peer was
+// probed. Every client that may reach discovered peers should dial with it.
+func (manager *Manager) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	if manager.config.AllowPrivate || manager.trusted(address) {
+		return manager.dialer.DialContext(ctx, network, address)
+	}
+	return manager.public.DialContext(ctx, network, address)
+}
+
+// trusted reports whether address is the host and port of a seed or of a
+// peer added by hand.
+func (manager *Manager) trusted(address string) bool {
+	for _, seed := range manager.config.Seeds {
+		if hostPort(seed) == address {
+			return true
+		}
+	}
+	manager.mu.Lock()
+	for node := range manager.discovered {
+		if hostPort(node) == address {
+			manager.mu.Unlock()
+			return false
+		}
+	}
+	manager.mu.Unlock()
+	for _, peer := range manager.chain.Peers() {
+		if hostPort(peer) == address {
+			return true
+		}
+	}
+	return false
+}
+
+// hostPort is the address dialed for node, with the default port of its
+// scheme when it has none.
+func hostPort(node string) string {
+	u, err := url.Parse(node)
+	if err != nil {
+		return ""
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func (manager *Manager) SetLogger(logger *slog.Logger) {
+	manager.logger = logger
+}
+
+// Start runs a discovery round now and then every interval until Close.
+func (manager *Manager) Start() {
+	go func() {
+		ticker := time.NewTicker(manager.config.Interval)
+		defer ticker.Stop()
+		for {
+			manager.Run(context.Background())
+			select {
+			case <-ticker.C:
+			case <-manager.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (manager *Manager) Close() {
+	manager.closeOnce.Do(func() { close(manager.quit) })
+}
+
+// Addresses returns the peers to tell others about: this node, its peers
+// and the addresses that worked most recently, at most limit of them.
+func (manager *Manager) Addresses(limit int) []string {
+	seen := make(map[string]bool)
+	addresses := make([]string, 0, limit)
+	add := func(address string) {
+		if address != "" && !seen[address] && len(addresses) < limit {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	add(manager.config.Self)
+	for _, node := range manager.chain.Peers() {
+		add(node)
+	}
+	for _, address := range manager.book.GoodAddresses(limit) {
+		add(address)
+	}
+	return addresses
+}
+
+// Learn records the address a caller advertised, when its host resolves to
+// remoteIP, the address the request came from. Anyone may advertise, so an
+// address of another host is not even looked at; the address is probed like
+// any other before it is used.
+func (manager *Manager) Learn(address string, remoteIP string) error {
+	address = strings.TrimRight(address, "/")
+	if address == manager.config.Self || manager.book.Has(address) {
+		return nil
+	}
+	if err := blockchain.ValidateNode(address); err != nil {
+		return err
+	}
+	ip := net.ParseIP(remoteIP)
+	if ip == nil {
+		return ErrInvalidAddress
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	ips, err := manager.lookup(ctx, address)
+	if err != nil {
+		return err
+	}
+	for _, resolved := range ips {
+		if resolved.Equal(ip) {
+			source := "http://" + remoteIP
+			manager.book.Add([]string{address}, source, map[string]string{address: ipGroup(ip), source: ipGroup(ip)})
+			return nil
+		}
+	}
+	return ErrNotAdvertiser
+}
+
+// lookup resolves the host of address, failing with
+// blockchain.ErrPrivateAddress when it is not public unless
+// config.AllowPrivate is set.
+func (manager *Manager) lookup(ctx context.Context, address string) ([]net.IP, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Hostname()
+	if !manager.config.AllowPrivate {
+		return blockchain.ResolvePublic(ctx, host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// groups resolves addresses, a few at a time, and returns the network group
+// of those that resolve, grouping host names by the address they resolve to
+// so that one host cannot spread over many buckets under many names.
+func (manager *Manager) groups(ctx context.Context, addresses []string) map[string]string {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	groups := make(map[string]string)
+	lookups := make(chan struct{}, maxLookups)
+	for _, address := range addresses {
+		wg.Add(1)
+		lookups <- struct{}{}
+		go func(address string) {
+			defer func() {
+				<-lookups
+				wg.Done()
+			}()
+			if ips, err := manager.lookup(ctx, address); err == nil && len(ips) > 0 {
+				mu.Lock()
+				groups[address] = ipGroup(ips[0])
+				mu.Unlock()
+			}
+		}(address)
+	}
+	wg.Wait()
+	return groups
+}
+
+// Run is one discovery round: exchange addresses with the current peers,
+// connect to new ones up to the target and save the address book.
+func (manager *Manager) Run(ctx context.Context) {
+	ctx, span := trace.Start(ctx, "discovery.Run", trace.SpanKindInternal)
+	defer span.End()
+	if fresh, tried := manager.book.Len(); fresh+tried == 0 {
+		manager.book.Add(manager.config.Seeds, "seed", nil)
+	}
+	manager.exchange(ctx)
+	added := manager.fill(ctx)
+	if err := manager.book.Save(); err != nil {
+		span.RecordError(err)
+		manager.logger.Warn("saving address book failed", "error", err)
+	}
+	fresh, tried := manager.book.Len()
+	addressesNew.Set(float64(fresh))
+	addressesTried.Set(float64(tried))
+	span.SetAttributes(trace.Int("added", added), trace.Int("peers", len(manager.chain.Peers())))
+}
+
+// exchange asks every peer for the peers it knows. New addresses are
+// resolved first; those that do not resolve, or resolve to addresses that
+// are not public, are dropped.
+func (manager *Manager) exchange(ctx context.Context) {
+	for _, node := range manager.chain.Peers() {
+		addresses, err := manager.fetchNodes(ctx, node)
+		if err != nil {
+			manager.book.Failed(node)
+			manager.peerFailed(node, err)
+			continue
+		}
+		manager.book.Good(node)
+		manager.mu.Lock()
+		if _, ok := manager.discovered[node]; ok {
+			manager.discovered[node] = 0
+		}
+		manager.mu.Unlock()
+		var fresh []string
+		for _, address := range addresses {
+			address = strings.TrimRight(address, "/")
+			if len(fresh) == maxAddressesPerAdd {
+				break
+			}
+			if address != manager.config.Self && address != node && !manager.book.Has(address) &&
+				blockchain.ValidateNode(address) == nil {
+				fresh = append(fresh, address)
+			}
+		}
+		if len(fresh) == 0 {
+			continue
+		}
+		groups := manager.groups(ctx, append(fresh, node))
+		if added := manager.book.Add(fresh, node, groups); added > 0 {
+			manager.logger.Debug("learned peer addresses", "peer", node, "added", added)
+		}
+	}
+}
+
+// peerFailed drops a discovered peer after maxPeerFailures failures in a
+// row, making room for another.
+func (manager *Manager) peerFailed(node string, err error) {
+	manager.mu.Lock()
+	failures, ok := manager.discovered[node]
+	if ok {
+		failures++
+		manager.discovered[node] = failures
+		if failures >= maxPeerFailures {
+			delete(manager.discovered, node)
+		}
+	}
+	manager.mu.Unlock()
+	manager.logger.Debug("peer exchange failed", "peer", node, "error", err)
+	if ok && failures >= maxPeerFailures {
+		manager.chain.RemoveNode(node)
+		peersDropped.Inc()
+	}
+}
+
+// fill adds peers from the address book until the chain has the target
+// number, checking first that each is on the same network.
+func (manager *Manager) fill(ctx context.Context) int {
+	peers := manager.chain.Peers()
+	need := manager.config.Target - len(peers)
+	if need <= 0 {
+		return 0
+	}
+	exclude := map[string]bool{manager.config.Self: true}
+	for _, node := range peers {
+		exclude[node] = true
+	}
+	added := 0
+	for attempts := 0; added < need && attempts < need*maxAttemptsRatio; attempts++ {
+		address := manager.book.Select(exclude)
+		if address == "" {
+			break
+		}
+		exclude[address] = true
+		manager.book.Attempt(address)
+		if err := manager.probe(ctx, address); err != nil {
+			manager.book.Failed(address)
+			probesFailed.Inc()
+			manager.logger.Debug("peer probe failed", "peer", address, "error", err)
+			continue
+		}
+		manager.book.Good(address)
+		manager.mu.Lock()
+		manager.discovered[address] = 0
+		manager.mu.Unlock()
+		manager.chain.AddNode(address)
+		added++
+	}
+	return added
+}
+
+// probe checks with GET /status that node runs the same chain.
+func (manager *Manager) probe(ctx context.Context, node string) error {
+	var status struct {
+		NetworkID   string `json:"network_id"`
+		NodeID      string `json:"node_id"`
+		GenesisHash string `json:"genesis_hash"`
+	}
+	if err := manager.get(ctx, node, "/status", &status); err != nil {
+		return err
+	}
+	if manager.config.NodeID != "" && status.NodeID == manager.config.NodeID {
+		return ErrSelf
+	}
+	if status.NetworkID != manager.config.NetworkID {
+		return ErrWrongNetwork
+	}
+	if status.GenesisHash != manager.genesis {
+		return ErrWrongGenesis
+	}
+	return nil
+}
+
+func (manager *Manager) fetchNodes(ctx context.Context, node string) ([]string, error) {
+	var addresses []string
+	if err := manager.get(ctx, node, "/nodes", &addresses); err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+// get decodes the JSON answer of a peer, propagating the trace context.
+func (manager *Manager) get(ctx context.Context, node string, path string, v interface{}) (err error) {
+	ctx, span := trace.Start(ctx, "GET "+path, trace.SpanKindClient, trace.String("peer", node))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	req, err := http.NewRequest("GET", node+path, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	trace.Inject(ctx, req.Header)
+	if manager.config.Self != "" {
+		req.Header.Set(AdvertiseHeader, manager.config.Self)
+	}
+	res, err := manager.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("http status code: %d", res.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(v)
+}
@@ -0,0 +1,136 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"blockchain"
+)
+
+func newTestManager(t *testing.T, config Config) *Manager {
+	engine, err := blockchain.NewProofOfWork(blockchain.DefaultPowParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.NewBlockChain(engine, blockchain.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewManager(chain, NewAddrBook(), config)
+}
+
+// newPeer returns the url of a node on the test network answering GET /nodes
+// with addresses.
+func newPeer(t *testing.T, addresses []string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/status" {
+			json.NewEncoder(w).Encode(map[string]string{"genesis_hash": blockchain.GenesisBlock().Hash})
+			return
+		}
+		json.NewEncoder(w).Encode(addresses)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestLearnRequiresTheAdvertisersAddress(t *testing.T) {
+	manager := newTestManager(t, Config{AllowPrivate: true})
+	if err := manager.Learn("http://127.0.0.1:9000/", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if !manager.book.Has("http://127.0.0.1:9000") {
+		t.Fatal("address of the caller was not learned")
+	}
+	if err := manager.Learn("http://10.1.2.3:9000", "127.0.0.1"); err != ErrNotAdvertiser {
+		t.Fatalf("address of another host: error = %v, want %v", err, ErrNotAdvertiser)
+	}
+	if manager.book.Has("http://10.1.2.3:9000") {
+		t.Fatal("address of another host was learned")
+	}
+}
+
+func TestLearnRefusesPrivateAddresses(t *testing.T) {
+	manager := newTestManager(t, Config{})
+	if err := manager.Learn("http://127.0.0.1:9000", "127.0.0.1"); !errors.Is(err, blockchain.ErrPrivateAddress) {
+		t.Fatalf("error = %v, want %v", err, blockchain.ErrPrivateAddress)
+	}
+	if fresh, tried := manager.book.Len(); fresh+tried != 0 {
+		t.Fatalf("book has %d addresses, want none", fresh+tried)
+	}
+}
+
+func TestExchangeDropsPrivateAddresses(t *testing.T) {
+	manager := newTestManager(t, Config{})
+	peer := newPeer(t, []string{"http://10.0.0.1:8080", "http://192.168.1.1", "http://[::1]:8080", "http://8.8.8.8:8333"})
+	// A peer added by hand may be on the local network.
+	manager.chain.AddNode(peer)
+	manager.exchange(context.Background())
+	for _, address := range []string{"http://10.0.0.1:8080", "http://192.168.1.1", "http://[::1]:8080"} {
+		if manager.book.Has(address) {
+			t.Errorf("private address %s was added", address)
+		}
+	}
+	if !manager.book.Has("http://8.8.8.8:8333") {
+		t.Error("public address was not added")
+	}
+}
+
+func TestFillRefusesPrivateDiscoveredPeers(t *testing.T) {
+	// Names resolving to a private address are caught when dialing.
+	peer := newPeer(t, nil)
+	for _, allowPrivate := range []bool{false, true} {
+		manager := newTestManager(t, Config{AllowPrivate: allowPrivate})
+		manager.book.Add([]string{peer}, "http://8.8.8.8", map[string]string{peer: "8.8.0.0"})
+		want := 0
+		if allowPrivate {
+			want = 1
+		}
+		if added := manager.fill(context.Background()); added != want {
+			t.Fatalf("AllowPrivate %v: added %d peers, want %d", allowPrivate, added, want)
+		}
+	}
+}
+
+func TestHostNamesGroupedByAddress(t *testing.T) {
+	// One host under many names reaches a single new bucket from one source.
+	book := newAddrBook(make([]byte, 32))
+	var names []string
+	groups := map[string]string{"http://8.8.8.8": "8.8.0.0"}
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("http://node%d.example.com", i)
+		names = append(names, name)
+		groups[name] = "203.0.0.0"
+	}
+	if added := book.Add(names, "http://8.8.8.8", groups); added != len(names) {
+		t.Fatalf("added %d addresses, want %d", added, len(names))
+	}
+	buckets := make(map[int]bool)
+	for _, address := range book.entries {
+		buckets[address.bucket] = true
+	}
+	if len(buckets) != 1 {
+		t.Fatalf("addresses of one host spread over %d buckets, want 1", len(buckets))
+	}
+}
+
+func TestDialRefusesPrivateDiscoveredPeers(t *testing.T) {
+	// A discovered peer that passed its probe and then points its name at
+	// a private address is refused on every later request.
+	manager := newTestManager(t, Config{})
+	peer := newPeer(t, nil)
+	manager.chain.AddNode(peer)
+	if _, err := manager.fetchNodes(context.Background(), peer); err != nil {
+		t.Fatalf("peer added by hand: %v", err)
+	}
+	// Open connections stay on the address they were made to.
+	manager.client.CloseIdleConnections()
+	manager.discovered[peer] = 0
+	if _, err := manager.fetchNodes(context.Background(), peer); !errors.Is(err, blockchain.ErrPrivateAddress) {
+		t.Fatalf("discovered peer: error = %v, want %v", err, blockchain.ErrPrivateAddress)
+	}
+}
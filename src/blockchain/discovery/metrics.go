@@ -0,0 +1,18 @@
+package discovery
+
+import "blockchain/metrics"
+
+var (
+	addressesNew = metrics.NewGauge(
+		"discovery_addresses_new",
+		"Number of untried addresses in the address book.")
+	addressesTried = metrics.NewGauge(
+		"discovery_addresses_tried",
+		"Number of addresses in the address book that worked.")
+	probesFailed = metrics.NewCounter(
+		"discovery_probes_failed_total",
+		"Number of candidate peers that failed the status check.")
+	peersDropped = metrics.NewCounter(
+		"discovery_peers_dropped_total",
+		"Number of discovered peers dropped after failing repeatedly.")
+)
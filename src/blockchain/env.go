@@ -0,0 +1,66 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ConsensusEngineFromEnv configures the consensus engine from the
+// environment variables shared by the node and its tools: CONSENSUS selects
+// pow (the default) or poa, POW_ALGORITHM and POW_DIFFICULTY tune proof of
+// work, and POA_VALIDATORS lists the validators of proof of authority.
+// POA_KEY, when set, lets the engine seal blocks.
+func ConsensusEngineFromEnv() (ConsensusEngine, error) {
+	if os.Getenv("CONSENSUS") != "poa" {
+		params, err := powParamsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		engine, err := NewProofOfWork(params)
+		if err != nil {
+			return nil, err
+		}
+		return engine, nil
+	}
+	var key *ecdsa.PrivateKey
+	if s := os.Getenv("POA_KEY"); s != "" {
+		k, err := ParseValidatorKey(s)
+		if err != nil {
+			return nil, err
+		}
+		key = k
+	}
+	engine, err := NewProofOfAuthority(SplitList(os.Getenv("POA_VALIDATORS")), key)
+	if err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
+func powParamsFromEnv() (PowParams, error) {
+	params := DefaultPowParams
+	if os.Getenv("POW_ALGORITHM") == PowScrypt {
+		params = DefaultScryptParams
+	}
+	if s := os.Getenv("POW_DIFFICULTY"); s != "" {
+		difficulty, err := strconv.Atoi(s)
+		if err != nil {
+			return params, err
+		}
+		params.Difficulty = difficulty
+	}
+	return params, nil
+}
+
+// SplitList splits a comma separated list, dropping blanks.
+func SplitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
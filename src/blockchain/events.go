@@ -0,0 +1,168 @@
+package blockchain
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	EventBlock       = "block"
+	EventTransaction = "transaction"
+	EventReorg       = "reorg"
+	EventPeer        = "peer"
+	// EventGap is never published. Event streams send it last when their
+	// subscriber missed Dropped events, so that clients know to resync.
+	EventGap = "gap"
+)
+
+const subscriptionBuffer = 64
+
+type Reorg struct {
+	ForkHeight int     `json:"fork_height"`
+	Removed    []Block `json:"removed"`
+	Added      []Block `json:"added"`
+}
+
+type Event struct {
+	Type        string       `json:"type"`
+	Block       *Block       `json:"block,omitempty"`
+	Transaction *Transaction `json:"transaction,omitempty"`
+	Reorg       *Reorg       `json:"reorg,omitempty"`
+	Peer        string       `json:"peer,omitempty"`
+	Dropped     uint64       `json:"dropped,omitempty"`
+}
+
+func (event *Event) addresses() map[string]bool {
+	addresses := make(map[string]bool)
+	addBlock := func(block *Block) {
+		for i := range block.Transactions {
+			for _, address := range block.Transactions[i].addresses() {
+				addresses[address] = true
+			}
+		}
+	}
+	if event.Block != nil {
+		addBlock(event.Block)
+	}
+	if event.Transaction != nil {
+		for _, address := range event.Transaction.addresses() {
+			addresses[address] = true
+		}
+	}
+	if event.Reorg != nil {
+		for i := range event.Reorg.Removed {
+			addBlock(&event.Reorg.Removed[i])
+		}
+		for i := range event.Reorg.Added {
+			addBlock(&event.Reorg.Added[i])
+		}
+	}
+	return addresses
+}
+
+type EventFilter struct {
+	Types     []string
+	Addresses []string
+}
+
+func (filter *EventFilter) Match(event *Event) bool {
+	if len(filter.Types) > 0 && !contains(filter.Types, event.Type) {
+		return false
+	}
+	if len(filter.Addresses) == 0 {
+		return true
+	}
+	addresses := event.addresses()
+	for _, address := range filter.Addresses {
+		if addresses[address] {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+type Subscription struct {
+	C       <-chan Event
+	c       chan Event
+	filter  EventFilter
+	bus     *EventBus
+	once    sync.Once
+	dropped uint64
+}
+
+// Dropped returns the number of events the subscription missed because its
+// buffer was full.
+func (sub *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+func (sub *Subscription) Close() {
+	sub.once.Do(func() {
+		sub.bus.unsubscribe(sub)
+	})
+}
+
+// EventBus fans events out to subscribers. Slow subscribers miss events
+// instead of blocking the chain, which Subscription.Dropped reports.
+// Listeners are called synchronously and never miss an event, so they must
+// not block.
+type EventBus struct {
+	mu        sync.Mutex
+	subs      map[*Subscription]struct{}
+	listeners []func(Event)
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+func (bus *EventBus) Subscribe(filter EventFilter) *Subscription {
+	c := make(chan Event, subscriptionBuffer)
+	sub := &Subscription{C: c, c: c, filter: filter, bus: bus}
+	bus.mu.Lock()
+	bus.subs[sub] = struct{}{}
+	bus.mu.Unlock()
+	return sub
+}
+
+func (bus *EventBus) Listen(listener func(Event)) {
+	bus.mu.Lock()
+	bus.listeners = append(bus.listeners, listener)
+	bus.mu.Unlock()
+}
+
+func (bus *EventBus) unsubscribe(sub *Subscription) {
+	bus.mu.Lock()
+	delete(bus.subs, sub)
+	bus.mu.Unlock()
+	close(sub.c)
+}
+
+func (bus *EventBus) Publish(event Event) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	for _, listener := range bus.listeners {
+		listener(event)
+	}
+	for sub := range bus.subs {
+		if !sub.filter.Match(&event) {
+			continue
+		}
+		select {
+		case sub.c <- event:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
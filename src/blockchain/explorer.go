@@ -0,0 +1,122 @@
+package blockchain
+
+type TransactionInfo struct {
+	TxID          string      `json:"txid"`
+	Transaction   Transaction `json:"transaction"`
+	BlockHash     string      `json:"block_hash"`
+	BlockHeight   int         `json:"block_height"`
+	Position      int         `json:"position"`
+	Confirmations int         `json:"confirmations"`
+}
+
+func (blockChain *BlockChain) Height() int {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	return len(blockChain.Chain) - 1
+}
+
+func (blockChain *BlockChain) BlockByHeight(height int) (*Block, bool) {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	return blockChain.blockByHeight(height)
+}
+
+func (blockChain *BlockChain) blockByHeight(height int) (*Block, bool) {
+	if height < 0 || height >= len(blockChain.Chain) {
+		return nil, false
+	}
+	block := blockChain.Chain[height]
+	return &block, true
+}
+
+func (blockChain *BlockChain) BlockByHash(hash string) (*Block, bool) {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	height, ok := blockChain.index.blocks[hash]
+	if !ok {
+		return nil, false
+	}
+	return blockChain.blockByHeight(height)
+}
+
+// ListBlocks returns up to limit blocks below the height before, newest first.
+func (blockChain *BlockChain) ListBlocks(before int, limit int) []Block {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	if before > len(blockChain.Chain) {
+		before = len(blockChain.Chain)
+	}
+	var blocks []Block
+	for height := before - 1; height >= 0 && len(blocks) < limit; height-- {
+		blocks = append(blocks, blockChain.Chain[height])
+	}
+	return blocks
+}
+
+func (blockChain *BlockChain) Transaction(txid string) (*TransactionInfo, bool) {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	return blockChain.transactionInfo(txid)
+}
+
+func (blockChain *BlockChain) transactionInfo(txid string) (*TransactionInfo, bool) {
+	location, ok := blockChain.index.transactions[txid]
+	if !ok {
+		return nil, false
+	}
+	block := &blockChain.Chain[location.Height]
+	if block.Pruned {
+		return nil, false
+	}
+	return &TransactionInfo{
+		TxID:          txid,
+		Transaction:   block.Transactions[location.Position],
+		BlockHash:     block.Hash,
+		BlockHeight:   block.Height,
+		Position:      location.Position,
+		Confirmations: len(blockChain.Chain) - block.Height,
+	}, true
+}
+
+// TransactionProof is a confirmed transaction with the merkle path that
+// links it to the merkle hash of its block header.
+type TransactionProof struct {
+	TransactionInfo
+	Size  int      `json:"size"`
+	Proof []string `json:"proof"`
+}
+
+// TransactionProof returns the merkle proof of a confirmed transaction. It
+// is not available once the transaction's block is pruned.
+func (blockChain *BlockChain) TransactionProof(txid string) (*TransactionProof, bool) {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	info, ok := blockChain.transactionInfo(txid)
+	if !ok {
+		return nil, false
+	}
+	transactions := blockChain.Chain[info.BlockHeight].Transactions
+	proof, err := MerkleProof(transactions, info.Position)
+	if err != nil {
+		return nil, false
+	}
+	return &TransactionProof{TransactionInfo: *info, Size: len(transactions), Proof: proof}, true
+}
+
+func (blockChain *BlockChain) AddressTransactions(address string) []TransactionInfo {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	var transactions []TransactionInfo
+	for _, txid := range blockChain.index.addresses[address] {
+		if info, ok := blockChain.transactionInfo(txid); ok {
+			transactions = append(transactions, *info)
+		}
+	}
+	return transactions
+}
+
+func (blockChain *BlockChain) Balance(address string) int64 {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	return blockChain.index.balances[address]
+}
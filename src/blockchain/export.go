@@ -0,0 +1,116 @@
+package blockchain
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// An export file starts with exportMagic and a version byte, followed by one
+// record per block: a big-endian uint32 payload length, the block as JSON
+// and a CRC-32C of the payload.
+const (
+	exportMagic      = "BCHN"
+	exportVersion    = 1
+	maxExportRecord  = 64 << 20
+	exportRecordHead = 4
+	exportRecordTail = 4
+)
+
+var (
+	ErrExportFormat   = errors.New("not a chain export file")
+	ErrExportVersion  = errors.New("unsupported chain export version")
+	ErrExportChecksum = errors.New("chain export record checksum mismatch")
+	ErrExportRecord   = errors.New("chain export record too large")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type ChainWriter struct {
+	w *bufio.Writer
+}
+
+// NewChainWriter writes the export header to w.
+func NewChainWriter(w io.Writer) (*ChainWriter, error) {
+	writer := &ChainWriter{w: bufio.NewWriter(w)}
+	if _, err := writer.w.WriteString(exportMagic); err != nil {
+		return nil, err
+	}
+	if err := writer.w.WriteByte(exportVersion); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (writer *ChainWriter) WriteBlock(block *Block) error {
+	payload, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	var head [exportRecordHead]byte
+	binary.BigEndian.PutUint32(head[:], uint32(len(payload)))
+	var tail [exportRecordTail]byte
+	binary.BigEndian.PutUint32(tail[:], crc32.Checksum(payload, castagnoli))
+	for _, b := range [][]byte{head[:], payload, tail[:]} {
+		if _, err := writer.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (writer *ChainWriter) Flush() error {
+	return writer.w.Flush()
+}
+
+type ChainReader struct {
+	r *bufio.Reader
+}
+
+// NewChainReader checks the export header of r.
+func NewChainReader(r io.Reader) (*ChainReader, error) {
+	reader := &ChainReader{r: bufio.NewReader(r)}
+	header := make([]byte, len(exportMagic)+1)
+	if _, err := io.ReadFull(reader.r, header); err != nil {
+		return nil, ErrExportFormat
+	}
+	if string(header[:len(exportMagic)]) != exportMagic {
+		return nil, ErrExportFormat
+	}
+	if header[len(exportMagic)] != exportVersion {
+		return nil, ErrExportVersion
+	}
+	return reader, nil
+}
+
+// ReadBlock returns the next block, or io.EOF after the last one. A record
+// cut short returns io.ErrUnexpectedEOF.
+func (reader *ChainReader) ReadBlock() (*Block, error) {
+	var head [exportRecordHead]byte
+	if _, err := io.ReadFull(reader.r, head[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(head[:])
+	if size > maxExportRecord {
+		return nil, ErrExportRecord
+	}
+	record := make([]byte, int(size)+exportRecordTail)
+	if _, err := io.ReadFull(reader.r, record); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	payload := record[:size]
+	if binary.BigEndian.Uint32(record[size:]) != crc32.Checksum(payload, castagnoli) {
+		return nil, ErrExportChecksum
+	}
+	var block Block
+	if err := json.Unmarshal(payload, &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
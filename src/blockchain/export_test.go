@@ -0,0 +1,101 @@
+package blockchain
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// exportChain returns the export of the blocks of chain from height from.
+func exportChain(t *testing.T, chain *BlockChain, from int) []byte {
+	var buffer bytes.Buffer
+	writer, err := NewChainWriter(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.Export(writer, from); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// exportBlocks writes blocks as they are, valid or not.
+func exportBlocks(t *testing.T, blocks []Block) []byte {
+	var buffer bytes.Buffer
+	writer, err := NewChainWriter(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range blocks {
+		if err := writer.WriteBlock(&blocks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func newChainReader(t *testing.T, data []byte) *ChainReader {
+	reader, err := NewChainReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	chain := newTestChain(t)
+	mineBlocks(t, chain, GenesisTimestamp+1, GenesisTimestamp+2)
+	reader := newChainReader(t, exportChain(t, chain, 0))
+	var blocks []Block
+	for {
+		block, err := reader.ReadBlock()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, *block)
+	}
+	if !reflect.DeepEqual(blocks, chain.Blocks()) {
+		t.Fatalf("read %d blocks that differ from the %d exported", len(blocks), chain.Height()+1)
+	}
+}
+
+func TestReadBlockChecksumMismatch(t *testing.T) {
+	data := exportChain(t, newTestChain(t), 0)
+	// Flip a bit of the payload of the first record.
+	data[len(exportMagic)+1+exportRecordHead+2] ^= 1
+	if _, err := newChainReader(t, data).ReadBlock(); err != ErrExportChecksum {
+		t.Fatalf("error = %v, want %v", err, ErrExportChecksum)
+	}
+}
+
+func TestReadBlockTruncatedRecord(t *testing.T) {
+	data := exportChain(t, newTestChain(t), 0)
+	for _, cut := range []int{1, exportRecordTail + 1, len(data) - len(exportMagic) - 1 - exportRecordHead} {
+		if _, err := newChainReader(t, data[:len(data)-cut]).ReadBlock(); err != io.ErrUnexpectedEOF {
+			t.Errorf("record cut by %d bytes: error = %v, want %v", cut, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestNewChainReaderChecksHeader(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		want error
+	}{
+		{"", ErrExportFormat},
+		{"BCH", ErrExportFormat},
+		{"JSON\x01", ErrExportFormat},
+		{exportMagic + "\x02", ErrExportVersion},
+	} {
+		if _, err := NewChainReader(bytes.NewReader([]byte(test.data))); err != test.want {
+			t.Errorf("NewChainReader(%q) error = %v, want %v", test.data, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrImportConflict = errors.New("imported block conflicts with the local chain")
+
+// ImportError reports the height of the block an import stopped at.
+type ImportError struct {
+	Height int
+	Err    error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("importing block %d: %v", e.Height, e.Err)
+}
+
+// Import validates the blocks of reader and appends them to the chain.
+// Blocks the chain already has are skipped after checking that they match,
+// so an interrupted import can be resumed with the same file, and a file
+// exported from a later height can be imported once the chain reaches it.
+// It returns the number of blocks appended.
+func (blockChain *BlockChain) Import(reader *ChainReader) (int, error) {
+	blockChain.mu.Lock()
+	defer blockChain.mu.Unlock()
+
+	imported := 0
+	defer func() {
+		if imported > 0 {
+			blockChain.logger.Info("blocks imported", "blocks", imported, "height", len(blockChain.Chain)-1)
+		}
+	}()
+	for {
+		block, err := reader.ReadBlock()
+		if err == io.EOF {
+			return imported, blockChain.store.SaveIndex(blockChain.index)
+		}
+		if err != nil {
+			blockChain.saveIndex()
+			return imported, err
+		}
+
+		if block.Height < len(blockChain.Chain) {
+			if block.Height < 0 || blockChain.Chain[block.Height].Hash != block.Hash {
+				err = ErrImportConflict
+			}
+		} else if block.Pruned {
+			err = ErrPrunedBlock
+		} else {
+			err = validateBlock(blockChain.engine, blockChain.lastBlock(), block)
+			if err == nil {
+				err = blockChain.index.checkNewTransactions([]Block{*block}, block.Height)
+			}
+			if err == nil {
+				err = blockChain.appendBlock(block)
+			}
+			if err == nil {
+				imported++
+				blockChain.events.Publish(Event{Type: EventBlock, Block: block})
+				blockChain.maybeSnapshot()
+			}
+		}
+		if err != nil {
+			blockChain.saveIndex()
+			return imported, &ImportError{Height: block.Height, Err: err}
+		}
+	}
+}
+
+// Export writes the blocks from height from to the tip to writer. Pruned
+// blocks cannot be exported.
+func (blockChain *BlockChain) Export(writer *ChainWriter, from int) (int, error) {
+	blocks := blockChain.Blocks()
+	if from < 0 {
+		from = 0
+	}
+	if pruned := blockChain.PrunedHeight(); from <= pruned {
+		return 0, fmt.Errorf("%v up to height %d; export from height %d", ErrPrunedBlock, pruned, pruned+1)
+	}
+	exported := 0
+	for i := from; i < len(blocks); i++ {
+		if err := writer.WriteBlock(&blocks[i]); err != nil {
+			return exported, err
+		}
+		exported++
+	}
+	return exported, writer.Flush()
+}
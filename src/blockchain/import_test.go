@@ -0,0 +1,69 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestImportResumesPartialImport(t *testing.T) {
+	source := newTestChain(t)
+	mineBlocks(t, source, GenesisTimestamp+1, GenesisTimestamp+2, GenesisTimestamp+3)
+	data := exportChain(t, source, 0)
+
+	// An import interrupted after the first block.
+	chain := newTestChain(t)
+	mineBlocks(t, chain, GenesisTimestamp+1)
+	imported, err := chain.Import(newChainReader(t, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 2 || chain.TipHash() != source.TipHash() {
+		t.Fatalf("imported %d blocks up to %s, want 2 up to %s", imported, chain.TipHash(), source.TipHash())
+	}
+	// Importing again changes nothing.
+	if imported, err := chain.Import(newChainReader(t, data)); imported != 0 || err != nil {
+		t.Fatalf("second import = %d, %v, want 0, nil", imported, err)
+	}
+}
+
+func TestImportStopsAtInvalidBlock(t *testing.T) {
+	source := newTestChain(t)
+	mineBlocks(t, source, GenesisTimestamp+1, GenesisTimestamp+2, GenesisTimestamp+3)
+	blocks := source.Blocks()
+	blocks[2].Timestamp++
+	data := exportBlocks(t, blocks)
+
+	// A failure to save the index does not hide the invalid block.
+	chain, store := newFailingChain(t)
+	store.failSaveIndex = true
+	imported, err := chain.Import(newChainReader(t, data))
+	var importErr *ImportError
+	if !errors.As(err, &importErr) || importErr.Height != 2 || importErr.Err != ErrInvalidHash {
+		t.Fatalf("error = %v, want block 2: %v", err, ErrInvalidHash)
+	}
+	if imported != 1 || chain.Height() != 1 {
+		t.Fatalf("imported %d blocks to height %d, want 1 to 1", imported, chain.Height())
+	}
+}
+
+func TestImportConflict(t *testing.T) {
+	source := newTestChain(t)
+	mineBlocks(t, source, GenesisTimestamp+1)
+	chain := newTestChain(t)
+	mineBlocks(t, chain, GenesisTimestamp+2)
+	_, err := chain.Import(newChainReader(t, exportChain(t, source, 0)))
+	var importErr *ImportError
+	if !errors.As(err, &importErr) || importErr.Height != 1 || importErr.Err != ErrImportConflict {
+		t.Fatalf("error = %v, want block 1: %v", err, ErrImportConflict)
+	}
+}
+
+func TestImportReportsIndexSaveFailure(t *testing.T) {
+	source := newTestChain(t)
+	mineBlocks(t, source, GenesisTimestamp+1)
+	chain, store := newFailingChain(t)
+	store.failSaveIndex = true
+	if _, err := chain.Import(newChainReader(t, exportChain(t, source, 0))); err != errStoreFailed {
+		t.Fatalf("error = %v, want %v", err, errStoreFailed)
+	}
+}
@@ -0,0 +1,173 @@
+package blockchain
+
+import "encoding/json"
+
+type TxLocation struct {
+	Height   int `json:"height"`
+	Position int `json:"position"`
+}
+
+// indexVersion is bumped when the index gains data, so that indexes saved
+// by older versions are rebuilt.
+const indexVersion = 2
+
+type Index struct {
+	version      int
+	tip          string
+	blocks       map[string]int
+	transactions map[string]TxLocation
+	addresses    map[string][]string
+	balances     map[string]int64
+}
+
+type indexData struct {
+	Version      int                   `json:"version"`
+	Tip          string                `json:"tip"`
+	Blocks       map[string]int        `json:"blocks"`
+	Transactions map[string]TxLocation `json:"transactions"`
+	Addresses    map[string][]string   `json:"addresses"`
+	Balances     map[string]int64      `json:"balances"`
+}
+
+func NewIndex() *Index {
+	return &Index{
+		version:      indexVersion,
+		blocks:       make(map[string]int),
+		transactions: make(map[string]TxLocation),
+		addresses:    make(map[string][]string),
+		balances:     make(map[string]int64),
+	}
+}
+
+func BuildIndex(chain []Block) *Index {
+	index := NewIndex()
+	for i := range chain {
+		index.addBlock(&chain[i])
+	}
+	return index
+}
+
+func (index *Index) Tip() string {
+	return index.tip
+}
+
+func (index *Index) clone() *Index {
+	clone := NewIndex()
+	clone.version = index.version
+	clone.tip = index.tip
+	for k, v := range index.blocks {
+		clone.blocks[k] = v
+	}
+	for k, v := range index.transactions {
+		clone.transactions[k] = v
+	}
+	for k, v := range index.addresses {
+		clone.addresses[k] = append([]string(nil), v...)
+	}
+	for k, v := range index.balances {
+		clone.balances[k] = v
+	}
+	return clone
+}
+
+func (index *Index) addBlock(block *Block) {
+	index.blocks[block.Hash] = block.Height
+	for i := range block.Transactions {
+		transaction := &block.Transactions[i]
+		txid := transaction.Hash()
+		index.transactions[txid] = TxLocation{Height: block.Height, Position: i}
+		for _, address := range transaction.addresses() {
+			index.addresses[address] = append(index.addresses[address], txid)
+		}
+		index.credit(transaction.Recipient, transaction.Amount)
+		index.credit(transaction.Sender, -transaction.Amount)
+	}
+	index.tip = block.Hash
+}
+
+// checkNewTransactions reports ErrDuplicateTransaction when blocks, which
+// follow the block at height fork-1, repeat a transaction of the chain below
+// fork or of an earlier one of blocks.
+func (index *Index) checkNewTransactions(blocks []Block, fork int) error {
+	seen := make(map[string]bool)
+	for i := range blocks {
+		for j := range blocks[i].Transactions {
+			txid := blocks[i].Transactions[j].Hash()
+			if location, ok := index.transactions[txid]; ok && location.Height < fork || seen[txid] {
+				return ErrDuplicateTransaction
+			}
+			seen[txid] = true
+		}
+	}
+	return nil
+}
+
+// credit adjusts the balance of address, dropping zero balances so that
+// equal states have equal indexes.
+func (index *Index) credit(address string, amount int64) {
+	if balance := index.balances[address] + amount; balance != 0 {
+		index.balances[address] = balance
+	} else {
+		delete(index.balances, address)
+	}
+}
+
+// removeBlock undoes addBlock. Blocks must be removed from the tip down.
+func (index *Index) removeBlock(block *Block) {
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		transaction := &block.Transactions[i]
+		txid := transaction.Hash()
+		if location, ok := index.transactions[txid]; ok && location.Height == block.Height {
+			delete(index.transactions, txid)
+		}
+		for _, address := range transaction.addresses() {
+			txids := index.addresses[address]
+			if n := len(txids); n > 0 && txids[n-1] == txid {
+				txids = txids[:n-1]
+			}
+			if len(txids) == 0 {
+				delete(index.addresses, address)
+			} else {
+				index.addresses[address] = txids
+			}
+		}
+		index.credit(transaction.Recipient, -transaction.Amount)
+		index.credit(transaction.Sender, transaction.Amount)
+	}
+	delete(index.blocks, block.Hash)
+	index.tip = block.PreviousHash
+}
+
+func (index *Index) MarshalJSON() ([]byte, error) {
+	return json.Marshal(indexData{
+		Version:      index.version,
+		Tip:          index.tip,
+		Blocks:       index.blocks,
+		Transactions: index.transactions,
+		Addresses:    index.addresses,
+		Balances:     index.balances,
+	})
+}
+
+func (index *Index) UnmarshalJSON(data []byte) error {
+	var d indexData
+	if err := json.Unmarshal(data, &d); err != nil {
+		return err
+	}
+	*index = *NewIndex()
+	index.version = d.Version
+	index.tip = d.Tip
+	for k, v := range d.Blocks {
+		index.blocks[k] = v
+	}
+	for k, v := range d.Transactions {
+		index.transactions[k] = v
+	}
+	for k, v := range d.Addresses {
+		index.addresses[k] = v
+	}
+	for k, v := range d.Balances {
+		index.balances[k] = v
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+)
+
+func newTestChain(t *testing.T) *BlockChain {
+	params := DefaultPowParams
+	params.Difficulty = 1
+	engine, err := NewProofOfWork(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := NewBlockChain(engine, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+func TestAddTransactionRejectsDuplicate(t *testing.T) {
+	chain := newTestChain(t)
+	ctx := context.Background()
+	for {
+		first := &Transaction{Sender: "alice", Recipient: "bob", Amount: 5}
+		if err := chain.AddTransaction(ctx, first); err != nil {
+			t.Fatal(err)
+		}
+		second := &Transaction{Sender: "alice", Recipient: "bob", Amount: 5}
+		err := chain.AddTransaction(ctx, second)
+		if second.Timestamp != first.Timestamp {
+			// A second passed in between, so the txids differ.
+			continue
+		}
+		if err != ErrDuplicateTransaction {
+			t.Fatalf("AddTransaction of a pooled txid: error = %v, want %v", err, ErrDuplicateTransaction)
+		}
+		return
+	}
+}
+
+func TestValidateBlockRejectsRepeatedTransaction(t *testing.T) {
+	chain := newTestChain(t)
+	transaction := Transaction{Timestamp: 1, Sender: "alice", Recipient: "bob", Amount: 5}
+	block, err := NewBlock(1, GenesisTimestamp+1, chain.TipHash(), []Transaction{transaction, transaction})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.engine.Seal(block); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AddBlock(context.Background(), block); err != ErrDuplicateTransaction {
+		t.Fatalf("AddBlock error = %v, want %v", err, ErrDuplicateTransaction)
+	}
+}
+
+func TestAddBlockRejectsTransactionOnChain(t *testing.T) {
+	chain := newTestChain(t)
+	ctx := context.Background()
+	transaction := Transaction{Timestamp: 1, Sender: "alice", Recipient: "bob", Amount: 5}
+	for height := 1; height <= 2; height++ {
+		block, err := NewBlock(height, GenesisTimestamp+int64(height), chain.TipHash(), []Transaction{transaction})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := chain.engine.Seal(block); err != nil {
+			t.Fatal(err)
+		}
+		err = chain.AddBlock(ctx, block)
+		if height == 1 && err != nil {
+			t.Fatal(err)
+		}
+		if height == 2 && err != ErrDuplicateTransaction {
+			t.Fatalf("AddBlock repeating a txid: error = %v, want %v", err, ErrDuplicateTransaction)
+		}
+	}
+	if txids := chain.index.addresses["alice"]; len(txids) != 1 {
+		t.Fatalf("alice has %d indexed transactions, want 1", len(txids))
+	}
+}
+
+func TestReorgReconcilesPool(t *testing.T) {
+	chain := newTestChain(t)
+	ctx := context.Background()
+	mined := Transaction{Timestamp: 1, Sender: "alice", Recipient: "bob", Amount: 5}
+	pending := Transaction{Timestamp: 2, Sender: "carol", Recipient: "dave", Amount: 7}
+	if _, err := chain.AddRelayedTransaction(ctx, &mined); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, chain, GenesisTimestamp+1)
+	if _, err := chain.AddRelayedTransaction(ctx, &pending); err != nil {
+		t.Fatal(err)
+	}
+
+	// A longer branch from the genesis includes the pending transaction
+	// and not the mined one.
+	peer := newTestChain(t)
+	if _, err := peer.AddRelayedTransaction(ctx, &pending); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, peer, GenesisTimestamp+10, GenesisTimestamp+11)
+	if replaced, err := chain.AddBranch(ctx, peer.Blocks()[1:]); err != nil || !replaced {
+		t.Fatalf("AddBranch = %v, %v", replaced, err)
+	}
+	pool := chain.PendingTransactions()
+	if len(pool) != 1 || pool[0].Hash() != mined.Hash() {
+		t.Fatalf("pool = %+v, want only the transaction of the removed block", pool)
+	}
+	if len(chain.pooled) != 1 || chain.pooled[mined.Hash()] != 1 {
+		t.Fatalf("pooled = %v, want only %s", chain.pooled, mined.Hash())
+	}
+}
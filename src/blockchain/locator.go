@@ -0,0 +1,107 @@
+package blockchain
+
+import (
+	"context"
+
+	"blockchain/trace"
+)
+
+// locatorDense is the number of most recent blocks listed one by one in a
+// locator before the steps start doubling.
+const locatorDense = 10
+
+// Locator lists block hashes from the tip back to the genesis, densely near
+// the tip and sparsely below, so that a peer can find the last block both
+// chains share without receiving the whole chain.
+func (blockChain *BlockChain) Locator() []string {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	return BuildLocator(blockChain.Chain)
+}
+
+// BuildLocator returns the locator of chain, which starts at the genesis;
+// light clients use it for their header chains.
+func BuildLocator(chain []Block) []string {
+	var locator []string
+	step := 1
+	for height := len(chain) - 1; height > 0; height -= step {
+		locator = append(locator, chain[height].Hash)
+		if len(locator) >= locatorDense {
+			step *= 2
+		}
+	}
+	return append(locator, chain[0].Hash)
+}
+
+// BlocksAfter returns the hashes of up to limit blocks following the first
+// locator hash found in the chain, or following the genesis when none is.
+func (blockChain *BlockChain) BlocksAfter(locator []string, limit int) []string {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	from := 0
+	for _, hash := range locator {
+		if height, ok := blockChain.index.blocks[hash]; ok {
+			from = height
+			break
+		}
+	}
+	var hashes []string
+	for height := from + 1; height < len(blockChain.Chain) && len(hashes) < limit; height++ {
+		hashes = append(hashes, blockChain.Chain[height].Hash)
+	}
+	return hashes
+}
+
+// PooledTransaction returns the transaction with txid from the pool.
+func (blockChain *BlockChain) PooledTransaction(txid string) (*Transaction, bool) {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	if blockChain.pooled[txid] == 0 {
+		return nil, false
+	}
+	for i := range blockChain.TransactionPool {
+		if blockChain.TransactionPool[i].Hash() == txid {
+			transaction := blockChain.TransactionPool[i]
+			return &transaction, true
+		}
+	}
+	return nil, false
+}
+
+// AddBranch switches to a side branch received from a peer if the consensus
+// engine prefers it. The branch must follow a block of the chain; it returns
+// ErrOrphanBlock otherwise, and false while the branch does not win yet.
+func (blockChain *BlockChain) AddBranch(ctx context.Context, branch []Block) (replaced bool, err error) {
+	_, span := trace.Start(ctx, "blockchain.AddBranch", trace.SpanKindInternal, trace.Int("blocks", len(branch)))
+	defer func() {
+		span.SetAttributes(trace.Bool("replaced", replaced))
+		span.RecordError(err)
+		span.End()
+	}()
+	if len(branch) == 0 {
+		return false, nil
+	}
+	blockChain.mu.Lock()
+	defer blockChain.mu.Unlock()
+	parent, ok := blockChain.index.blocks[branch[0].PreviousHash]
+	if !ok {
+		return false, ErrOrphanBlock
+	}
+	chain := append(append([]Block(nil), blockChain.Chain[:parent+1]...), branch...)
+	if !blockChain.engine.ChooseFork(blockChain.Chain, chain) {
+		return false, nil
+	}
+	for height := parent + 1; height < len(chain); height++ {
+		if err := validateBlock(blockChain.engine, &chain[height-1], &chain[height]); err != nil {
+			return false, &ImportError{Height: height, Err: err}
+		}
+	}
+	if err := blockChain.index.checkNewTransactions(branch, parent+1); err != nil {
+		return false, err
+	}
+	if err := blockChain.replaceChain(chain); err != nil {
+		return false, err
+	}
+	consensusReplacementsTotal.Inc()
+	return true, nil
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package blockchain
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on file, held until it is closed.
+func lockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrStoreLocked
+	}
+	return err
+}
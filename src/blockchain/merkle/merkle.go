@@ -0,0 +1,121 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+const (
+	leafPrefix  = 0x00
+	innerPrefix = 0x01
+)
+
+var ErrIndexOutOfRange = errors.New("merkle: index out of range")
+
+// emptyRoot is the root of a tree with no leaves.
+var emptyRoot = sha256.Sum256(nil)
+
+// LeafHash hashes a leaf with a domain separation prefix so that a leaf can
+// never be confused with an inner node.
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func innerHash(left []byte, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{innerPrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// splitPoint returns the largest power of two smaller than n.
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// Root computes the root of the tree over leaves. Odd nodes are never
+// duplicated: the tree is split at the largest power of two, as in RFC 6962.
+func Root(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		root := emptyRoot
+		return root[:]
+	}
+	hashes := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i] = LeafHash(leaf)
+	}
+	return rootOf(hashes)
+}
+
+func rootOf(hashes [][]byte) []byte {
+	if len(hashes) == 1 {
+		return hashes[0]
+	}
+	k := splitPoint(len(hashes))
+	return innerHash(rootOf(hashes[:k]), rootOf(hashes[k:]))
+}
+
+// Proof returns the audit path for the leaf at index, ordered from the leaf
+// up to the root.
+func Proof(leaves [][]byte, index int) ([][]byte, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, ErrIndexOutOfRange
+	}
+	hashes := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i] = LeafHash(leaf)
+	}
+	return proofOf(hashes, index), nil
+}
+
+func proofOf(hashes [][]byte, index int) [][]byte {
+	if len(hashes) == 1 {
+		return nil
+	}
+	k := splitPoint(len(hashes))
+	if index < k {
+		return append(proofOf(hashes[:k], index), rootOf(hashes[k:]))
+	}
+	return append(proofOf(hashes[k:], index-k), rootOf(hashes[:k]))
+}
+
+// VerifyProof checks that leaf is at index in a tree of size leaves with the
+// given root.
+func VerifyProof(root []byte, leaf []byte, index int, size int, proof [][]byte) bool {
+	if index < 0 || index >= size {
+		return false
+	}
+	hash, rest, ok := climb(LeafHash(leaf), index, size, proof)
+	return ok && len(rest) == 0 && bytes.Equal(hash, root)
+}
+
+func climb(hash []byte, index int, size int, proof [][]byte) ([]byte, [][]byte, bool) {
+	if size == 1 {
+		return hash, proof, true
+	}
+	k := splitPoint(size)
+	var sub []byte
+	var ok bool
+	if index < k {
+		sub, proof, ok = climb(hash, index, k, proof)
+	} else {
+		sub, proof, ok = climb(hash, index-k, size-k, proof)
+	}
+	if !ok || len(proof) == 0 {
+		return nil, nil, false
+	}
+	sibling := proof[0]
+	if index < k {
+		return innerHash(sub, sibling), proof[1:], true
+	}
+	return innerHash(sibling, sub), proof[1:], true
+}
@@ -0,0 +1,164 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// rfc6962Leaves and rfc6962Roots are the test vectors of the certificate
+// transparency reference implementation: the root of the first n leaves.
+var rfc6962Leaves = []string{
+	"",
+	"00",
+	"10",
+	"2021",
+	"3031",
+	"40414243",
+	"5051525354555657",
+	"606162636465666768696a6b6c6d6e6f",
+}
+
+var rfc6962Roots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+func decodeLeaves(t *testing.T, encoded []string) [][]byte {
+	leaves := make([][]byte, len(encoded))
+	for i, s := range encoded {
+		leaf, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaves[i] = leaf
+	}
+	return leaves
+}
+
+func testLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = []byte{byte(i), byte(i >> 8), 'x'}
+	}
+	return leaves
+}
+
+func TestEmptyRoot(t *testing.T) {
+	want := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if got := hex.EncodeToString(Root(nil)); got != want {
+		t.Fatalf("Root(nil) = %s, want %s", got, want)
+	}
+}
+
+func TestEmptyRootNotShared(t *testing.T) {
+	root := Root(nil)
+	root[0] ^= 0xff
+	if bytes.Equal(Root(nil), root) {
+		t.Fatal("modifying a returned root changed the empty root")
+	}
+}
+
+func TestRootRFC6962(t *testing.T) {
+	leaves := decodeLeaves(t, rfc6962Leaves)
+	for n := 1; n <= len(leaves); n++ {
+		if got := hex.EncodeToString(Root(leaves[:n])); got != rfc6962Roots[n-1] {
+			t.Errorf("root of %d leaves = %s, want %s", n, got, rfc6962Roots[n-1])
+		}
+	}
+}
+
+func TestLeafAndInnerDomainsDiffer(t *testing.T) {
+	leaves := testLeaves(2)
+	inner := append(LeafHash(leaves[0]), LeafHash(leaves[1])...)
+	if bytes.Equal(Root([][]byte{inner}), Root(leaves)) {
+		t.Fatal("a leaf holding two leaf hashes has the root of the two leaves")
+	}
+}
+
+func TestProofs(t *testing.T) {
+	for size := 1; size <= 33; size++ {
+		leaves := testLeaves(size)
+		root := Root(leaves)
+		for index := 0; index < size; index++ {
+			proof, err := Proof(leaves, index)
+			if err != nil {
+				t.Fatalf("size %d index %d: %v", size, index, err)
+			}
+			if !VerifyProof(root, leaves[index], index, size, proof) {
+				t.Fatalf("size %d index %d: valid proof rejected", size, index)
+			}
+		}
+	}
+}
+
+func TestProofIndexOutOfRange(t *testing.T) {
+	leaves := testLeaves(4)
+	for _, index := range []int{-1, 4} {
+		if _, err := Proof(leaves, index); err != ErrIndexOutOfRange {
+			t.Errorf("Proof(index %d) error = %v, want %v", index, err, ErrIndexOutOfRange)
+		}
+	}
+}
+
+func TestTamperedProofs(t *testing.T) {
+	const size = 11
+	leaves := testLeaves(size)
+	root := Root(leaves)
+	for index := 0; index < size; index++ {
+		proof, _ := Proof(leaves, index)
+		mutations := map[string]func() ([]byte, []byte, int, int, [][]byte){
+			"leaf": func() ([]byte, []byte, int, int, [][]byte) {
+				return root, []byte("forged"), index, size, proof
+			},
+			"root": func() ([]byte, []byte, int, int, [][]byte) {
+				forged := append([]byte(nil), root...)
+				forged[len(forged)-1] ^= 1
+				return forged, leaves[index], index, size, proof
+			},
+			"index": func() ([]byte, []byte, int, int, [][]byte) {
+				return root, leaves[index], (index + 1) % size, size, proof
+			},
+			"index out of range": func() ([]byte, []byte, int, int, [][]byte) {
+				return root, leaves[index], size, size, proof
+			},
+			// The root does not commit to the size, so only a size that
+			// changes the shape of the path must be rejected.
+			"size": func() ([]byte, []byte, int, int, [][]byte) {
+				return root, leaves[index], index, 2 * size, proof
+			},
+			"truncated": func() ([]byte, []byte, int, int, [][]byte) {
+				return root, leaves[index], index, size, proof[:len(proof)-1]
+			},
+			"extended": func() ([]byte, []byte, int, int, [][]byte) {
+				return root, leaves[index], index, size, append(append([][]byte(nil), proof...), root)
+			},
+			"reordered": func() ([]byte, []byte, int, int, [][]byte) {
+				swapped := append([][]byte(nil), proof...)
+				swapped[0], swapped[1] = swapped[1], swapped[0]
+				return root, leaves[index], index, size, swapped
+			},
+		}
+		for name, mutate := range mutations {
+			if VerifyProof(mutate()) {
+				t.Errorf("index %d: proof with tampered %s accepted", index, name)
+			}
+		}
+		for i := range proof {
+			for bit := 0; bit < 8; bit++ {
+				tampered := append([][]byte(nil), proof...)
+				tampered[i] = append([]byte(nil), proof[i]...)
+				tampered[i][0] ^= 1 << bit
+				if VerifyProof(root, leaves[index], index, size, tampered) {
+					t.Errorf("index %d: proof with bit %d of hash %d flipped accepted", index, bit, i)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package blockchain
+
+import "blockchain/metrics"
+
+var (
+	blocksMinedTotal = metrics.NewCounter(
+		"blockchain_blocks_mined_total",
+		"Number of blocks mined by this node.")
+	miningDuration = metrics.NewHistogram(
+		"blockchain_mining_duration_seconds",
+		"Time taken to seal a block.",
+		[]float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300})
+	hashesTotal = metrics.NewCounter(
+		"blockchain_hashes_total",
+		"Number of proof-of-work hashes computed.")
+	hashRate = metrics.NewGauge(
+		"blockchain_hash_rate",
+		"Proof-of-work hashes per second during the last mined block.")
+	// peerFailuresTotal has no peer label: anyone can become a peer, and
+	// the logs name the peer of each failure.
+	peerFailuresTotal = metrics.NewCounter(
+		"blockchain_peer_failures_total",
+		"Number of failed requests to a peer.")
+	consensusReplacementsTotal = metrics.NewCounter(
+		"blockchain_consensus_replacements_total",
+		"Number of times the chain was replaced by a peer's chain.")
+	compactReconstructedTotal = metrics.NewCounter(
+		"blockchain_compact_block_transactions_reconstructed_total",
+		"Number of compact block transactions found in the transaction pool.")
+	compactMissingTotal = metrics.NewCounter(
+		"blockchain_compact_block_transactions_missing_total",
+		"Number of compact block transactions that had to be requested from the peer.")
+)
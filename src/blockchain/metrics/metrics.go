@@ -0,0 +1,369 @@
+// Package metrics implements counters, gauges and histograms exposed in the
+// Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are latency buckets in seconds suited to HTTP requests.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	describe() (name string, help string, kind string)
+	write(w io.Writer)
+}
+
+// Registry holds metrics by name. The New* constructors register with
+// DefaultRegistry and panic on duplicate names.
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+var DefaultRegistry = NewRegistry()
+
+func (registry *Registry) register(c collector) {
+	name, _, _ := c.describe()
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if _, ok := registry.collectors[name]; ok {
+		panic("metrics: duplicate metric " + name)
+	}
+	registry.collectors[name] = c
+}
+
+// Write writes every registered metric in the text exposition format.
+func (registry *Registry) Write(w io.Writer) {
+	registry.mu.Lock()
+	names := make([]string, 0, len(registry.collectors))
+	for name := range registry.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collectors := make([]collector, len(names))
+	for i, name := range names {
+		collectors[i] = registry.collectors[name]
+	}
+	registry.mu.Unlock()
+
+	for _, c := range collectors {
+		name, help, kind := c.describe()
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(help), name, kind)
+		c.write(w)
+	}
+}
+
+func (registry *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		buf := bufio.NewWriter(w)
+		registry.Write(buf)
+		buf.Flush()
+	})
+}
+
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+type desc struct {
+	name string
+	help string
+	kind string
+}
+
+func (d *desc) describe() (string, string, string) {
+	return d.name, d.help, d.kind
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// The text format escapes backslashes and line feeds in help texts, and
+// double quotes as well in label values.
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatLabel(name string, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+func formatLabels(names []string, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, formatLabel(name, values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, formatLabel(extra[i], extra[i+1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// value is a float64 updated under a mutex; metric updates are rare enough
+// that lock-free tricks are not worth it.
+type value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (v *value) add(delta float64) {
+	v.mu.Lock()
+	v.v += delta
+	v.mu.Unlock()
+}
+
+func (v *value) set(f float64) {
+	v.mu.Lock()
+	v.v = f
+	v.mu.Unlock()
+}
+
+func (v *value) get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v
+}
+
+type Counter struct {
+	desc
+	value
+}
+
+func NewCounter(name string, help string) *Counter {
+	c := &Counter{desc: desc{name, help, "counter"}}
+	DefaultRegistry.register(c)
+	return c
+}
+
+func (c *Counter) Inc() {
+	c.add(1)
+}
+
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.add(delta)
+}
+
+func (c *Counter) write(w io.Writer) {
+	fmt.Fprintf(w, "%s %s\n", c.name, formatFloat(c.get()))
+}
+
+type Gauge struct {
+	desc
+	value
+}
+
+func NewGauge(name string, help string) *Gauge {
+	g := &Gauge{desc: desc{name, help, "gauge"}}
+	DefaultRegistry.register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64) {
+	g.set(v)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.add(delta)
+}
+
+func (g *Gauge) write(w io.Writer) {
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.get()))
+}
+
+// GaugeFunc reports the value of a function at scrape time.
+type GaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+func NewGaugeFunc(name string, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name, help, "gauge"}, fn: fn}
+	DefaultRegistry.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+type histogramData struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogramData(buckets []float64) *histogramData {
+	return &histogramData{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogramData) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogramData) write(w io.Writer, name string, labelNames []string, labelValues []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(labelNames, labelValues, "le", formatFloat(upper)), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(labelNames, labelValues, "le", "+Inf"), h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, formatLabels(labelNames, labelValues), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, formatLabels(labelNames, labelValues), h.count)
+}
+
+type Histogram struct {
+	desc
+	data *histogramData
+}
+
+func NewHistogram(name string, help string, buckets []float64) *Histogram {
+	h := &Histogram{desc: desc{name, help, "histogram"}, data: newHistogramData(buckets)}
+	DefaultRegistry.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.data.observe(v)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.data.write(w, h.name, nil, nil)
+}
+
+// vec holds one child per combination of label values.
+type vec struct {
+	desc
+	labels   []string
+	mu       sync.Mutex
+	children map[string]interface{}
+	values   map[string][]string
+}
+
+func newVec(d desc, labels []string) vec {
+	return vec{
+		desc:     d,
+		labels:   labels,
+		children: make(map[string]interface{}),
+		values:   make(map[string][]string),
+	}
+}
+
+func (v *vec) child(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic("metrics: wrong number of label values for " + v.name)
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.children[key]
+	if !ok {
+		c = create()
+		v.children[key] = c
+		v.values[key] = append([]string(nil), values...)
+	}
+	return c
+}
+
+func (v *vec) each(fn func(values []string, child interface{})) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	children := make([]interface{}, len(keys))
+	values := make([][]string, len(keys))
+	for i, key := range keys {
+		children[i] = v.children[key]
+		values[i] = v.values[key]
+	}
+	v.mu.Unlock()
+	for i := range keys {
+		fn(values[i], children[i])
+	}
+}
+
+type CounterVec struct {
+	vec
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(desc{name, help, "counter"}, labels)}
+	DefaultRegistry.register(c)
+	return c
+}
+
+func (c *CounterVec) WithLabelValues(values ...string) *Counter {
+	return c.child(values, func() interface{} {
+		return &Counter{desc: c.desc}
+	}).(*Counter)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.each(func(values []string, child interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, values), formatFloat(child.(*Counter).get()))
+	})
+}
+
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{vec: newVec(desc{name, help, "histogram"}, labels), buckets: buckets}
+	DefaultRegistry.register(h)
+	return h
+}
+
+func (h *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return h.child(values, func() interface{} {
+		return &Histogram{desc: h.desc, data: newHistogramData(h.buckets)}
+	}).(*Histogram)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.each(func(values []string, child interface{}) {
+		child.(*Histogram).data.write(w, h.name, h.labels, values)
+	})
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func newTestRegistry(collectors ...collector) *Registry {
+	registry := NewRegistry()
+	for _, c := range collectors {
+		registry.register(c)
+	}
+	return registry
+}
+
+func checkOutput(t *testing.T, registry *Registry, want string) {
+	t.Helper()
+	var buffer bytes.Buffer
+	registry.Write(&buffer)
+	if got := buffer.String(); got != want {
+		t.Fatalf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	counter := &Counter{desc: desc{"requests_total", "Number of requests.", "counter"}}
+	gauge := &Gauge{desc: desc{"queue_length", `Transactions in the "pool"\queue.` + "\nSecond line.", "gauge"}}
+	counter.Inc()
+	counter.Add(1.5)
+	gauge.Set(-3)
+	checkOutput(t, newTestRegistry(counter, gauge), `# HELP queue_length Transactions in the "pool"\\queue.\nSecond line.
+# TYPE queue_length gauge
+queue_length -3
+# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total 2.5
+`)
+}
+
+func TestLabelEscaping(t *testing.T) {
+	counter := &CounterVec{newVec(desc{"errors_total", "Errors.", "counter"}, []string{"path", "message"})}
+	counter.WithLabelValues(`/a"b`, "back\\slash\nnew line").Inc()
+	counter.WithLabelValues("/ü", "tab\there").Inc()
+	checkOutput(t, newTestRegistry(counter), `# HELP errors_total Errors.
+# TYPE errors_total counter
+errors_total{path="/a\"b",message="back\\slash\nnew line"} 1
+errors_total{path="/ü",message="tab	here"} 1
+`)
+}
+
+func TestHistogram(t *testing.T) {
+	histogram := &HistogramVec{vec: newVec(desc{"latency_seconds", "Latency.", "histogram"}, []string{"route"}), buckets: []float64{.1, 1}}
+	for _, v := range []float64{.05, .1, .5, 3} {
+		histogram.WithLabelValues("/blocks").Observe(v)
+	}
+	checkOutput(t, newTestRegistry(histogram), `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/blocks",le="0.1"} 2
+latency_seconds_bucket{route="/blocks",le="1"} 3
+latency_seconds_bucket{route="/blocks",le="+Inf"} 4
+latency_seconds_sum{route="/blocks"} 3.65
+latency_seconds_count{route="/blocks"} 4
+`)
+}
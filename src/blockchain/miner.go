@@ -0,0 +1,105 @@
+package blockchain
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// MaxNonce is the last nonce tried before the timestamp is bumped. Block
+// nonces are ints, so it is lower where ints have 32 bits.
+const MaxNonce = min(math.MaxUint32, math.MaxInt)
+
+type Miner struct {
+	hashes     uint64
+	Workers    int
+	Difficulty int
+	hash       powHash
+	// checkMask sets how often workers look whether another found a nonce:
+	// every checkMask+1 hashes.
+	checkMask uint64
+}
+
+// NewMiner checks for cancellation every 4096 hashes, which is cheap for
+// SHA-256; a slow hash should use SetCheckInterval.
+func NewMiner(difficulty int, hash powHash) *Miner {
+	return &Miner{
+		Workers:    runtime.GOMAXPROCS(0),
+		Difficulty: difficulty,
+		hash:       hash,
+		checkMask:  0xfff,
+	}
+}
+
+// SetCheckInterval makes workers check for cancellation every interval
+// hashes, rounded down to a power of two.
+func (miner *Miner) SetCheckInterval(interval int) {
+	mask := uint64(1)
+	for mask<<1 <= uint64(interval) {
+		mask <<= 1
+	}
+	miner.checkMask = mask - 1
+}
+
+func (miner *Miner) Hashes() uint64 {
+	return atomic.LoadUint64(&miner.hashes)
+}
+
+// Mine searches for a nonce that satisfies the difficulty. When the whole
+// nonce space is exhausted for a timestamp, the timestamp is bumped and the
+// search starts over, so the returned timestamp may differ from the input.
+func (miner *Miner) Mine(timestamp int64, previousHash string, merkleHash string) (int64, int, error) {
+	for {
+		prefix, err := headerPrefix(timestamp, previousHash, merkleHash)
+		if err != nil {
+			return 0, 0, err
+		}
+		if nonce, ok := miner.search(prefix); ok {
+			return timestamp, nonce, nil
+		}
+		timestamp++
+	}
+}
+
+func (miner *Miner) search(prefix []byte) (int, bool) {
+	workers := miner.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var found int32
+	result := -1
+	var once sync.Once
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(start int) {
+			defer wg.Done()
+			header := append(append([]byte{}, prefix...), make([]byte, nonceSize)...)
+			var count uint64
+			// The nonce is a uint64 so that adding workers past MaxNonce
+			// cannot wrap around.
+			for nonce := uint64(start); nonce <= MaxNonce; nonce += uint64(workers) {
+				if count&miner.checkMask == 0 && atomic.LoadInt32(&found) != 0 {
+					break
+				}
+				putNonce(header, int(nonce))
+				hash := miner.hash(header)
+				count++
+				if meetsDifficulty(hash[:], miner.Difficulty) {
+					once.Do(func() {
+						result = int(nonce)
+						atomic.StoreInt32(&found, 1)
+					})
+					break
+				}
+			}
+			atomic.AddUint64(&miner.hashes, count)
+			hashesTotal.Add(float64(count))
+		}(w)
+	}
+	wg.Wait()
+
+	return result, result >= 0
+}
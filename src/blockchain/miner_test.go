@@ -0,0 +1,106 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+)
+
+var (
+	benchPreviousHash = GenesisPreviousHash
+	benchMerkleHash   = CalcMerkleHash(nil)
+)
+
+func TestMineMeetsDifficulty(t *testing.T) {
+	miner := NewMiner(3, sha256.Sum256)
+	timestamp, nonce, err := miner.Mine(GenesisTimestamp, benchPreviousHash, benchMerkleHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &Block{Timestamp: timestamp, Nonce: nonce, PreviousHash: benchPreviousHash, MerkleHash: benchMerkleHash}
+	header, err := block.header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash := sha256.Sum256(header); !meetsDifficulty(hash[:], 3) {
+		t.Fatalf("mined nonce %d gives hash %x", nonce, hash)
+	}
+}
+
+func TestMineRejectsMalformedHash(t *testing.T) {
+	miner := NewMiner(1, sha256.Sum256)
+	for _, hash := range []string{"", "zz", "00", benchMerkleHash + "00"} {
+		if _, _, err := miner.Mine(GenesisTimestamp, hash, benchMerkleHash); err != ErrMalformedHash {
+			t.Errorf("Mine with previous hash %q: error = %v, want %v", hash, err, ErrMalformedHash)
+		}
+	}
+	block := &Block{PreviousHash: "not hex", MerkleHash: benchMerkleHash}
+	if _, err := block.CalcHash(); err != ErrMalformedHash {
+		t.Errorf("CalcHash error = %v, want %v", err, ErrMalformedHash)
+	}
+}
+
+// benchmarkMiner reports the hash rate of the miner solving a new header
+// at difficulty 4, about 65536 hashes, on every iteration.
+func benchmarkMiner(b *testing.B, workers int) {
+	miner := NewMiner(4, sha256.Sum256)
+	miner.Workers = workers
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := miner.Mine(GenesisTimestamp+int64(i), benchPreviousHash, benchMerkleHash); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(miner.Hashes())/b.Elapsed().Seconds(), "hashes/s")
+}
+
+func BenchmarkMinerOneWorker(b *testing.B) { benchmarkMiner(b, 1) }
+
+func BenchmarkMinerAllWorkers(b *testing.B) { benchmarkMiner(b, NewMiner(1, sha256.Sum256).Workers) }
+
+// BenchmarkNewBlockLoop measures the miner this replaced: one goroutine
+// building a block and marshaling its header to JSON for every nonce.
+func BenchmarkNewBlockLoop(b *testing.B) {
+	const attempts = 1 << 12
+	block := &Block{Timestamp: GenesisTimestamp, PreviousHash: benchPreviousHash, MerkleHash: benchMerkleHash}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for nonce := 0; nonce < attempts; nonce++ {
+			candidate := *block
+			candidate.Nonce = nonce
+			data, _ := json.Marshal(candidate)
+			hash := sha256.Sum256(data)
+			meetsDifficulty(hash[:], 64)
+		}
+	}
+	b.ReportMetric(float64(b.N)*attempts/b.Elapsed().Seconds(), "hashes/s")
+}
+
+func TestCheckInterval(t *testing.T) {
+	for _, params := range []PowParams{DefaultPowParams, DefaultScryptParams} {
+		pow, err := NewProofOfWork(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := pow.Miner().checkMask+1, uint64(params.checkInterval()); got != want {
+			t.Errorf("%s miner checks every %d hashes, want %d", params.Algorithm, got, want)
+		}
+	}
+}
+
+func BenchmarkMinerScrypt(b *testing.B) {
+	params := DefaultScryptParams
+	params.Difficulty = 1
+	pow, err := NewProofOfWork(params)
+	if err != nil {
+		b.Fatal(err)
+	}
+	miner := pow.Miner()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := miner.Mine(GenesisTimestamp+int64(i), benchPreviousHash, benchMerkleHash); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(miner.Hashes())/b.Elapsed().Seconds(), "hashes/s")
+}
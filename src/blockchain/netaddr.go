@@ -0,0 +1,57 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+)
+
+var ErrPrivateAddress = errors.New("address is loopback, private or link-local")
+
+// IsPublicIP reports whether ip can be reached over the internet, as
+// opposed to loopback, private, link-local and unspecified addresses.
+func IsPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified())
+}
+
+// ResolvePublic resolves host and fails with ErrPrivateAddress when any of
+// its addresses is not public.
+func ResolvePublic(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if !IsPublicIP(ip) {
+			return nil, ErrPrivateAddress
+		}
+		return []net.IP{ip}, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		if !IsPublicIP(addr.IP) {
+			return nil, ErrPrivateAddress
+		}
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// PublicDialer returns a dialer that refuses to connect to addresses that
+// are not public. Checking at dial time also catches names that resolved
+// to a public address when they were checked and to a private one since.
+func PublicDialer(dialer *net.Dialer) *net.Dialer {
+	dialer.Control = func(network, address string, conn syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+			return ErrPrivateAddress
+		}
+		return nil
+	}
+	return dialer
+}
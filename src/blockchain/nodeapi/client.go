@@ -0,0 +1,183 @@
+package nodeapi
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+
+	"blockchain/trace"
+)
+
+type DialFunc func(ctx context.Context, network string, address string) (net.Conn, error)
+
+// Client calls the Node service over cleartext HTTP/2.
+type Client struct {
+	target string
+	http   *http.Client
+	apiKey string
+}
+
+func Dial(target string) *Client {
+	var dialer net.Dialer
+	return DialWith(target, dialer.DialContext)
+}
+
+// DialWith creates a client that opens connections with dial, for example
+// PipeListener.Dial for in-process use.
+func DialWith(target string, dial DialFunc) *Client {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{
+		Protocols:   protocols,
+		DialContext: dial,
+	}
+	return &Client{
+		target: "http://" + target,
+		http:   &http.Client{Transport: transport},
+	}
+}
+
+// SetAPIKey sends key as the x-api-key metadata of every call.
+func (client *Client) SetAPIKey(key string) {
+	client.apiKey = key
+}
+
+func (client *Client) Close() {
+	client.http.CloseIdleConnections()
+}
+
+func (client *Client) call(ctx context.Context, method string, in message) (*http.Response, error) {
+	var body bytes.Buffer
+	if err := writeFrame(&body, in); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", client.target+servicePrefix+method, &body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	if client.apiKey != "" {
+		req.Header.Set("X-Api-Key", client.apiKey)
+	}
+	trace.Inject(ctx, req.Header)
+
+	res, err := client.http.Do(req)
+	if err != nil {
+		return nil, errorf(CodeUnavailable, "%v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, errorf(CodeUnavailable, "http status code: %d", res.StatusCode)
+	}
+	return res, nil
+}
+
+// status returns the call status after the body has been fully read.
+func status(res *http.Response) error {
+	code, message := res.Trailer.Get("Grpc-Status"), res.Trailer.Get("Grpc-Message")
+	if code == "" {
+		// Trailers-only responses carry the status in the headers.
+		code, message = res.Header.Get("Grpc-Status"), res.Header.Get("Grpc-Message")
+	}
+	return parseStatus(code, message)
+}
+
+func (client *Client) unary(ctx context.Context, method string, in message, out message) error {
+	res, err := client.call(ctx, method, in)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	frameErr := readFrame(res.Body, out)
+	if frameErr != nil && frameErr != io.EOF {
+		return frameErr
+	}
+	io.Copy(io.Discard, res.Body)
+	if err := status(res); err != nil {
+		return err
+	}
+	if frameErr == io.EOF {
+		return errorf(CodeInternal, "missing response message")
+	}
+	return nil
+}
+
+func (client *Client) SubmitTransaction(ctx context.Context, transaction *Transaction) (string, error) {
+	var out SubmitTransactionResponse
+	if err := client.unary(ctx, "SubmitTransaction", &SubmitTransactionRequest{Transaction: transaction}, &out); err != nil {
+		return "", err
+	}
+	return out.TxID, nil
+}
+
+func (client *Client) GetBlock(ctx context.Context, req *GetBlockRequest) (*Block, error) {
+	var out Block
+	if err := client.unary(ctx, "GetBlock", req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+type clientStream struct {
+	res *http.Response
+}
+
+func (s *clientStream) recv(m message) error {
+	err := readFrame(s.res.Body, m)
+	if err == io.EOF {
+		if err := status(s.res); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	return err
+}
+
+func (s *clientStream) Close() error {
+	return s.res.Body.Close()
+}
+
+type BlockStream struct {
+	clientStream
+}
+
+// Recv returns the next block, or io.EOF when the server ended the stream.
+func (s *BlockStream) Recv() (*Block, error) {
+	var block Block
+	if err := s.recv(&block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+func (client *Client) StreamBlocks(ctx context.Context, req *StreamBlocksRequest) (*BlockStream, error) {
+	res, err := client.call(ctx, "StreamBlocks", req)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockStream{clientStream{res}}, nil
+}
+
+type TransactionStream struct {
+	clientStream
+}
+
+func (s *TransactionStream) Recv() (*Transaction, error) {
+	var transaction Transaction
+	if err := s.recv(&transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+func (client *Client) StreamMempool(ctx context.Context, req *StreamMempoolRequest) (*TransactionStream, error) {
+	res, err := client.call(ctx, "StreamMempool", req)
+	if err != nil {
+		return nil, err
+	}
+	return &TransactionStream{clientStream{res}}, nil
+}
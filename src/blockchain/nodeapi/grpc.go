@@ -0,0 +1,99 @@
+package nodeapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+)
+
+// gRPC status codes used by the node service.
+const (
+	CodeOK                = 0
+	CodeCanceled          = 1
+	CodeInvalidArgument   = 3
+	CodeNotFound          = 5
+	CodePermissionDenied  = 7
+	CodeResourceExhausted = 8
+	CodeUnimplemented     = 12
+	CodeInternal          = 13
+	CodeUnavailable       = 14
+	CodeUnauthenticated   = 16
+)
+
+const maxMessageSize = 4 << 20
+
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("nodeapi: code = %d desc = %s", e.Code, e.Message)
+}
+
+func errorf(code int, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func statusOf(err error) (int, string) {
+	if err == nil {
+		return CodeOK, ""
+	}
+	if e, ok := err.(*Error); ok {
+		return e.Code, e.Message
+	}
+	return CodeInternal, err.Error()
+}
+
+func parseStatus(code string, message string) error {
+	if code == "" {
+		return errorf(CodeInternal, "missing grpc-status")
+	}
+	n, err := strconv.Atoi(code)
+	if err != nil {
+		return errorf(CodeInternal, "malformed grpc-status: %s", code)
+	}
+	if n == CodeOK {
+		return nil
+	}
+	if m, err := url.PathUnescape(message); err == nil {
+		message = m
+	}
+	return &Error{Code: n, Message: message}
+}
+
+// writeFrame writes a length-prefixed gRPC message.
+func writeFrame(w io.Writer, m message) error {
+	body := m.marshal(nil)
+	frame := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+	_, err := w.Write(append(frame, body...))
+	return err
+}
+
+func readFrame(r io.Reader, m message) error {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	if header[0] != 0 {
+		return errorf(CodeUnimplemented, "compressed messages are not supported")
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > maxMessageSize {
+		return errorf(CodeInvalidArgument, "message too large: %d", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if err := m.unmarshal(body); err != nil {
+		return errorf(CodeInvalidArgument, "%v", err)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package nodeapi
+
+import "blockchain"
+
+type Transaction struct {
+	Timestamp int64
+	Sender    string
+	Recipient string
+	Amount    int64
+}
+
+func NewTransaction(transaction *blockchain.Transaction) *Transaction {
+	return &Transaction{
+		Timestamp: transaction.Timestamp,
+		Sender:    transaction.Sender,
+		Recipient: transaction.Recipient,
+		Amount:    transaction.Amount,
+	}
+}
+
+func (transaction *Transaction) Transaction() blockchain.Transaction {
+	return blockchain.Transaction{
+		Timestamp: transaction.Timestamp,
+		Sender:    transaction.Sender,
+		Recipient: transaction.Recipient,
+		Amount:    transaction.Amount,
+	}
+}
+
+func (transaction *Transaction) marshal(b []byte) []byte {
+	b = appendInt64(b, 1, transaction.Timestamp)
+	b = appendString(b, 2, transaction.Sender)
+	b = appendString(b, 3, transaction.Recipient)
+	return appendInt64(b, 4, transaction.Amount)
+}
+
+func (transaction *Transaction) unmarshal(b []byte) error {
+	return parseFields(b, func(f *field) error {
+		switch f.number {
+		case 1:
+			transaction.Timestamp = f.int64()
+		case 2:
+			transaction.Sender = f.string()
+		case 3:
+			transaction.Recipient = f.string()
+		case 4:
+			transaction.Amount = f.int64()
+		}
+		return nil
+	})
+}
+
+type Block struct {
+	Height       int64
+	Timestamp    int64
+	Nonce        int64
+	Hash         string
+	PreviousHash string
+	MerkleHash   string
+	Signer       string
+	Signature    string
+	Transactions []*Transaction
+}
+
+func NewBlock(block *blockchain.Block) *Block {
+	b := &Block{
+		Height:       int64(block.Height),
+		Timestamp:    block.Timestamp,
+		Nonce:        int64(block.Nonce),
+		Hash:         block.Hash,
+		PreviousHash: block.PreviousHash,
+		MerkleHash:   block.MerkleHash,
+		Signer:       block.Signer,
+		Signature:    block.Signature,
+	}
+	for i := range block.Transactions {
+		b.Transactions = append(b.Transactions, NewTransaction(&block.Transactions[i]))
+	}
+	return b
+}
+
+func (block *Block) Block() blockchain.Block {
+	b := blockchain.Block{
+		Height:       int(block.Height),
+		Timestamp:    block.Timestamp,
+		Nonce:        int(block.Nonce),
+		Hash:         block.Hash,
+		PreviousHash: block.PreviousHash,
+		MerkleHash:   block.MerkleHash,
+		Signer:       block.Signer,
+		Signature:    block.Signature,
+	}
+	for _, transaction := range block.Transactions {
+		b.Transactions = append(b.Transactions, transaction.Transaction())
+	}
+	return b
+}
+
+func (block *Block) marshal(b []byte) []byte {
+	b = appendInt64(b, 1, block.Height)
+	b = appendInt64(b, 2, block.Timestamp)
+	b = appendInt64(b, 3, block.Nonce)
+	b = appendString(b, 4, block.Hash)
+	b = appendString(b, 5, block.PreviousHash)
+	b = appendString(b, 6, block.MerkleHash)
+	b = appendString(b, 7, block.Signer)
+	b = appendString(b, 8, block.Signature)
+	for _, transaction := range block.Transactions {
+		b = appendMessage(b, 9, transaction)
+	}
+	return b
+}
+
+func (block *Block) unmarshal(b []byte) error {
+	return parseFields(b, func(f *field) error {
+		switch f.number {
+		case 1:
+			block.Height = f.int64()
+		case 2:
+			block.Timestamp = f.int64()
+		case 3:
+			block.Nonce = f.int64()
+		case 4:
+			block.Hash = f.string()
+		case 5:
+			block.PreviousHash = f.string()
+		case 6:
+			block.MerkleHash = f.string()
+		case 7:
+			block.Signer = f.string()
+		case 8:
+			block.Signature = f.string()
+		case 9:
+			transaction := &Transaction{}
+			if err := transaction.unmarshal(f.bytes); err != nil {
+				return err
+			}
+			block.Transactions = append(block.Transactions, transaction)
+		}
+		return nil
+	})
+}
+
+type SubmitTransactionRequest struct {
+	Transaction *Transaction
+}
+
+func (req *SubmitTransactionRequest) marshal(b []byte) []byte {
+	if req.Transaction == nil {
+		return b
+	}
+	return appendMessage(b, 1, req.Transaction)
+}
+
+func (req *SubmitTransactionRequest) unmarshal(b []byte) error {
+	return parseFields(b, func(f *field) error {
+		if f.number == 1 {
+			req.Transaction = &Transaction{}
+			return req.Transaction.unmarshal(f.bytes)
+		}
+		return nil
+	})
+}
+
+type SubmitTransactionResponse struct {
+	TxID string
+}
+
+func (res *SubmitTransactionResponse) marshal(b []byte) []byte {
+	return appendString(b, 1, res.TxID)
+}
+
+func (res *SubmitTransactionResponse) unmarshal(b []byte) error {
+	return parseFields(b, func(f *field) error {
+		if f.number == 1 {
+			res.TxID = f.string()
+		}
+		return nil
+	})
+}
+
+// GetBlockRequest selects a block by hash when Hash is set, by height
+// otherwise.
+type GetBlockRequest struct {
+	Height int64
+	Hash   string
+}
+
+func (req *GetBlockRequest) marshal(b []byte) []byte {
+	if req.Hash != "" {
+		return appendString(b, 2, req.Hash)
+	}
+	// Oneof members are always present on the wire, even when zero.
+	return appendVarint(appendTag(b, 1, wireVarint), uint64(req.Height))
+}
+
+func (req *GetBlockRequest) unmarshal(b []byte) error {
+	return parseFields(b, func(f *field) error {
+		switch f.number {
+		case 1:
+			req.Height, req.Hash = f.int64(), ""
+		case 2:
+			req.Hash = f.string()
+		}
+		return nil
+	})
+}
+
+type StreamBlocksRequest struct {
+	FromHeight int64
+}
+
+func (req *StreamBlocksRequest) marshal(b []byte) []byte {
+	return appendInt64(b, 1, req.FromHeight)
+}
+
+func (req *StreamBlocksRequest) unmarshal(b []byte) error {
+	return parseFields(b, func(f *field) error {
+		if f.number == 1 {
+			req.FromHeight = f.int64()
+		}
+		return nil
+	})
+}
+
+type StreamMempoolRequest struct {
+	Addresses []string
+}
+
+func (req *StreamMempoolRequest) marshal(b []byte) []byte {
+	for _, address := range req.Addresses {
+		b = appendVarint(appendTag(b, 1, wireBytes), uint64(len(address)))
+		b = append(b, address...)
+	}
+	return b
+}
+
+func (req *StreamMempoolRequest) unmarshal(b []byte) error {
+	return parseFields(b, func(f *field) error {
+		if f.number == 1 {
+			req.Addresses = append(req.Addresses, f.string())
+		}
+		return nil
+	})
+}
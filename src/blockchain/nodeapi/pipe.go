@@ -0,0 +1,67 @@
+package nodeapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+var errListenerClosed = errors.New("nodeapi: listener closed")
+
+// PipeListener is an in-memory net.Listener for running a server and its
+// clients in the same process without opening sockets.
+type PipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func NewPipeListener() *PipeListener {
+	return &PipeListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (listener *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case <-listener.closed:
+		return nil, errListenerClosed
+	}
+}
+
+func (listener *PipeListener) Close() error {
+	listener.once.Do(func() {
+		close(listener.closed)
+	})
+	return nil
+}
+
+func (listener *PipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+func (listener *PipeListener) Dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case listener.conns <- server:
+		return client, nil
+	case <-listener.closed:
+		return nil, errListenerClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (pipeAddr) String() string {
+	return "pipe"
+}
@@ -0,0 +1,114 @@
+package nodeapi
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// A minimal protocol buffers wire format codec for the messages in
+// node.proto.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errMalformed = errors.New("nodeapi: malformed protobuf message")
+
+type message interface {
+	marshal(b []byte) []byte
+	unmarshal(b []byte) error
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendTag(b []byte, field int, wireType int) []byte {
+	return appendVarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendInt64(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	return appendVarint(appendTag(b, field, wireVarint), uint64(v))
+}
+
+func appendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendVarint(appendTag(b, field, wireBytes), uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendMessage(b []byte, field int, m message) []byte {
+	body := m.marshal(nil)
+	b = appendVarint(appendTag(b, field, wireBytes), uint64(len(body)))
+	return append(b, body...)
+}
+
+type field struct {
+	number   int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+func (f *field) int64() int64 {
+	return int64(f.varint)
+}
+
+func (f *field) string() string {
+	return string(f.bytes)
+}
+
+// parseFields calls fn for every field in b.
+func parseFields(b []byte, fn func(f *field) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errMalformed
+		}
+		b = b[n:]
+		f := field{number: int(key >> 3), wireType: int(key & 7)}
+		switch f.wireType {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errMalformed
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errMalformed
+			}
+			b = b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errMalformed
+			}
+			b = b[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return errMalformed
+			}
+			f.bytes = b[n : n+int(length)]
+			b = b[n+int(length):]
+		default:
+			return errMalformed
+		}
+		if err := fn(&f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package nodeapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"blockchain"
+	"blockchain/trace"
+)
+
+const servicePrefix = "/nodeapi.Node/"
+
+// Interceptor runs before every call. The request headers carry the call
+// metadata and the method is the last element of the path. It returns the
+// request to continue with, which may carry context values or a limited
+// body, or an error, usually an *Error, that ends the call with its status.
+type Interceptor func(w http.ResponseWriter, req *http.Request) (*http.Request, error)
+
+// Server implements the Node service from node.proto over gRPC's HTTP/2
+// wire protocol.
+type Server struct {
+	blockChain   *blockchain.BlockChain
+	interceptors []Interceptor
+}
+
+func NewServer(blockChain *blockchain.BlockChain) *Server {
+	return &Server{blockChain: blockChain}
+}
+
+// Intercept adds an interceptor, run after those added before it.
+func (server *Server) Intercept(interceptor Interceptor) {
+	server.interceptors = append(server.interceptors, interceptor)
+}
+
+// Serve accepts cleartext HTTP/2 (h2c) connections on listener.
+func (server *Server) Serve(listener net.Listener) error {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	httpServer := &http.Server{
+		Handler:   server,
+		Protocols: protocols,
+	}
+	return httpServer.Serve(listener)
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	ctx, span := trace.Start(trace.Extract(req.Context(), req.Header), strings.TrimPrefix(req.URL.Path, "/"), trace.SpanKindServer)
+	req = req.WithContext(ctx)
+	var err error
+	for _, interceptor := range server.interceptors {
+		if req, err = interceptor(w, req); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = server.dispatch(w, req)
+	}
+	span.RecordError(err)
+	span.End()
+	code, message := statusOf(err)
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", url.PathEscape(message))
+	}
+}
+
+func (server *Server) dispatch(w http.ResponseWriter, req *http.Request) error {
+	switch strings.TrimPrefix(req.URL.Path, servicePrefix) {
+	case "SubmitTransaction":
+		var in SubmitTransactionRequest
+		if err := readRequest(req, &in); err != nil {
+			return err
+		}
+		out, err := server.SubmitTransaction(req.Context(), &in)
+		if err != nil {
+			return err
+		}
+		return writeFrame(w, out)
+	case "GetBlock":
+		var in GetBlockRequest
+		if err := readRequest(req, &in); err != nil {
+			return err
+		}
+		out, err := server.GetBlock(req.Context(), &in)
+		if err != nil {
+			return err
+		}
+		return writeFrame(w, out)
+	case "StreamBlocks":
+		var in StreamBlocksRequest
+		if err := readRequest(req, &in); err != nil {
+			return err
+		}
+		return server.StreamBlocks(&in, newStream(w, req))
+	case "StreamMempool":
+		var in StreamMempoolRequest
+		if err := readRequest(req, &in); err != nil {
+			return err
+		}
+		return server.StreamMempool(&in, newStream(w, req))
+	}
+	return errorf(CodeUnimplemented, "unknown method %s", req.URL.Path)
+}
+
+func readRequest(req *http.Request, m message) error {
+	if err := readFrame(req.Body, m); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errorf(CodeInvalidArgument, "missing request message")
+		}
+		if errors.As(err, new(*http.MaxBytesError)) {
+			return errorf(CodeResourceExhausted, "request message too large")
+		}
+		return err
+	}
+	return nil
+}
+
+type stream struct {
+	w    http.ResponseWriter
+	req  *http.Request
+	done <-chan struct{}
+}
+
+func newStream(w http.ResponseWriter, req *http.Request) *stream {
+	return &stream{w: w, req: req, done: req.Context().Done()}
+}
+
+func (s *stream) send(m message) error {
+	if err := writeFrame(s.w, m); err != nil {
+		return err
+	}
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+func (server *Server) SubmitTransaction(ctx context.Context, req *SubmitTransactionRequest) (*SubmitTransactionResponse, error) {
+	if req.Transaction == nil {
+		return nil, errorf(CodeInvalidArgument, "transaction is required")
+	}
+	transaction := req.Transaction.Transaction()
+	if err := server.blockChain.AddTransaction(ctx, &transaction); err != nil {
+		if err == blockchain.ErrPoolFull {
+			return nil, errorf(CodeResourceExhausted, "%v", err)
+		}
+		return nil, errorf(CodeInvalidArgument, "%v", err)
+	}
+	return &SubmitTransactionResponse{TxID: transaction.Hash()}, nil
+}
+
+func (server *Server) GetBlock(ctx context.Context, req *GetBlockRequest) (*Block, error) {
+	var block *blockchain.Block
+	var ok bool
+	if req.Hash != "" {
+		block, ok = server.blockChain.BlockByHash(req.Hash)
+	} else {
+		block, ok = server.blockChain.BlockByHeight(int(req.Height))
+	}
+	if !ok {
+		return nil, errorf(CodeNotFound, "block not found")
+	}
+	return NewBlock(block), nil
+}
+
+// StreamBlocks replays the blocks from req.FromHeight and then sends new
+// blocks. The stream ends with CodeUnavailable if the client falls so far
+// behind that blocks were dropped, so that it never misses one silently.
+func (server *Server) StreamBlocks(req *StreamBlocksRequest, s *stream) error {
+	// Most of the replay runs before subscribing, so a long replay cannot
+	// overflow the subscription.
+	next := int(req.FromHeight)
+	if req.FromHeight >= 0 {
+		var err error
+		if next, err = server.replay(s, next, nil); err != nil {
+			return err
+		}
+	}
+	sub := server.blockChain.Subscribe(blockchain.EventFilter{Types: []string{blockchain.EventBlock}})
+	defer sub.Close()
+
+	// Blocks appended since the replay are sent now and skipped when their
+	// events arrive.
+	replayed := make(map[string]bool)
+	if req.FromHeight >= 0 {
+		if _, err := server.replay(s, next, replayed); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return errorf(CodeUnavailable, "subscription closed")
+			}
+			if dropped := sub.Dropped(); dropped > 0 {
+				return errorf(CodeUnavailable, "stream fell behind, %d blocks dropped", dropped)
+			}
+			if replayed[event.Block.Hash] {
+				continue
+			}
+			if err := s.send(NewBlock(event.Block)); err != nil {
+				return err
+			}
+		case <-s.done:
+			return errorf(CodeCanceled, "stream canceled")
+		}
+	}
+}
+
+// replay sends the blocks from height next up to the tip, one at a time so
+// that the chain is not copied, and returns the height after the last one.
+func (server *Server) replay(s *stream, next int, replayed map[string]bool) (int, error) {
+	for ; ; next++ {
+		block, ok := server.blockChain.BlockByHeight(next)
+		if !ok {
+			return next, nil
+		}
+		if err := s.send(NewBlock(block)); err != nil {
+			return next, err
+		}
+		if replayed != nil {
+			replayed[block.Hash] = true
+		}
+	}
+}
+
+// StreamMempool sends transactions entering the pool, like StreamBlocks
+// ending with CodeUnavailable when some were dropped.
+func (server *Server) StreamMempool(req *StreamMempoolRequest, s *stream) error {
+	sub := server.blockChain.Subscribe(blockchain.EventFilter{
+		Types:     []string{blockchain.EventTransaction},
+		Addresses: req.Addresses,
+	})
+	defer sub.Close()
+
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return errorf(CodeUnavailable, "subscription closed")
+			}
+			if dropped := sub.Dropped(); dropped > 0 {
+				return errorf(CodeUnavailable, "stream fell behind, %d transactions dropped", dropped)
+			}
+			if err := s.send(NewTransaction(event.Transaction)); err != nil {
+				return err
+			}
+		case <-s.done:
+			return errorf(CodeCanceled, "stream canceled")
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package nodeapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"blockchain"
+)
+
+// newTestNode serves a new chain over a PipeListener and returns a client
+// connected to it.
+func newTestNode(t *testing.T) (*blockchain.BlockChain, *Client) {
+	chain := newTestChain(t)
+	listener := NewPipeListener()
+	go NewServer(chain).Serve(listener)
+	client := DialWith("pipe", listener.Dial)
+	t.Cleanup(func() {
+		client.Close()
+		listener.Close()
+	})
+	return chain, client
+}
+
+func newTestChain(t *testing.T) *blockchain.BlockChain {
+	params := blockchain.DefaultPowParams
+	params.Difficulty = 1
+	engine, err := blockchain.NewProofOfWork(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.NewBlockChain(engine, blockchain.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+func mine(t *testing.T, chain *blockchain.BlockChain) *blockchain.Block {
+	block, err := chain.Mine(context.Background(), blockchain.GenesisTimestamp+int64(chain.Height())+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func code(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return -1
+}
+
+func TestSubmitTransactionAndGetBlock(t *testing.T) {
+	chain, client := newTestNode(t)
+	ctx := context.Background()
+	txid, err := client.SubmitTransaction(ctx, &Transaction{Sender: "alice", Recipient: "bob", Amount: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := mine(t, chain)
+	if len(block.Transactions) != 1 || block.Transactions[0].Hash() != txid {
+		t.Fatalf("mined block does not contain %s", txid)
+	}
+
+	byHeight, err := client.GetBlock(ctx, &GetBlockRequest{Height: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byHash, err := client.GetBlock(ctx, &GetBlockRequest{Hash: block.Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byHeight.Hash != block.Hash || byHash.Hash != block.Hash || len(byHash.Transactions) != 1 {
+		t.Fatalf("GetBlock = %+v and %+v, want block %s", byHeight, byHash, block.Hash)
+	}
+	if _, err := client.GetBlock(ctx, &GetBlockRequest{Height: 7}); code(err) != CodeNotFound {
+		t.Fatalf("GetBlock of a missing height: error = %v, want code %d", err, CodeNotFound)
+	}
+}
+
+func TestSubmitTransactionRejectsInvalid(t *testing.T) {
+	_, client := newTestNode(t)
+	_, err := client.SubmitTransaction(context.Background(), &Transaction{Sender: "alice", Recipient: "bob"})
+	if code(err) != CodeInvalidArgument {
+		t.Fatalf("SubmitTransaction of a zero amount: error = %v, want code %d", err, CodeInvalidArgument)
+	}
+}
+
+func TestStreamBlocksReplaysThenFollows(t *testing.T) {
+	chain, client := newTestNode(t)
+	mine(t, chain)
+	mine(t, chain)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.StreamBlocks(ctx, &StreamBlocksRequest{FromHeight: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	for height := 1; height <= 2; height++ {
+		block, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Height != int64(height) {
+			t.Fatalf("replayed height %d, want %d", block.Height, height)
+		}
+	}
+	mined := mine(t, chain)
+	block, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Hash != mined.Hash {
+		t.Fatalf("streamed block %s, want %s", block.Hash, mined.Hash)
+	}
+}
+
+func TestStreamMempool(t *testing.T) {
+	chain, client := newTestNode(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.StreamMempool(ctx, &StreamMempoolRequest{Addresses: []string{"bob"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	// The server sends its headers before it subscribes, so the first
+	// transactions may be missed; add more until one arrives.
+	received := make(chan *Transaction, 1)
+	go func() {
+		transaction, err := stream.Recv()
+		if err == nil {
+			received <- transaction
+		}
+	}()
+	for amount := int64(1); ; amount++ {
+		chain.AddTransaction(ctx, &blockchain.Transaction{Sender: "carol", Recipient: "dave", Amount: amount})
+		chain.AddTransaction(ctx, &blockchain.Transaction{Sender: "alice", Recipient: "bob", Amount: amount})
+		select {
+		case transaction := <-received:
+			if transaction.Recipient != "bob" {
+				t.Fatalf("streamed transaction to %s, want bob", transaction.Recipient)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestUnknownMethod(t *testing.T) {
+	_, client := newTestNode(t)
+	err := client.unary(context.Background(), "Missing", &GetBlockRequest{}, &Block{})
+	if code(err) != CodeUnimplemented {
+		t.Fatalf("error = %v, want code %d", err, CodeUnimplemented)
+	}
+}
+
+// blockedWriter is a ResponseWriter whose writes wait until release is
+// closed, like a client that stopped reading.
+type blockedWriter struct {
+	header  http.Header
+	writing chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (w *blockedWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *blockedWriter) WriteHeader(int) {}
+
+func (w *blockedWriter) Write(b []byte) (int, error) {
+	w.once.Do(func() { close(w.writing) })
+	<-w.release
+	return len(b), nil
+}
+
+func TestStreamBlocksEndsWhenBlocksAreDropped(t *testing.T) {
+	chain := newTestChain(t)
+	server := NewServer(chain)
+	w := &blockedWriter{header: make(http.Header), writing: make(chan struct{}), release: make(chan struct{})}
+	req := httptest.NewRequest("POST", "/", nil)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.StreamBlocks(&StreamBlocksRequest{FromHeight: -1}, newStream(w, req))
+	}()
+
+	// Mine until the stream is stuck sending a block, then overflow its
+	// subscription.
+	for stuck := false; !stuck; {
+		mine(t, chain)
+		select {
+		case <-w.writing:
+			stuck = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	for i := 0; i < 2*64; i++ {
+		mine(t, chain)
+	}
+	close(w.release)
+	select {
+	case err := <-errs:
+		if code(err) != CodeUnavailable {
+			t.Fatalf("StreamBlocks error = %v, want code %d", err, CodeUnavailable)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StreamBlocks kept streaming after dropping blocks")
+	}
+}
+
+func TestStreamEndsWithStatus(t *testing.T) {
+	chain, client := newTestNode(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.StreamBlocks(ctx, &StreamBlocksRequest{FromHeight: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if block, err := stream.Recv(); err != nil || block.Hash != chain.TipHash() {
+		t.Fatalf("Recv = %v, %v, want the genesis block", block, err)
+	}
+	cancel()
+	if _, err := stream.Recv(); err == nil || err == io.EOF {
+		t.Fatalf("Recv after cancel: error = %v, want a canceled stream", err)
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	chain := newTestChain(t)
+	listener := NewPipeListener()
+	server := NewServer(chain)
+	server.Intercept(func(w http.ResponseWriter, req *http.Request) (*http.Request, error) {
+		if req.Header.Get("X-Api-Key") != "secret" {
+			return nil, &Error{Code: CodeUnauthenticated, Message: "invalid credentials"}
+		}
+		if req.URL.Path != servicePrefix+"GetBlock" {
+			return nil, &Error{Code: CodePermissionDenied, Message: "requires role submitter"}
+		}
+		return req, nil
+	})
+	go server.Serve(listener)
+	defer listener.Close()
+	client := DialWith("pipe", listener.Dial)
+	defer client.Close()
+	ctx := context.Background()
+
+	if _, err := client.GetBlock(ctx, &GetBlockRequest{}); code(err) != CodeUnauthenticated {
+		t.Fatalf("GetBlock without a key: error = %v, want code %d", err, CodeUnauthenticated)
+	}
+	client.SetAPIKey("secret")
+	if _, err := client.GetBlock(ctx, &GetBlockRequest{}); err != nil {
+		t.Fatalf("GetBlock with a key: %v", err)
+	}
+	_, err := client.SubmitTransaction(ctx, &Transaction{Sender: "alice", Recipient: "bob", Amount: 1})
+	if code(err) != CodePermissionDenied {
+		t.Fatalf("SubmitTransaction: error = %v, want code %d", err, CodePermissionDenied)
+	}
+	if len(chain.PendingTransactions()) != 0 {
+		t.Fatal("refused transaction was pooled")
+	}
+}
@@ -0,0 +1,192 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Nodes calling each other over HTTP sign their requests with the identity
+// key, so that a node can give its peers more than anonymous callers get
+// without sharing a secret. The signature covers the method, host, request
+// URI, time, a random nonce and the body digest.
+const (
+	HeaderNodeKey       = "X-Node-Key"
+	HeaderNodeDate      = "X-Node-Date"
+	HeaderNodeNonce     = "X-Node-Nonce"
+	HeaderNodeDigest    = "X-Node-Content-Sha256"
+	HeaderNodeSignature = "X-Node-Signature"
+	maxRequestSkew      = 5 * time.Minute
+	maxNonceSize        = 64
+)
+
+var (
+	ErrRequestSignature = errors.New("p2p: invalid request signature")
+	ErrRequestExpired   = errors.New("p2p: signed request is too old or too new")
+	ErrBodyDigest       = errors.New("p2p: request body does not match its signed digest")
+	ErrRequestReplayed  = errors.New("p2p: signed request was already received")
+)
+
+func signedRequest(req *http.Request, host string, date string, nonce string, digest string) []byte {
+	return []byte(fmt.Sprintf("blockchain-node-request\n%s\n%s\n%s\n%s\n%s\n%s",
+		req.Method, host, req.URL.RequestURI(), date, nonce, digest))
+}
+
+// SignRequest signs req, whose body is body, with the identity key.
+func (identity *Identity) SignRequest(req *http.Request, body []byte) {
+	identity.signRequest(req, body, time.Now())
+}
+
+func (identity *Identity) signRequest(req *http.Request, body []byte, now time.Time) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	date := strconv.FormatInt(now.Unix(), 10)
+	var random [16]byte
+	rand.Read(random[:])
+	nonce := hex.EncodeToString(random[:])
+	sum := sha256.Sum256(body)
+	digest := hex.EncodeToString(sum[:])
+	signature := ed25519.Sign(identity.key, signedRequest(req, host, date, nonce, digest))
+	req.Header.Set(HeaderNodeKey, base64.StdEncoding.EncodeToString(identity.key.Public().(ed25519.PublicKey)))
+	req.Header.Set(HeaderNodeDate, date)
+	req.Header.Set(HeaderNodeNonce, nonce)
+	req.Header.Set(HeaderNodeDigest, digest)
+	req.Header.Set(HeaderNodeSignature, base64.StdEncoding.EncodeToString(signature))
+}
+
+// Transport returns a round tripper that signs every request with the
+// identity before passing it to base.
+func (identity *Identity) Transport(base http.RoundTripper) http.RoundTripper {
+	return &signingTransport{identity: identity, base: base}
+}
+
+type signingTransport struct {
+	identity *Identity
+	base     http.RoundTripper
+}
+
+func (transport *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	signed := req.Clone(req.Context())
+	if body != nil {
+		signed.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	transport.identity.SignRequest(signed, body)
+	return transport.base.RoundTrip(signed)
+}
+
+// IsSigned reports whether req carries a node signature.
+func IsSigned(req *http.Request) bool {
+	return req.Header.Get(HeaderNodeSignature) != ""
+}
+
+// VerifyRequest checks the node signature of req and returns the signer's
+// node ID. It does not read the body, which CheckBody checks, nor look for
+// replays, which Nonces does.
+func VerifyRequest(req *http.Request) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(req.Header.Get(HeaderNodeKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return "", ErrRequestSignature
+	}
+	signature, err := base64.StdEncoding.DecodeString(req.Header.Get(HeaderNodeSignature))
+	if err != nil {
+		return "", ErrRequestSignature
+	}
+	date := req.Header.Get(HeaderNodeDate)
+	unix, err := strconv.ParseInt(date, 10, 64)
+	if err != nil {
+		return "", ErrRequestSignature
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > maxRequestSkew || skew < -maxRequestSkew {
+		return "", ErrRequestExpired
+	}
+	nonce := req.Header.Get(HeaderNodeNonce)
+	if nonce == "" || len(nonce) > maxNonceSize {
+		return "", ErrRequestSignature
+	}
+	digest := req.Header.Get(HeaderNodeDigest)
+	if !ed25519.Verify(key, signedRequest(req, req.Host, date, nonce, digest), signature) {
+		return "", ErrRequestSignature
+	}
+	return NodeID(key), nil
+}
+
+// CheckBody reads the body of a verified request and fails with
+// ErrBodyDigest when it does not match the signed digest. The body is
+// replaced to be read again.
+func CheckBody(req *http.Request) error {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != req.Header.Get(HeaderNodeDigest) {
+		return ErrBodyDigest
+	}
+	return nil
+}
+
+// Nonces remembers the nonces of verified requests for as long as their
+// dates are accepted, so that a captured request cannot be sent again.
+type Nonces struct {
+	mu sync.Mutex
+	// seen holds the expiry of each node ID and nonce.
+	seen map[string]time.Time
+	next time.Time
+}
+
+func NewNonces() *Nonces {
+	return &Nonces{seen: make(map[string]time.Time)}
+}
+
+// Check records the nonce of req, verified as signed by id, and fails with
+// ErrRequestReplayed when it was recorded before.
+func (nonces *Nonces) Check(req *http.Request, id string) error {
+	unix, err := strconv.ParseInt(req.Header.Get(HeaderNodeDate), 10, 64)
+	if err != nil {
+		return ErrRequestSignature
+	}
+	now := time.Now()
+	key := id + " " + req.Header.Get(HeaderNodeNonce)
+	nonces.mu.Lock()
+	defer nonces.mu.Unlock()
+	if now.After(nonces.next) {
+		for k, expiry := range nonces.seen {
+			if now.After(expiry) {
+				delete(nonces.seen, k)
+			}
+		}
+		nonces.next = now.Add(maxRequestSkew)
+	}
+	if _, ok := nonces.seen[key]; ok {
+		return ErrRequestReplayed
+	}
+	nonces.seen[key] = time.Unix(unix, 0).Add(maxRequestSkew)
+	return nil
+}
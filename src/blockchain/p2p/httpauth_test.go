@@ -0,0 +1,145 @@
+package p2p
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestIdentity(t *testing.T) *Identity {
+	identity, err := NewIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
+
+// verify checks a request the way a node does, returning the signer and
+// the body it read.
+func verify(req *http.Request) (string, string, error) {
+	id, err := VerifyRequest(req)
+	if err != nil {
+		return "", "", err
+	}
+	if err := CheckBody(req); err != nil {
+		return "", "", err
+	}
+	body, err := io.ReadAll(req.Body)
+	return id, string(body), err
+}
+
+func signed(identity *Identity, method string, url string, body string, now time.Time) *http.Request {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	identity.signRequest(req, []byte(body), now)
+	return req
+}
+
+func TestTransportSignsRequests(t *testing.T) {
+	identity := newTestIdentity(t)
+	type result struct {
+		id, body string
+		err      error
+	}
+	results := make(chan result, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id, body, err := verify(req)
+		results <- result{id, body, err}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: identity.Transport(http.DefaultTransport)}
+	res, err := client.Post(server.URL+"/transactions/relay?x=1", "application/json", strings.NewReader(`{"amount":5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	got := <-results
+	if got.err != nil || got.id != identity.ID() || got.body != `{"amount":5}` {
+		t.Fatalf("verified %q with body %q, error %v; want %s", got.id, got.body, got.err, identity.ID())
+	}
+}
+
+func TestVerifyRequestRejectsTampering(t *testing.T) {
+	identity := newTestIdentity(t)
+	now := time.Now()
+
+	req := signed(identity, "POST", "http://node/blocks/compact", `{"height":1}`, now)
+	req.Body = io.NopCloser(strings.NewReader(`{"height":2}`))
+	if _, _, err := verify(req); err != ErrBodyDigest {
+		t.Errorf("other body: error = %v, want %v", err, ErrBodyDigest)
+	}
+
+	req = signed(identity, "GET", "http://node/chains", "", now)
+	req.URL.Path = "/nodes/resolve"
+	if _, _, err := verify(req); err != ErrRequestSignature {
+		t.Errorf("other path: error = %v, want %v", err, ErrRequestSignature)
+	}
+
+	req = signed(identity, "GET", "http://node/chains", "", now)
+	req.Host = "other"
+	if _, _, err := verify(req); err != ErrRequestSignature {
+		t.Errorf("other host: error = %v, want %v", err, ErrRequestSignature)
+	}
+
+	// Claiming another node's key does not make its signature valid.
+	req = signed(identity, "GET", "http://node/chains", "", now)
+	req.Header.Set(HeaderNodeKey, signed(newTestIdentity(t), "GET", "http://node/chains", "", now).Header.Get(HeaderNodeKey))
+	if _, _, err := verify(req); err != ErrRequestSignature {
+		t.Errorf("other key: error = %v, want %v", err, ErrRequestSignature)
+	}
+}
+
+func TestVerifyRequestRejectsStaleDates(t *testing.T) {
+	identity := newTestIdentity(t)
+	for _, now := range []time.Time{time.Now().Add(-2 * maxRequestSkew), time.Now().Add(2 * maxRequestSkew)} {
+		if _, err := VerifyRequest(signed(identity, "GET", "http://node/chains", "", now)); err != ErrRequestExpired {
+			t.Errorf("signed at %v: error = %v, want %v", now, err, ErrRequestExpired)
+		}
+	}
+}
+
+func TestUnsignedRequest(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://node/chains", nil)
+	if IsSigned(req) {
+		t.Fatal("unsigned request reported as signed")
+	}
+	if _, err := VerifyRequest(req); err != ErrRequestSignature {
+		t.Fatalf("error = %v, want %v", err, ErrRequestSignature)
+	}
+}
+
+func TestVerifyRequestRejectsOtherNonce(t *testing.T) {
+	identity := newTestIdentity(t)
+	req := signed(identity, "GET", "http://node/chains", "", time.Now())
+	req.Header.Set(HeaderNodeNonce, "0123")
+	if _, err := VerifyRequest(req); err != ErrRequestSignature {
+		t.Fatalf("error = %v, want %v", err, ErrRequestSignature)
+	}
+}
+
+func TestNoncesRefuseReplays(t *testing.T) {
+	identity := newTestIdentity(t)
+	nonces := NewNonces()
+	req := signed(identity, "POST", "http://node/transactions/relay", `{"amount":5}`, time.Now())
+	if err := nonces.Check(req, identity.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := nonces.Check(req, identity.ID()); err != ErrRequestReplayed {
+		t.Fatalf("replay: error = %v, want %v", err, ErrRequestReplayed)
+	}
+	// The same request signed again has a nonce of its own.
+	if err := nonces.Check(signed(identity, "POST", "http://node/transactions/relay", `{"amount":5}`, time.Now()), identity.ID()); err != nil {
+		t.Fatalf("request signed again: %v", err)
+	}
+	// Nonces are forgotten once their date is refused anyway.
+	old := signed(identity, "GET", "http://node/chains", "", time.Now().Add(-2*maxRequestSkew))
+	nonces.Check(old, identity.ID())
+	nonces.next = time.Time{}
+	nonces.Check(req, identity.ID())
+	if len(nonces.seen) != 2 {
+		t.Fatalf("%d nonces kept, want 2", len(nonces.seen))
+	}
+}
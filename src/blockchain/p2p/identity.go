@@ -0,0 +1,184 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const certificateLifetime = 365 * 24 * time.Hour
+
+var (
+	ErrInvalidIdentity = errors.New("p2p: invalid node identity key")
+	ErrPeerCertificate = errors.New("p2p: invalid peer certificate")
+	ErrPeerIdentity    = errors.New("p2p: peer identity does not match")
+	ErrPeerNotAllowed  = errors.New("p2p: peer is not in the allowed list")
+)
+
+// Identity is the long term key of a node. Peers know a node by its ID, the
+// hex SHA-256 of the public key, and check it on every connection.
+type Identity struct {
+	key ed25519.PrivateKey
+	id  string
+}
+
+func NewIdentity() (*Identity, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key, id: NodeID(key.Public().(ed25519.PublicKey))}, nil
+}
+
+// LoadIdentity reads the PEM encoded key at path, creating it on first use so
+// the node keeps its ID across restarts.
+func LoadIdentity(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		identity, err := NewIdentity()
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(identity.key)
+		if err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		// The key may be the first file of a new data directory.
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, err
+		}
+		return identity, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, ErrInvalidIdentity
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIdentity, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidIdentity
+	}
+	return &Identity{key: key, id: NodeID(key.Public().(ed25519.PublicKey))}, nil
+}
+
+// NodeID names the node holding the private half of key.
+func NodeID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+func (identity *Identity) ID() string {
+	return identity.id
+}
+
+// certificate self-signs the identity key. Peers check nothing but the key,
+// so a fresh certificate is made for every connection.
+func (identity *Identity) certificate() (tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: identity.id},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certificateLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, identity.key.Public(), identity.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: identity.key}, nil
+}
+
+// peerID checks that the peer's certificate is signed by its own ed25519
+// key, which proves the peer holds the key, and returns the node ID.
+func peerID(rawCerts [][]byte) (string, error) {
+	if len(rawCerts) == 0 {
+		return "", fmt.Errorf("%w: no certificate", ErrPeerCertificate)
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrPeerCertificate, err)
+	}
+	key, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return "", fmt.Errorf("%w: not an ed25519 key", ErrPeerCertificate)
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrPeerCertificate, err)
+	}
+	if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return "", fmt.Errorf("%w: expired or not yet valid", ErrPeerCertificate)
+	}
+	return NodeID(key), nil
+}
+
+// tlsConfig authenticates both sides by their identity keys instead of a
+// certificate authority. When expected is set the peer must have that ID;
+// when allowed is not empty the peer must be in it.
+func tlsConfig(cert tls.Certificate, expected string, allowed map[string]bool) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+		ClientAuth:   tls.RequireAnyClientCert,
+		// The chain is not verified against roots; VerifyPeerCertificate
+		// checks the self signature and pins the key.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			id, err := peerID(rawCerts)
+			if err != nil {
+				return err
+			}
+			if expected != "" && id != expected {
+				return fmt.Errorf("%w: expected %s, got %s", ErrPeerIdentity, expected, id)
+			}
+			if len(allowed) > 0 && !allowed[id] {
+				return fmt.Errorf("%w: %s", ErrPeerNotAllowed, id)
+			}
+			return nil
+		},
+	}
+}
+
+// ParseAddr splits a peer address of the form id@host:port; the ID is
+// optional and pins the peer's identity when present.
+func ParseAddr(addr string) (id string, hostport string, err error) {
+	hostport = addr
+	if at := strings.LastIndex(addr, "@"); at >= 0 {
+		id, hostport = strings.ToLower(addr[:at]), addr[at+1:]
+		if raw, err := hex.DecodeString(id); err != nil || len(raw) != sha256.Size {
+			return "", "", fmt.Errorf("p2p: invalid node id in %q", addr)
+		}
+	}
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		return "", "", fmt.Errorf("p2p: invalid peer address %q: %v", addr, err)
+	}
+	return id, hostport, nil
+}
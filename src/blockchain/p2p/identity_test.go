@@ -0,0 +1,22 @@
+package p2p
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadIdentityCreatesKey(t *testing.T) {
+	// The data directory may not exist yet on the first start.
+	path := filepath.Join(t.TempDir(), "data", "node.key")
+	created, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ID() != created.ID() {
+		t.Fatalf("loaded identity %s, want %s", loaded.ID(), created.ID())
+	}
+}
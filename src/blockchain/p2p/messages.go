@@ -0,0 +1,271 @@
+package p2p
+
+import "blockchain"
+
+// ProtocolVersion is sent in the handshake. Peers below
+// MinProtocolVersion are disconnected.
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+const (
+	CmdVersion   = "version"
+	CmdVerack    = "verack"
+	CmdPing      = "ping"
+	CmdPong      = "pong"
+	CmdInv       = "inv"
+	CmdGetData   = "getdata"
+	CmdNotFound  = "notfound"
+	CmdGetBlocks = "getblocks"
+	CmdBlock     = "block"
+	CmdTx        = "tx"
+)
+
+const (
+	// MaxInvItems bounds inv, getdata and notfound messages.
+	MaxInvItems = 50000
+	// MaxBlocksPerInv is how many block hashes answer one getblocks.
+	MaxBlocksPerInv = 500
+	maxLocator      = 64
+)
+
+// Message is a payload of the wire protocol.
+type Message interface {
+	Command() string
+	encode(e *encoder)
+	decode(d *decoder)
+}
+
+func newMessage(command string) Message {
+	switch command {
+	case CmdVersion:
+		return &Version{}
+	case CmdVerack:
+		return &Verack{}
+	case CmdPing:
+		return &Ping{}
+	case CmdPong:
+		return &Pong{}
+	case CmdInv:
+		return &Inv{}
+	case CmdGetData:
+		return &GetData{}
+	case CmdNotFound:
+		return &NotFound{}
+	case CmdGetBlocks:
+		return &GetBlocks{}
+	case CmdBlock:
+		return &BlockMessage{}
+	case CmdTx:
+		return &TxMessage{}
+	}
+	return nil
+}
+
+// Version opens the handshake. Nonce is random per node, so that a node
+// notices when it connected to itself.
+type Version struct {
+	Version    uint32
+	NetworkID  string
+	BestHeight uint64
+	Nonce      uint64
+	UserAgent  string
+	Timestamp  int64
+}
+
+func (msg *Version) Command() string { return CmdVersion }
+
+func (msg *Version) encode(e *encoder) {
+	e.uint32(msg.Version)
+	e.string(msg.NetworkID)
+	e.uint64(msg.BestHeight)
+	e.uint64(msg.Nonce)
+	e.string(msg.UserAgent)
+	e.int64(msg.Timestamp)
+}
+
+func (msg *Version) decode(d *decoder) {
+	msg.Version = d.uint32()
+	msg.NetworkID = d.string()
+	msg.BestHeight = d.uint64()
+	msg.Nonce = d.uint64()
+	msg.UserAgent = d.string()
+	msg.Timestamp = d.int64()
+}
+
+// Verack accepts the peer's Version.
+type Verack struct{}
+
+func (msg *Verack) Command() string   { return CmdVerack }
+func (msg *Verack) encode(e *encoder) {}
+func (msg *Verack) decode(d *decoder) {}
+
+type Ping struct {
+	Nonce uint64
+}
+
+func (msg *Ping) Command() string   { return CmdPing }
+func (msg *Ping) encode(e *encoder) { e.uint64(msg.Nonce) }
+func (msg *Ping) decode(d *decoder) { msg.Nonce = d.uint64() }
+
+// Pong answers a Ping with its nonce.
+type Pong struct {
+	Nonce uint64
+}
+
+func (msg *Pong) Command() string   { return CmdPong }
+func (msg *Pong) encode(e *encoder) { e.uint64(msg.Nonce) }
+func (msg *Pong) decode(d *decoder) { msg.Nonce = d.uint64() }
+
+type InvType uint8
+
+const (
+	InvTransaction InvType = 1
+	InvBlock       InvType = 2
+)
+
+// InvVector names a block or transaction by its hash.
+type InvVector struct {
+	Type InvType
+	Hash string
+}
+
+func encodeInventory(e *encoder, items []InvVector) {
+	e.length(len(items))
+	for _, item := range items {
+		e.uint8(uint8(item.Type))
+		e.hash(item.Hash)
+	}
+}
+
+func decodeInventory(d *decoder) []InvVector {
+	n := d.length(33)
+	if n > MaxInvItems {
+		d.err = ErrMalformed
+		return nil
+	}
+	items := make([]InvVector, n)
+	for i := range items {
+		items[i].Type = InvType(d.uint8())
+		items[i].Hash = d.hash()
+	}
+	return items
+}
+
+// Inv announces blocks and transactions the sender has.
+type Inv struct {
+	Items []InvVector
+}
+
+func (msg *Inv) Command() string   { return CmdInv }
+func (msg *Inv) encode(e *encoder) { encodeInventory(e, msg.Items) }
+func (msg *Inv) decode(d *decoder) { msg.Items = decodeInventory(d) }
+
+// GetData requests announced blocks and transactions.
+type GetData struct {
+	Items []InvVector
+}
+
+func (msg *GetData) Command() string   { return CmdGetData }
+func (msg *GetData) encode(e *encoder) { encodeInventory(e, msg.Items) }
+func (msg *GetData) decode(d *decoder) { msg.Items = decodeInventory(d) }
+
+// NotFound answers the items of a GetData the sender does not have.
+type NotFound struct {
+	Items []InvVector
+}
+
+func (msg *NotFound) Command() string   { return CmdNotFound }
+func (msg *NotFound) encode(e *encoder) { encodeInventory(e, msg.Items) }
+func (msg *NotFound) decode(d *decoder) { msg.Items = decodeInventory(d) }
+
+// GetBlocks asks for the hashes of the blocks after the first locator hash
+// the peer has, answered with an Inv.
+type GetBlocks struct {
+	Locator []string
+}
+
+func (msg *GetBlocks) Command() string { return CmdGetBlocks }
+
+func (msg *GetBlocks) encode(e *encoder) {
+	e.length(len(msg.Locator))
+	for _, hash := range msg.Locator {
+		e.hash(hash)
+	}
+}
+
+func (msg *GetBlocks) decode(d *decoder) {
+	n := d.length(32)
+	if n > maxLocator {
+		d.err = ErrMalformed
+		return
+	}
+	msg.Locator = make([]string, n)
+	for i := range msg.Locator {
+		msg.Locator[i] = d.hash()
+	}
+}
+
+type BlockMessage struct {
+	Block blockchain.Block
+}
+
+func (msg *BlockMessage) Command() string { return CmdBlock }
+
+func (msg *BlockMessage) encode(e *encoder) {
+	block := &msg.Block
+	e.uint64(uint64(block.Height))
+	e.int64(block.Timestamp)
+	e.uint64(uint64(block.Nonce))
+	e.hash(block.Hash)
+	e.hash(block.PreviousHash)
+	e.hash(block.MerkleHash)
+	e.string(block.Signer)
+	e.string(block.Signature)
+	e.length(len(block.Transactions))
+	for i := range block.Transactions {
+		encodeTransaction(e, &block.Transactions[i])
+	}
+}
+
+func (msg *BlockMessage) decode(d *decoder) {
+	block := &msg.Block
+	block.Height = int(d.uint64())
+	block.Timestamp = d.int64()
+	block.Nonce = int(d.uint64())
+	block.Hash = d.hash()
+	block.PreviousHash = d.hash()
+	block.MerkleHash = d.hash()
+	block.Signer = d.string()
+	block.Signature = d.string()
+	block.Transactions = make([]blockchain.Transaction, d.length(minTransactionSize))
+	for i := range block.Transactions {
+		decodeTransaction(d, &block.Transactions[i])
+	}
+}
+
+type TxMessage struct {
+	Transaction blockchain.Transaction
+}
+
+func (msg *TxMessage) Command() string   { return CmdTx }
+func (msg *TxMessage) encode(e *encoder) { encodeTransaction(e, &msg.Transaction) }
+func (msg *TxMessage) decode(d *decoder) { decodeTransaction(d, &msg.Transaction) }
+
+// minTransactionSize is the encoding of a transaction with empty addresses.
+const minTransactionSize = 8 + 1 + 1 + 8
+
+func encodeTransaction(e *encoder, transaction *blockchain.Transaction) {
+	e.int64(transaction.Timestamp)
+	e.string(transaction.Sender)
+	e.string(transaction.Recipient)
+	e.int64(transaction.Amount)
+}
+
+func decodeTransaction(d *decoder, transaction *blockchain.Transaction) {
+	transaction.Timestamp = d.int64()
+	transaction.Sender = d.string()
+	transaction.Recipient = d.string()
+	transaction.Amount = d.int64()
+}
@@ -0,0 +1,13 @@
+package p2p
+
+import "blockchain/metrics"
+
+var (
+	peersConnected = metrics.NewGauge(
+		"p2p_peers_connected",
+		"Number of connected wire protocol peers.")
+	messagesReceived = metrics.NewCounterVec(
+		"p2p_messages_received_total",
+		"Number of wire protocol messages received.",
+		"command")
+)
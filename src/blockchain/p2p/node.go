@@ -0,0 +1,630 @@
+// Package p2p implements the native peer protocol: persistent TCP
+// connections carrying checksummed binary frames, a version handshake,
+// keepalive pings and inventory based relay of blocks and transactions.
+package p2p
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"blockchain"
+)
+
+const (
+	DefaultPingInterval = 30 * time.Second
+	DefaultMaxInbound   = 64
+	handshakeTimeout    = 10 * time.Second
+	dialTimeout         = 10 * time.Second
+	eventQueueSize      = 1024
+	maxRedialInterval   = 5 * time.Minute
+)
+
+var (
+	ErrHandshake      = errors.New("p2p: handshake failed")
+	ErrNetworkID      = errors.New("p2p: peer is on another network")
+	ErrOldVersion     = errors.New("p2p: peer protocol version is too old")
+	ErrSelfConnection = errors.New("p2p: connected to self")
+	ErrNodeClosed     = errors.New("p2p: node is closed")
+	ErrUnexpected     = errors.New("p2p: unexpected message")
+	ErrTooManyPeers   = errors.New("p2p: too many inbound peers")
+)
+
+type Config struct {
+	NetworkID    string
+	UserAgent    string
+	PingInterval time.Duration
+	// Identity, when set, runs every connection over mutual TLS
+	// authenticated by the node identity keys. Without it traffic is
+	// plaintext and peers are anonymous.
+	Identity *Identity
+	// AllowedPeers limits the peers to these node IDs when not empty.
+	AllowedPeers []string
+	// MaxInbound bounds the connections Serve accepts at a time, including
+	// those still in the handshake; DefaultMaxInbound when zero.
+	MaxInbound int
+}
+
+// Node keeps the peer connections of a chain and relays its new blocks and
+// transactions to them.
+type Node struct {
+	chain     *blockchain.BlockChain
+	config    Config
+	magic     uint32
+	nonce     uint64
+	allowed   map[string]bool
+	logger    *slog.Logger
+	events    chan blockchain.Event
+	mu        sync.Mutex
+	peers     map[*Peer]struct{}
+	inbound   int
+	listeners []net.Listener
+	closed    bool
+	quit      chan struct{}
+}
+
+func NewNode(chain *blockchain.BlockChain, config Config) *Node {
+	if config.PingInterval == 0 {
+		config.PingInterval = DefaultPingInterval
+	}
+	if config.MaxInbound == 0 {
+		config.MaxInbound = DefaultMaxInbound
+	}
+	node := &Node{
+		chain:   chain,
+		config:  config,
+		magic:   Magic(config.NetworkID),
+		nonce:   randomNonce(),
+		allowed: make(map[string]bool),
+		logger:  slog.Default(),
+		events:  make(chan blockchain.Event, eventQueueSize),
+		peers:   make(map[*Peer]struct{}),
+		quit:    make(chan struct{}),
+	}
+	for _, id := range config.AllowedPeers {
+		node.allowed[strings.ToLower(id)] = true
+	}
+	chain.Listen(node.handleEvent)
+	go node.announceLoop()
+	return node
+}
+
+// ID returns the node ID, or "" when the node has no identity.
+func (node *Node) ID() string {
+	if node.config.Identity == nil {
+		return ""
+	}
+	return node.config.Identity.ID()
+}
+
+func (node *Node) SetLogger(logger *slog.Logger) {
+	node.logger = logger
+}
+
+func randomNonce() uint64 {
+	var buf [8]byte
+	rand.Read(buf[:])
+	return binary.BigEndian.Uint64(buf[:]) | 1
+}
+
+// Serve accepts peers on listener until it or the node is closed.
+func (node *Node) Serve(listener net.Listener) error {
+	node.mu.Lock()
+	if node.closed {
+		node.mu.Unlock()
+		return ErrNodeClosed
+	}
+	node.listeners = append(node.listeners, listener)
+	node.mu.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-node.quit:
+				return ErrNodeClosed
+			default:
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		if !node.reserveInbound() {
+			node.logger.Debug("inbound peer rejected", "addr", conn.RemoteAddr().String(), "error", ErrTooManyPeers)
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer node.releaseInbound()
+			peer, err := node.AddConn(conn, true)
+			if err != nil {
+				node.logger.Debug("inbound peer rejected", "addr", conn.RemoteAddr().String(), "error", err)
+				return
+			}
+			<-peer.Done()
+		}()
+	}
+}
+
+// reserveInbound takes one of the MaxInbound slots for an accepted
+// connection, or reports false when none is free.
+func (node *Node) reserveInbound() bool {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.inbound >= node.config.MaxInbound {
+		return false
+	}
+	node.inbound++
+	return true
+}
+
+func (node *Node) releaseInbound() {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.inbound--
+}
+
+// Connect dials a peer at addr, a host:port optionally prefixed with the
+// peer's node ID and "@" to refuse any other node at that address.
+func (node *Node) Connect(addr string) (*Peer, error) {
+	id, hostport, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", hostport, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return node.addConn(conn, false, id)
+}
+
+// ConnectPersistent keeps a connection to addr, redialing with backoff
+// whenever it drops, until the node is closed.
+func (node *Node) ConnectPersistent(addr string) {
+	go func() {
+		wait := time.Second
+		for {
+			peer, err := node.Connect(addr)
+			if err == nil {
+				wait = time.Second
+				err = peer.Err()
+			}
+			node.logger.Debug("peer connection lost", "addr", addr, "error", err, "retry_in", wait)
+			select {
+			case <-time.After(wait):
+			case <-node.quit:
+				return
+			}
+			if wait *= 2; wait > maxRedialInterval {
+				wait = maxRedialInterval
+			}
+		}
+	}()
+}
+
+// AddConn runs the handshake on conn and, when it succeeds, serves the peer
+// until it disconnects. Any connection works, which lets the simulated
+// network use in-memory pipes.
+func (node *Node) AddConn(conn net.Conn, inbound bool) (*Peer, error) {
+	return node.addConn(conn, inbound, "")
+}
+
+func (node *Node) addConn(conn net.Conn, inbound bool, expectedID string) (*Peer, error) {
+	conn, id, err := node.secure(conn, inbound, expectedID)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	peer := newPeer(node, conn, inbound, id)
+	go peer.writeLoop()
+	if err := node.handshake(peer); err != nil {
+		peer.Close(err)
+		return nil, err
+	}
+
+	node.mu.Lock()
+	if node.closed {
+		node.mu.Unlock()
+		peer.Close(ErrNodeClosed)
+		return nil, ErrNodeClosed
+	}
+	node.peers[peer] = struct{}{}
+	node.mu.Unlock()
+	peersConnected.Add(1)
+	node.logger.Info("peer connected",
+		"addr", peer.addr,
+		"id", peer.id,
+		"inbound", inbound,
+		"user_agent", peer.version.UserAgent,
+		"best_height", peer.version.BestHeight)
+
+	go node.readLoop(peer)
+	go peer.pingLoop(node.config.PingInterval)
+	if int(peer.version.BestHeight) > node.chain.Height() {
+		peer.queue(&GetBlocks{Locator: node.chain.Locator()})
+	}
+	return peer, nil
+}
+
+// secure runs the TLS handshake on conn when the node has an identity and
+// returns the encrypted connection and the authenticated peer ID.
+func (node *Node) secure(conn net.Conn, inbound bool, expectedID string) (net.Conn, string, error) {
+	identity := node.config.Identity
+	if identity == nil {
+		if expectedID != "" {
+			return conn, "", fmt.Errorf("%w: node has no identity to authenticate %s", ErrHandshake, expectedID)
+		}
+		return conn, "", nil
+	}
+	cert, err := identity.certificate()
+	if err != nil {
+		return conn, "", err
+	}
+	config := tlsConfig(cert, expectedID, node.allowed)
+	var tlsConn *tls.Conn
+	if inbound {
+		tlsConn = tls.Server(conn, config)
+	} else {
+		tlsConn = tls.Client(conn, config)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return conn, "", fmt.Errorf("%w: %v", ErrHandshake, err)
+	}
+	// VerifyPeerCertificate has checked the key, so the ID is known good.
+	key := tlsConn.ConnectionState().PeerCertificates[0].PublicKey.(ed25519.PublicKey)
+	if id := NodeID(key); id != identity.ID() {
+		return tlsConn, id, nil
+	}
+	return tlsConn, "", ErrSelfConnection
+}
+
+// handshake exchanges Version and Verack. Both sides send their Version
+// first, so neither waits for the other.
+func (node *Node) handshake(peer *Peer) error {
+	peer.queue(&Version{
+		Version:    ProtocolVersion,
+		NetworkID:  node.config.NetworkID,
+		BestHeight: uint64(node.chain.Height()),
+		Nonce:      node.nonce,
+		UserAgent:  node.config.UserAgent,
+		Timestamp:  time.Now().Unix(),
+	})
+	peer.conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	msg, err := ReadMessage(peer.conn, node.magic)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrHandshake, err)
+	}
+	version, ok := msg.(*Version)
+	switch {
+	case !ok:
+		return fmt.Errorf("%w: expected version, got %s", ErrHandshake, msg.Command())
+	case version.NetworkID != node.config.NetworkID:
+		return ErrNetworkID
+	case version.Version < MinProtocolVersion:
+		return ErrOldVersion
+	case version.Nonce == node.nonce:
+		return ErrSelfConnection
+	}
+	peer.mu.Lock()
+	peer.version = version
+	peer.bestHeight = int(version.BestHeight)
+	peer.mu.Unlock()
+
+	peer.queue(&Verack{})
+	msg, err = ReadMessage(peer.conn, node.magic)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrHandshake, err)
+	}
+	if _, ok := msg.(*Verack); !ok {
+		return fmt.Errorf("%w: expected verack, got %s", ErrHandshake, msg.Command())
+	}
+	return nil
+}
+
+func (node *Node) readLoop(peer *Peer) {
+	defer node.removePeer(peer)
+	// Dead peers are found by the ping loop, which closes the connection.
+	peer.conn.SetReadDeadline(time.Time{})
+	for {
+		msg, err := ReadMessage(peer.conn, node.magic)
+		if err == ErrUnknownCommand {
+			continue
+		}
+		if err != nil {
+			peer.Close(err)
+			return
+		}
+		messagesReceived.WithLabelValues(msg.Command()).Inc()
+		if err := node.handle(peer, msg); err != nil {
+			node.logger.Warn("disconnecting misbehaving peer", "addr", peer.addr, "command", msg.Command(), "error", err)
+			peer.Close(err)
+			return
+		}
+	}
+}
+
+func (node *Node) removePeer(peer *Peer) {
+	node.mu.Lock()
+	delete(node.peers, peer)
+	node.mu.Unlock()
+	peersConnected.Add(-1)
+	node.logger.Info("peer disconnected", "addr", peer.addr, "reason", peer.Err())
+}
+
+// Peers describes the connected peers.
+func (node *Node) Peers() []PeerInfo {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	infos := make([]PeerInfo, 0, len(node.peers))
+	for peer := range node.peers {
+		infos = append(infos, peer.Info())
+	}
+	return infos
+}
+
+func (node *Node) connectedPeers() []*Peer {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	peers := make([]*Peer, 0, len(node.peers))
+	for peer := range node.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// Close stops the listeners and disconnects every peer.
+func (node *Node) Close() {
+	node.mu.Lock()
+	if node.closed {
+		node.mu.Unlock()
+		return
+	}
+	node.closed = true
+	close(node.quit)
+	listeners := node.listeners
+	node.mu.Unlock()
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	for _, peer := range node.connectedPeers() {
+		peer.Close(ErrNodeClosed)
+	}
+}
+
+func (node *Node) handle(peer *Peer, msg Message) error {
+	switch msg := msg.(type) {
+	case *Ping:
+		peer.queue(&Pong{Nonce: msg.Nonce})
+	case *Pong:
+		peer.pong(msg.Nonce)
+	case *Inv:
+		node.handleInv(peer, msg)
+	case *GetData:
+		node.handleGetData(peer, msg)
+	case *NotFound:
+		node.logger.Debug("peer does not have requested items", "addr", peer.addr, "items", len(msg.Items))
+	case *GetBlocks:
+		hashes := node.chain.BlocksAfter(msg.Locator, MaxBlocksPerInv)
+		if len(hashes) > 0 {
+			items := make([]InvVector, len(hashes))
+			for i, hash := range hashes {
+				items[i] = InvVector{Type: InvBlock, Hash: hash}
+			}
+			peer.queue(&Inv{Items: items})
+		}
+	case *BlockMessage:
+		return node.handleBlock(peer, &msg.Block)
+	case *TxMessage:
+		node.handleTransaction(peer, &msg.Transaction)
+	default:
+		return ErrUnexpected
+	}
+	return nil
+}
+
+func (node *Node) handleInv(peer *Peer, inv *Inv) {
+	var want []InvVector
+	blocks := 0
+	for _, item := range inv.Items {
+		peer.markKnown(item.Hash)
+		switch item.Type {
+		case InvBlock:
+			blocks++
+			if _, ok := node.chain.BlockByHash(item.Hash); !ok {
+				want = append(want, item)
+			}
+		case InvTransaction:
+			if _, ok := node.chain.PooledTransaction(item.Hash); ok {
+				continue
+			}
+			if _, ok := node.chain.Transaction(item.Hash); !ok {
+				want = append(want, item)
+			}
+		}
+	}
+	// A full batch of block hashes answers a getblocks; ask for the next
+	// batch once its last block arrives.
+	if blocks == MaxBlocksPerInv && len(want) > 0 {
+		peer.mu.Lock()
+		peer.syncTail = want[len(want)-1].Hash
+		peer.mu.Unlock()
+	}
+	if len(want) > 0 {
+		peer.queue(&GetData{Items: want})
+	}
+}
+
+func (node *Node) handleGetData(peer *Peer, getData *GetData) {
+	var notFound []InvVector
+	for _, item := range getData.Items {
+		switch item.Type {
+		case InvBlock:
+			if block, ok := node.chain.BlockByHash(item.Hash); ok && !block.Pruned {
+				peer.queue(&BlockMessage{Block: *block})
+				continue
+			}
+		case InvTransaction:
+			if transaction, ok := node.chain.PooledTransaction(item.Hash); ok {
+				peer.queue(&TxMessage{Transaction: *transaction})
+				continue
+			}
+			if info, ok := node.chain.Transaction(item.Hash); ok {
+				peer.queue(&TxMessage{Transaction: info.Transaction})
+				continue
+			}
+		}
+		notFound = append(notFound, item)
+	}
+	if len(notFound) > 0 {
+		peer.queue(&NotFound{Items: notFound})
+	}
+}
+
+// handleBlock appends a block to the tip, collects side chain blocks until
+// their branch wins, and asks for the missing blocks of unknown parents.
+// Only invalid blocks are an error.
+func (node *Node) handleBlock(peer *Peer, block *blockchain.Block) error {
+	ctx := context.Background()
+	peer.markKnown(block.Hash)
+	peer.setBestHeight(block.Height)
+	err := node.chain.AddBlock(ctx, block)
+	switch err {
+	case nil, blockchain.ErrKnownBlock:
+	case blockchain.ErrOrphanBlock:
+		if err := node.extendBranch(ctx, peer, block); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	peer.mu.Lock()
+	next := block.Hash == peer.syncTail
+	if next {
+		peer.syncTail = ""
+	}
+	peer.mu.Unlock()
+	if next {
+		peer.queue(&GetBlocks{Locator: node.chain.Locator()})
+	}
+	return nil
+}
+
+func (node *Node) extendBranch(ctx context.Context, peer *Peer, block *blockchain.Block) error {
+	peer.mu.Lock()
+	switch {
+	case len(peer.branch) > 0 && peer.branch[len(peer.branch)-1].Hash == block.PreviousHash:
+		peer.branch = append(peer.branch, *block)
+	default:
+		if _, ok := node.chain.BlockByHash(block.PreviousHash); !ok {
+			peer.branch = nil
+			peer.mu.Unlock()
+			peer.queue(&GetBlocks{Locator: node.chain.Locator()})
+			return nil
+		}
+		peer.branch = []blockchain.Block{*block}
+	}
+	if len(peer.branch) > maxBranch {
+		peer.branch = nil
+	}
+	branch := peer.branch
+	peer.mu.Unlock()
+
+	replaced, err := node.chain.AddBranch(ctx, branch)
+	if err == blockchain.ErrOrphanBlock {
+		// The fork point was reorganized away meanwhile; start over.
+		peer.mu.Lock()
+		peer.branch = nil
+		peer.mu.Unlock()
+		peer.queue(&GetBlocks{Locator: node.chain.Locator()})
+		return nil
+	}
+	if replaced || err != nil {
+		peer.mu.Lock()
+		peer.branch = nil
+		peer.mu.Unlock()
+	}
+	if replaced {
+		node.logger.Info("switched to peer's branch", "addr", peer.addr, "height", block.Height, "hash", block.Hash)
+	}
+	return err
+}
+
+func (node *Node) handleTransaction(peer *Peer, transaction *blockchain.Transaction) {
+	peer.markKnown(transaction.Hash())
+	if _, err := node.chain.AddRelayedTransaction(context.Background(), transaction); err != nil {
+		node.logger.Debug("relayed transaction rejected", "addr", peer.addr, "txid", transaction.Hash(), "error", err)
+	}
+}
+
+// handleEvent runs with the chain locked, so it only queues the event.
+func (node *Node) handleEvent(event blockchain.Event) {
+	if event.Type != blockchain.EventBlock && event.Type != blockchain.EventTransaction {
+		return
+	}
+	select {
+	case node.events <- event:
+	default:
+		node.logger.Warn("p2p event queue full, dropping event", "type", event.Type)
+	}
+}
+
+// announceLoop sends the new tips and pooled transactions to the peers that
+// do not have them yet, batching the events queued meanwhile into one inv.
+func (node *Node) announceLoop() {
+	for {
+		var events []blockchain.Event
+		select {
+		case event := <-node.events:
+			events = append(events, event)
+		case <-node.quit:
+			return
+		}
+	drain:
+		for len(events) < MaxInvItems {
+			select {
+			case event := <-node.events:
+				events = append(events, event)
+			default:
+				break drain
+			}
+		}
+
+		tip := node.chain.TipHash()
+		var items []InvVector
+		for _, event := range events {
+			switch event.Type {
+			case blockchain.EventBlock:
+				if event.Block.Hash == tip {
+					items = append(items, InvVector{Type: InvBlock, Hash: tip})
+				}
+			case blockchain.EventTransaction:
+				items = append(items, InvVector{Type: InvTransaction, Hash: event.Transaction.Hash()})
+			}
+		}
+		for _, peer := range node.connectedPeers() {
+			var unknown []InvVector
+			for _, item := range items {
+				if !peer.knows(item.Hash) {
+					peer.markKnown(item.Hash)
+					unknown = append(unknown, item)
+				}
+			}
+			if len(unknown) > 0 {
+				peer.queue(&Inv{Items: unknown})
+			}
+		}
+	}
+}
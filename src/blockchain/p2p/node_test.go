@@ -0,0 +1,229 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"blockchain"
+)
+
+const testTimeout = 5 * time.Second
+
+func newTestNode(t *testing.T, config Config) *Node {
+	params := blockchain.DefaultPowParams
+	params.Difficulty = 1
+	engine, err := blockchain.NewProofOfWork(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.NewBlockChain(engine, blockchain.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.NetworkID == "" {
+		config.NetworkID = "test"
+	}
+	node := NewNode(chain, config)
+	t.Cleanup(node.Close)
+	return node
+}
+
+// mine seals a block on node, retrying when a relayed block made it stale.
+func mine(t *testing.T, node *Node, timestamp int64) {
+	for {
+		_, err := node.chain.Mine(context.Background(), timestamp)
+		if err == nil {
+			return
+		}
+		if err != blockchain.ErrStaleBlock {
+			t.Fatal(err)
+		}
+	}
+}
+
+// link runs the handshake between a and b over a pipe and returns the
+// errors of both sides.
+func link(a *Node, b *Node) (*Peer, *Peer, error) {
+	connA, connB := net.Pipe()
+	type result struct {
+		peer *Peer
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		peer, err := b.AddConn(connB, true)
+		results <- result{peer, err}
+	}()
+	peerA, errA := a.AddConn(connA, false)
+	r := <-results
+	return peerA, r.peer, errors.Join(errA, r.err)
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	deadline := time.Now().Add(testTimeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	a := newTestNode(t, Config{UserAgent: "a"})
+	b := newTestNode(t, Config{UserAgent: "b"})
+	mine(t, b, blockchain.GenesisTimestamp+1)
+	peerA, peerB, err := link(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := peerA.Info(); info.UserAgent != "b" || info.BestHeight != 1 || info.Inbound {
+		t.Fatalf("a's view of b = %+v", info)
+	}
+	if info := peerB.Info(); info.UserAgent != "a" || info.BestHeight != 0 || !info.Inbound {
+		t.Fatalf("b's view of a = %+v", info)
+	}
+	if len(a.Peers()) != 1 || len(b.Peers()) != 1 {
+		t.Fatalf("a has %d peers and b %d, want 1 each", len(a.Peers()), len(b.Peers()))
+	}
+	// a is behind and syncs b's block after the handshake.
+	waitFor(t, "a to sync", func() bool { return a.chain.TipHash() == b.chain.TipHash() })
+}
+
+func TestHandshakeRejectsOtherNetwork(t *testing.T) {
+	a := newTestNode(t, Config{NetworkID: "main"})
+	b := newTestNode(t, Config{NetworkID: "test"})
+	_, _, err := link(a, b)
+	if !errors.Is(err, ErrHandshake) || !errors.Is(err, ErrBadMagic) {
+		t.Fatalf("link across networks: error = %v, want %v", err, ErrBadMagic)
+	}
+	if len(a.Peers()) != 0 || len(b.Peers()) != 0 {
+		t.Fatal("peers kept after a failed handshake")
+	}
+
+	// A peer using the network's magic with another network ID.
+	node := newTestNode(t, Config{})
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := node.AddConn(conn, true)
+		errs <- err
+	}()
+	go ReadMessage(remote, node.magic)
+	WriteMessage(remote, node.magic, &Version{Version: ProtocolVersion, NetworkID: "other", Nonce: 1})
+	if err := <-errs; err != ErrNetworkID {
+		t.Fatalf("handshake with another network ID: error = %v, want %v", err, ErrNetworkID)
+	}
+}
+
+func TestHandshakeRejectsSelf(t *testing.T) {
+	node := newTestNode(t, Config{})
+	if _, _, err := link(node, node); !errors.Is(err, ErrSelfConnection) {
+		t.Fatalf("link to self: error = %v, want %v", err, ErrSelfConnection)
+	}
+}
+
+func TestRelayTransactionAndBlock(t *testing.T) {
+	a := newTestNode(t, Config{})
+	b := newTestNode(t, Config{})
+	c := newTestNode(t, Config{})
+	if _, _, err := link(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := link(b, c); err != nil {
+		t.Fatal(err)
+	}
+
+	// The transaction crosses b to reach c by inv and getdata.
+	transaction := &blockchain.Transaction{Sender: "alice", Recipient: "bob", Amount: 5}
+	if err := a.chain.AddTransaction(context.Background(), transaction); err != nil {
+		t.Fatal(err)
+	}
+	txid := transaction.Hash()
+	waitFor(t, "the transaction to reach c", func() bool {
+		_, ok := c.chain.PooledTransaction(txid)
+		return ok
+	})
+
+	mine(t, c, blockchain.GenesisTimestamp+1)
+	waitFor(t, "the block to reach a", func() bool { return a.chain.TipHash() == c.chain.TipHash() })
+	if _, ok := a.chain.Transaction(txid); !ok {
+		t.Fatal("relayed block does not confirm the transaction on a")
+	}
+	if pending := a.chain.PendingTransactions(); len(pending) != 0 {
+		t.Fatalf("a still pools %d transactions", len(pending))
+	}
+}
+
+func TestConvergeAfterPartition(t *testing.T) {
+	sim := NewSimNetwork()
+	defer sim.Close()
+	names := []string{"n0", "n1", "n2"}
+	for _, name := range names {
+		sim.AddNode(name, newTestNode(t, Config{UserAgent: name}))
+	}
+	if err := sim.Link("n0", "n1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Link("n1", "n2"); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, sim.Node("n0"), blockchain.GenesisTimestamp+1)
+	if err := sim.WaitConverged(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	// n2 mines a longer branch while cut off.
+	sim.Unlink("n1", "n2")
+	mine(t, sim.Node("n0"), blockchain.GenesisTimestamp+10)
+	for i := int64(0); i < 3; i++ {
+		mine(t, sim.Node("n2"), blockchain.GenesisTimestamp+20+i)
+	}
+	if sim.Node("n0").chain.TipHash() == sim.Node("n2").chain.TipHash() {
+		t.Fatal("partitioned nodes share a tip")
+	}
+	if err := sim.Link("n1", "n2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.WaitConverged(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if height := sim.Node("n0").chain.Height(); height != 4 {
+		t.Fatalf("converged at height %d, want n2's branch at 4", height)
+	}
+}
+
+func TestServeLimitsInbound(t *testing.T) {
+	server := newTestNode(t, Config{MaxInbound: 1})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	addr := listener.Addr().String()
+
+	first := newTestNode(t, Config{})
+	peer, err := first.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := newTestNode(t, Config{})
+	if _, err := second.Connect(addr); !errors.Is(err, ErrHandshake) {
+		t.Fatalf("connecting over the limit: error = %v, want %v", err, ErrHandshake)
+	}
+
+	// The slot is free again once the first peer leaves.
+	peer.Close(fmt.Errorf("test done"))
+	waitFor(t, "the slot to be released", func() bool {
+		peer, err := second.Connect(addr)
+		if err == nil {
+			peer.Close(fmt.Errorf("test done"))
+		}
+		return err == nil
+	})
+}
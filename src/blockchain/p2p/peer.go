@@ -0,0 +1,237 @@
+package p2p
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"blockchain"
+)
+
+const (
+	// maxQueuedMessages bounds the messages waiting to be written to a
+	// peer; a peer that falls further behind is disconnected.
+	maxQueuedMessages = 2 * MaxInvItems
+	// maxKnownInventory bounds the hashes remembered per peer; the oldest
+	// are forgotten first.
+	maxKnownInventory = 50000
+	maxBranch         = 2000
+	pingTimeouts      = 4
+	writeTimeout      = 30 * time.Second
+)
+
+var (
+	ErrSlowPeer    = errors.New("p2p: peer does not keep up with its messages")
+	ErrPingTimeout = errors.New("p2p: peer did not answer ping")
+)
+
+// PeerInfo describes a connected peer.
+type PeerInfo struct {
+	Addr string `json:"addr"`
+	// ID is the authenticated node ID, empty on a plaintext connection.
+	ID         string    `json:"id,omitempty"`
+	Inbound    bool      `json:"inbound"`
+	Version    uint32    `json:"version"`
+	UserAgent  string    `json:"user_agent"`
+	BestHeight int       `json:"best_height"`
+	PingMS     float64   `json:"ping_ms"`
+	Connected  time.Time `json:"connected"`
+}
+
+// Peer is one connection. Frames are written by a single goroutine from the
+// outbox; the node reads and handles the peer's messages. Queueing never
+// blocks, so two peers answering each other cannot deadlock.
+type Peer struct {
+	node      *Node
+	conn      net.Conn
+	addr      string
+	id        string
+	inbound   bool
+	connected time.Time
+	notify    chan struct{}
+	quit      chan struct{}
+	closeOnce sync.Once
+	err       error
+
+	mu         sync.Mutex
+	outbox     []Message
+	version    *Version
+	bestHeight int
+	pingNonce  uint64
+	pingSent   time.Time
+	pingTime   time.Duration
+	known      map[string]bool
+	knownOrder []string
+	// branch holds blocks of a side chain until it wins or is dropped.
+	branch []blockchain.Block
+	// syncTail is the last hash of the latest getblocks answer; receiving
+	// it asks for the next batch.
+	syncTail string
+}
+
+func newPeer(node *Node, conn net.Conn, inbound bool, id string) *Peer {
+	return &Peer{
+		node:      node,
+		conn:      conn,
+		addr:      conn.RemoteAddr().String(),
+		id:        id,
+		inbound:   inbound,
+		connected: time.Now(),
+		notify:    make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+		known:     make(map[string]bool),
+	}
+}
+
+func (peer *Peer) Addr() string {
+	return peer.addr
+}
+
+// ID returns the peer's authenticated node ID, or "" without encryption.
+func (peer *Peer) ID() string {
+	return peer.id
+}
+
+// Done is closed when the peer disconnects.
+func (peer *Peer) Done() <-chan struct{} {
+	return peer.quit
+}
+
+// Err returns why the peer disconnected.
+func (peer *Peer) Err() error {
+	<-peer.quit
+	return peer.err
+}
+
+func (peer *Peer) Info() PeerInfo {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	info := PeerInfo{
+		Addr:       peer.addr,
+		ID:         peer.id,
+		Inbound:    peer.inbound,
+		BestHeight: peer.bestHeight,
+		PingMS:     float64(peer.pingTime) / float64(time.Millisecond),
+		Connected:  peer.connected,
+	}
+	if peer.version != nil {
+		info.Version = peer.version.Version
+		info.UserAgent = peer.version.UserAgent
+	}
+	return info
+}
+
+// Close disconnects the peer, recording err as the reason.
+func (peer *Peer) Close(err error) {
+	peer.closeOnce.Do(func() {
+		peer.err = err
+		close(peer.quit)
+		peer.conn.Close()
+	})
+}
+
+// queue adds msg to the outbox.
+func (peer *Peer) queue(msg Message) {
+	peer.mu.Lock()
+	if len(peer.outbox) >= maxQueuedMessages {
+		peer.mu.Unlock()
+		peer.Close(ErrSlowPeer)
+		return
+	}
+	peer.outbox = append(peer.outbox, msg)
+	peer.mu.Unlock()
+	select {
+	case peer.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (peer *Peer) writeLoop() {
+	for {
+		select {
+		case <-peer.notify:
+		case <-peer.quit:
+			return
+		}
+		peer.mu.Lock()
+		outbox := peer.outbox
+		peer.outbox = nil
+		peer.mu.Unlock()
+		for _, msg := range outbox {
+			peer.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := WriteMessage(peer.conn, peer.node.magic, msg); err != nil {
+				peer.Close(err)
+				return
+			}
+		}
+	}
+}
+
+// pingLoop pings the peer every interval. A peer busy with a backlog may
+// answer late, so it is only dropped when a ping goes unanswered for
+// pingTimeouts intervals.
+func (peer *Peer) pingLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-peer.quit:
+			return
+		}
+		peer.mu.Lock()
+		if peer.pingNonce != 0 {
+			late := time.Since(peer.pingSent) > pingTimeouts*interval
+			peer.mu.Unlock()
+			if late {
+				peer.Close(ErrPingTimeout)
+				return
+			}
+			continue
+		}
+		peer.pingNonce = randomNonce()
+		peer.pingSent = time.Now()
+		nonce := peer.pingNonce
+		peer.mu.Unlock()
+		peer.queue(&Ping{Nonce: nonce})
+	}
+}
+
+func (peer *Peer) pong(nonce uint64) {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if nonce == peer.pingNonce {
+		peer.pingTime = time.Since(peer.pingSent)
+		peer.pingNonce = 0
+	}
+}
+
+// markKnown records that the peer has hash, so it is not announced back.
+func (peer *Peer) markKnown(hash string) {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if peer.known[hash] {
+		return
+	}
+	peer.known[hash] = true
+	peer.knownOrder = append(peer.knownOrder, hash)
+	if len(peer.knownOrder) > maxKnownInventory {
+		delete(peer.known, peer.knownOrder[0])
+		peer.knownOrder = peer.knownOrder[1:]
+	}
+}
+
+func (peer *Peer) knows(hash string) bool {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	return peer.known[hash]
+}
+
+func (peer *Peer) setBestHeight(height int) {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if height > peer.bestHeight {
+		peer.bestHeight = height
+	}
+}
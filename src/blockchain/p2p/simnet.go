@@ -0,0 +1,168 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrNotConverged = errors.New("p2p: simulated nodes did not converge")
+
+// SimNetwork links nodes in memory with net.Pipe instead of sockets, with
+// an optional one way latency per frame. Links can be cut and restored to
+// simulate partitions.
+type SimNetwork struct {
+	mu      sync.Mutex
+	latency time.Duration
+	nodes   map[string]*Node
+	links   map[[2]string][2]net.Conn
+}
+
+func NewSimNetwork() *SimNetwork {
+	return &SimNetwork{
+		nodes: make(map[string]*Node),
+		links: make(map[[2]string][2]net.Conn),
+	}
+}
+
+// SetLatency delays every write on links made afterwards.
+func (sim *SimNetwork) SetLatency(latency time.Duration) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.latency = latency
+}
+
+func (sim *SimNetwork) AddNode(name string, node *Node) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.nodes[name] = node
+}
+
+func (sim *SimNetwork) Node(name string) *Node {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.nodes[name]
+}
+
+func linkKey(a string, b string) [2]string {
+	if b < a {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+// Link connects node a to node b, a dialing out, and waits for both sides of
+// the handshake.
+func (sim *SimNetwork) Link(a string, b string) error {
+	sim.mu.Lock()
+	nodeA, nodeB := sim.nodes[a], sim.nodes[b]
+	latency := sim.latency
+	sim.mu.Unlock()
+	if nodeA == nil || nodeB == nil {
+		return fmt.Errorf("p2p: unknown simulated node %q or %q", a, b)
+	}
+	pipeA, pipeB := net.Pipe()
+	connA := &simConn{Conn: pipeA, remote: simAddr(b), latency: latency}
+	connB := &simConn{Conn: pipeB, remote: simAddr(a), latency: latency}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := nodeB.AddConn(connB, true)
+		errs <- err
+	}()
+	_, errA := nodeA.AddConn(connA, false)
+	errB := <-errs
+	if errA != nil || errB != nil {
+		connA.Close()
+		connB.Close()
+		if errA != nil {
+			return errA
+		}
+		return errB
+	}
+	sim.mu.Lock()
+	sim.links[linkKey(a, b)] = [2]net.Conn{connA, connB}
+	sim.mu.Unlock()
+	return nil
+}
+
+// Unlink cuts the link between a and b, as a network partition would.
+func (sim *SimNetwork) Unlink(a string, b string) {
+	sim.mu.Lock()
+	conns, ok := sim.links[linkKey(a, b)]
+	delete(sim.links, linkKey(a, b))
+	sim.mu.Unlock()
+	if ok {
+		conns[0].Close()
+		conns[1].Close()
+	}
+}
+
+// Tips returns the tip hash of every node by name.
+func (sim *SimNetwork) Tips() map[string]string {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	tips := make(map[string]string, len(sim.nodes))
+	for name, node := range sim.nodes {
+		tips[name] = node.chain.TipHash()
+	}
+	return tips
+}
+
+// WaitConverged waits until every node has the same tip.
+func (sim *SimNetwork) WaitConverged(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		tips := sim.Tips()
+		converged := true
+		var first string
+		for _, tip := range tips {
+			if first == "" {
+				first = tip
+			}
+			converged = converged && tip == first
+		}
+		if converged {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrNotConverged
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Close disconnects every simulated node.
+func (sim *SimNetwork) Close() {
+	sim.mu.Lock()
+	nodes := sim.nodes
+	sim.nodes = make(map[string]*Node)
+	sim.mu.Unlock()
+	for _, node := range nodes {
+		node.Close()
+	}
+}
+
+type simAddr string
+
+func (addr simAddr) Network() string { return "sim" }
+func (addr simAddr) String() string  { return string(addr) }
+
+type simConn struct {
+	net.Conn
+	remote  simAddr
+	latency time.Duration
+}
+
+func (conn *simConn) RemoteAddr() net.Addr {
+	return conn.remote
+}
+
+func (conn *simConn) Write(b []byte) (int, error) {
+	if conn.latency > 0 {
+		time.Sleep(conn.latency)
+	}
+	return conn.Conn.Write(b)
+}
@@ -0,0 +1,202 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+// A frame is a 24 byte header followed by the payload:
+//
+//	magic     uint32   derived from the network ID
+//	command   [12]byte ASCII, zero padded
+//	length    uint32   payload length
+//	checksum  [4]byte  first bytes of SHA-256 of the payload
+//
+// All integers are big-endian.
+const (
+	headerSize     = 24
+	commandSize    = 12
+	checksumSize   = 4
+	MaxPayloadSize = 32 << 20
+)
+
+var (
+	ErrBadMagic        = errors.New("p2p: frame is for another network")
+	ErrBadChecksum     = errors.New("p2p: frame checksum mismatch")
+	ErrPayloadTooLarge = errors.New("p2p: frame payload too large")
+	ErrUnknownCommand  = errors.New("p2p: unknown command")
+	ErrMalformed       = errors.New("p2p: malformed payload")
+)
+
+// Magic derives the first bytes of every frame from the network ID, so that
+// nodes of different networks part at the first frame.
+func Magic(networkID string) uint32 {
+	sum := sha256.Sum256([]byte(networkID))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+func checksum(payload []byte) []byte {
+	sum := sha256.Sum256(payload)
+	return sum[:checksumSize]
+}
+
+// WriteMessage writes msg as one frame.
+func WriteMessage(w io.Writer, magic uint32, msg Message) error {
+	e := &encoder{buf: make([]byte, headerSize, headerSize+64)}
+	msg.encode(e)
+	if e.err != nil {
+		return e.err
+	}
+	frame := e.buf
+	payload := frame[headerSize:]
+	if len(payload) > MaxPayloadSize {
+		return ErrPayloadTooLarge
+	}
+	binary.BigEndian.PutUint32(frame[0:4], magic)
+	copy(frame[4:4+commandSize], msg.Command())
+	binary.BigEndian.PutUint32(frame[16:20], uint32(len(payload)))
+	copy(frame[20:24], checksum(payload))
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadMessage reads one frame. A frame with an unknown command is consumed
+// and reported as ErrUnknownCommand, so that the caller may skip it.
+func ReadMessage(r io.Reader, magic uint32) (Message, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(header[0:4]) != magic {
+		return nil, ErrBadMagic
+	}
+	command := string(bytes.TrimRight(header[4:4+commandSize], "\x00"))
+	length := binary.BigEndian.Uint32(header[16:20])
+	if length > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if !bytes.Equal(header[20:24], checksum(payload)) {
+		return nil, ErrBadChecksum
+	}
+	msg := newMessage(command)
+	if msg == nil {
+		return nil, ErrUnknownCommand
+	}
+	d := &decoder{buf: payload}
+	msg.decode(d)
+	if d.err != nil || len(d.buf) > 0 {
+		return nil, ErrMalformed
+	}
+	return msg, nil
+}
+
+// encoder appends fields to a payload. The first error sticks.
+type encoder struct {
+	buf []byte
+	err error
+}
+
+func (e *encoder) uint8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, v)
+}
+
+func (e *encoder) int64(v int64) {
+	e.uint64(uint64(v))
+}
+
+func (e *encoder) length(n int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(n))
+}
+
+func (e *encoder) string(s string) {
+	e.length(len(s))
+	e.buf = append(e.buf, s...)
+}
+
+// hash writes a hex encoded SHA-256 hash as its 32 raw bytes.
+func (e *encoder) hash(s string) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != sha256.Size {
+		if e.err == nil {
+			e.err = ErrMalformed
+		}
+		b = make([]byte, sha256.Size)
+	}
+	e.buf = append(e.buf, b...)
+}
+
+// decoder consumes fields from a payload. The first error sticks and later
+// reads return zero values.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+// next consumes n bytes. Callers bound n, by a fixed size or by length.
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || n > len(d.buf) {
+		d.err = ErrMalformed
+		return make([]byte, n)
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint8() uint8 {
+	return d.next(1)[0]
+}
+
+func (d *decoder) uint32() uint32 {
+	return binary.BigEndian.Uint32(d.next(4))
+}
+
+func (d *decoder) uint64() uint64 {
+	return binary.BigEndian.Uint64(d.next(8))
+}
+
+func (d *decoder) int64() int64 {
+	return int64(d.uint64())
+}
+
+// length reads a count of items of at least minSize bytes each, rejecting
+// counts the rest of the payload cannot hold.
+func (d *decoder) length(minSize int) int {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(d.buf)
+	if size <= 0 || n > uint64(len(d.buf)-size)/uint64(minSize) {
+		d.err = ErrMalformed
+		return 0
+	}
+	d.buf = d.buf[size:]
+	return int(n)
+}
+
+func (d *decoder) string() string {
+	return string(d.next(d.length(1)))
+}
+
+func (d *decoder) hash() string {
+	return hex.EncodeToString(d.next(sha256.Size))
+}
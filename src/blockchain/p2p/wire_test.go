@@ -0,0 +1,89 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+const testMagic = 0x0b110907
+
+// frame builds a frame around payload without checking it.
+func frame(magic uint32, command string, payload []byte) []byte {
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header[0:4], magic)
+	copy(header[4:4+commandSize], command)
+	binary.BigEndian.PutUint32(header[16:20], uint32(len(payload)))
+	copy(header[20:24], checksum(payload))
+	return append(header, payload...)
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	sent := &Inv{Items: []InvVector{{Type: InvBlock, Hash: "00000000000000000000000000000000000000000000000000000000000000ff"}}}
+	if err := WriteMessage(&buf, testMagic, sent); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteMessage(&buf, testMagic, &Ping{Nonce: 42}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := ReadMessage(&buf, testMagic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv, ok := msg.(*Inv); !ok || len(inv.Items) != 1 || inv.Items[0] != sent.Items[0] {
+		t.Fatalf("read %#v, want %#v", msg, sent)
+	}
+	if msg, err := ReadMessage(&buf, testMagic); err != nil || msg.(*Ping).Nonce != 42 {
+		t.Fatalf("read %#v, %v, want ping 42", msg, err)
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	ping := frame(testMagic, CmdPing, make([]byte, 8))
+	corrupted := append([]byte(nil), ping...)
+	corrupted[len(corrupted)-1] ^= 1
+	tooLarge := frame(testMagic, CmdPing, nil)
+	binary.BigEndian.PutUint32(tooLarge[16:20], MaxPayloadSize+1)
+
+	for _, test := range []struct {
+		name  string
+		frame []byte
+		err   error
+	}{
+		{"bad magic", frame(testMagic+1, CmdPing, make([]byte, 8)), ErrBadMagic},
+		{"bad checksum", corrupted, ErrBadChecksum},
+		{"too large", tooLarge, ErrPayloadTooLarge},
+		{"short payload", frame(testMagic, CmdPing, make([]byte, 7)), ErrMalformed},
+		{"trailing bytes", frame(testMagic, CmdPing, make([]byte, 9)), ErrMalformed},
+		{"inventory count", frame(testMagic, CmdInv, []byte{0xff, 0x01}), ErrMalformed},
+		{"truncated", ping[:len(ping)-1], io.ErrUnexpectedEOF},
+		{"truncated header", ping[:headerSize-1], io.ErrUnexpectedEOF},
+	} {
+		if _, err := ReadMessage(bytes.NewReader(test.frame), testMagic); err != test.err {
+			t.Errorf("%s: error = %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestReadMessageSkipsUnknownCommand(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(frame(testMagic, "future", []byte("payload")))
+	if err := WriteMessage(&buf, testMagic, &Pong{Nonce: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadMessage(&buf, testMagic); err != ErrUnknownCommand {
+		t.Fatalf("error = %v, want %v", err, ErrUnknownCommand)
+	}
+	if msg, err := ReadMessage(&buf, testMagic); err != nil || msg.(*Pong).Nonce != 7 {
+		t.Fatalf("message after the unknown one = %#v, %v, want pong 7", msg, err)
+	}
+}
+
+func TestWriteMessageRejectsMalformedHash(t *testing.T) {
+	err := WriteMessage(io.Discard, testMagic, &GetData{Items: []InvVector{{Type: InvBlock, Hash: "not hex"}}})
+	if err != ErrMalformed {
+		t.Fatalf("error = %v, want %v", err, ErrMalformed)
+	}
+}
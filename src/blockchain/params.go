@@ -0,0 +1,65 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"blockchain/scrypt"
+)
+
+const (
+	PowSHA256 = "sha256"
+	PowScrypt = "scrypt"
+)
+
+type PowParams struct {
+	Algorithm  string `json:"algorithm"`
+	Difficulty int    `json:"difficulty"`
+	ScryptN    int    `json:"scrypt_n,omitempty"`
+	ScryptR    int    `json:"scrypt_r,omitempty"`
+	ScryptP    int    `json:"scrypt_p,omitempty"`
+}
+
+var DefaultPowParams = PowParams{
+	Algorithm:  PowSHA256,
+	Difficulty: BlockDifficulty,
+}
+
+var DefaultScryptParams = PowParams{
+	Algorithm:  PowScrypt,
+	Difficulty: 3,
+	ScryptN:    1024,
+	ScryptR:    1,
+	ScryptP:    1,
+}
+
+type powHash func(header []byte) [sha256.Size]byte
+
+// checkInterval is how many hashes a mining worker does between looks at
+// whether another worker found the nonce: a few for scrypt, whose hashes
+// take a millisecond, thousands for SHA-256.
+func (params PowParams) checkInterval() int {
+	if params.Algorithm == PowScrypt {
+		return 16
+	}
+	return 4096
+}
+
+func (params PowParams) hasher() (powHash, error) {
+	switch params.Algorithm {
+	case "", PowSHA256:
+		return sha256.Sum256, nil
+	case PowScrypt:
+		// The header is used as both password and salt, as in Litecoin.
+		if _, err := scrypt.Key(nil, nil, params.ScryptN, params.ScryptR, params.ScryptP, sha256.Size); err != nil {
+			return nil, err
+		}
+		return func(header []byte) [sha256.Size]byte {
+			var hash [sha256.Size]byte
+			key, _ := scrypt.Key(header, header, params.ScryptN, params.ScryptR, params.ScryptP, sha256.Size)
+			copy(hash[:], key)
+			return hash
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown proof-of-work algorithm: %s", params.Algorithm)
+}
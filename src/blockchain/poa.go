@@ -0,0 +1,147 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math/big"
+)
+
+var (
+	ErrNoValidators = errors.New("no validators configured")
+	ErrNotValidator = errors.New("node is not a validator")
+	ErrNotInTurn    = errors.New("validator is not in turn")
+	ErrInvalidKey   = errors.New("validator key is out of range")
+)
+
+type ProofOfAuthority struct {
+	validators []string
+	key        *ecdsa.PrivateKey
+	address    string
+}
+
+// NewProofOfAuthority creates an engine where validators seal blocks in
+// round-robin order by height. key may be nil for nodes that only verify.
+//
+// Only the validator in turn may seal a block, with no fallback to another
+// one after a timeout, so the chain stops at the height of a validator that
+// is offline. Every node must then be restarted with the validator removed
+// from the list to go on.
+func NewProofOfAuthority(validators []string, key *ecdsa.PrivateKey) (*ProofOfAuthority, error) {
+	if len(validators) == 0 {
+		return nil, ErrNoValidators
+	}
+	poa := &ProofOfAuthority{
+		validators: validators,
+		key:        key,
+	}
+	if key != nil {
+		poa.address = ValidatorAddress(&key.PublicKey)
+		if poa.validatorIndex(poa.address) < 0 {
+			return nil, ErrNotValidator
+		}
+	}
+	return poa, nil
+}
+
+func GenerateValidatorKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// ParseValidatorKey reads a hex encoded P-256 private scalar, which must be
+// in [1, N).
+func ParseValidatorKey(s string) (*ecdsa.PrivateKey, error) {
+	d, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	curve := elliptic.P256()
+	scalar := new(big.Int).SetBytes(d)
+	if scalar.Sign() == 0 || scalar.Cmp(curve.Params().N) >= 0 {
+		return nil, ErrInvalidKey
+	}
+	key := &ecdsa.PrivateKey{D: scalar}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(d)
+	return key, nil
+}
+
+func ValidatorAddress(key *ecdsa.PublicKey) string {
+	return hex.EncodeToString(elliptic.Marshal(key.Curve, key.X, key.Y))
+}
+
+func (poa *ProofOfAuthority) validatorIndex(address string) int {
+	for i, validator := range poa.validators {
+		if validator == address {
+			return i
+		}
+	}
+	return -1
+}
+
+func (poa *ProofOfAuthority) inTurn(height int) string {
+	return poa.validators[height%len(poa.validators)]
+}
+
+func (poa *ProofOfAuthority) Seal(block *Block) error {
+	if poa.key == nil {
+		return ErrNotValidator
+	}
+	if poa.inTurn(block.Height) != poa.address {
+		return ErrNotInTurn
+	}
+	hash, err := block.CalcHash()
+	if err != nil {
+		return err
+	}
+	block.Hash = hash
+	digest, _ := hex.DecodeString(hash)
+	r, s, err := ecdsa.Sign(rand.Reader, poa.key, digest)
+	if err != nil {
+		return err
+	}
+	signature := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(signature[32-len(rb):32], rb)
+	copy(signature[64-len(sb):], sb)
+	block.Signer = poa.address
+	block.Signature = hex.EncodeToString(signature)
+	return nil
+}
+
+func (poa *ProofOfAuthority) VerifySeal(block *Block) error {
+	if err := verifyHash(block); err != nil {
+		return err
+	}
+	if block.Signer != poa.inTurn(block.Height) {
+		return ErrNotInTurn
+	}
+	pub, err := hex.DecodeString(block.Signer)
+	if err != nil {
+		return ErrInvalidSeal
+	}
+	curve := elliptic.P256()
+	x, y := elliptic.Unmarshal(curve, pub)
+	if x == nil {
+		return ErrInvalidSeal
+	}
+	signature, err := hex.DecodeString(block.Signature)
+	if err != nil || len(signature) != 64 {
+		return ErrInvalidSeal
+	}
+	key := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	// verifyHash has checked that the hash is well formed.
+	digest, _ := hex.DecodeString(block.Hash)
+	if !ecdsa.Verify(key, digest, r, s) {
+		return ErrInvalidSeal
+	}
+	return nil
+}
+
+func (poa *ProofOfAuthority) ChooseFork(current []Block, candidate []Block) bool {
+	return longestChain(current, candidate)
+}
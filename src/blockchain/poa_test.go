@@ -0,0 +1,164 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// newValidators returns n validator keys and their addresses.
+func newValidators(t *testing.T, n int) ([]*ecdsa.PrivateKey, []string) {
+	var keys []*ecdsa.PrivateKey
+	var addresses []string
+	for i := 0; i < n; i++ {
+		key, err := GenerateValidatorKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+		addresses = append(addresses, ValidatorAddress(&key.PublicKey))
+	}
+	return keys, addresses
+}
+
+func newPoa(t *testing.T, validators []string, key *ecdsa.PrivateKey) *ProofOfAuthority {
+	engine, err := NewProofOfAuthority(validators, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+func newTestBlock(t *testing.T, height int) *Block {
+	block, err := NewBlock(height, GenesisTimestamp+int64(height), GenesisPreviousHash, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestPoaSealInTurn(t *testing.T) {
+	keys, validators := newValidators(t, 3)
+	verifier := newPoa(t, validators, nil)
+	for height := 1; height <= 3; height++ {
+		block := newTestBlock(t, height)
+		sealer := newPoa(t, validators, keys[height%3])
+		if err := sealer.Seal(block); err != nil {
+			t.Fatalf("height %d: %v", height, err)
+		}
+		if err := verifier.VerifySeal(block); err != nil {
+			t.Fatalf("height %d: VerifySeal error = %v", height, err)
+		}
+		// The others are not in turn.
+		other := newPoa(t, validators, keys[(height+1)%3])
+		if err := other.Seal(newTestBlock(t, height)); err != ErrNotInTurn {
+			t.Fatalf("height %d: Seal out of turn error = %v, want %v", height, err, ErrNotInTurn)
+		}
+	}
+}
+
+func TestPoaVerifySealRejectsWrongSigner(t *testing.T) {
+	keys, validators := newValidators(t, 3)
+	verifier := newPoa(t, validators, nil)
+	// A validator sealing at another's height, as a chain of its own
+	// would let it.
+	block := newTestBlock(t, 1)
+	if err := newPoa(t, validators[:1], keys[0]).Seal(block); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.VerifySeal(block); err != ErrNotInTurn {
+		t.Fatalf("VerifySeal error = %v, want %v", err, ErrNotInTurn)
+	}
+}
+
+func TestPoaVerifySealRejectsTampering(t *testing.T) {
+	keys, validators := newValidators(t, 2)
+	verifier := newPoa(t, validators, nil)
+	sealed := func() *Block {
+		block := newTestBlock(t, 1)
+		if err := newPoa(t, validators, keys[1]).Seal(block); err != nil {
+			t.Fatal(err)
+		}
+		return block
+	}
+
+	block := sealed()
+	signature, _ := hex.DecodeString(block.Signature)
+	signature[10] ^= 1
+	block.Signature = hex.EncodeToString(signature)
+	if err := verifier.VerifySeal(block); err != ErrInvalidSeal {
+		t.Errorf("tampered signature: error = %v, want %v", err, ErrInvalidSeal)
+	}
+
+	block = sealed()
+	block.Timestamp++
+	if err := verifier.VerifySeal(block); err != ErrInvalidHash {
+		t.Errorf("tampered header: error = %v, want %v", err, ErrInvalidHash)
+	}
+
+	block = sealed()
+	block.Timestamp++
+	block.Hash, _ = block.CalcHash()
+	if err := verifier.VerifySeal(block); err != ErrInvalidSeal {
+		t.Errorf("tampered header with its hash: error = %v, want %v", err, ErrInvalidSeal)
+	}
+}
+
+func TestPoaRejectsKeysOfOthers(t *testing.T) {
+	_, validators := newValidators(t, 2)
+	outsiders, outsider := newValidators(t, 1)
+	if _, err := NewProofOfAuthority(validators, outsiders[0]); err != ErrNotValidator {
+		t.Fatalf("NewProofOfAuthority with an outsider key: error = %v, want %v", err, ErrNotValidator)
+	}
+
+	// An outsider's own chain does not verify.
+	block := newTestBlock(t, 1)
+	if err := newPoa(t, outsider, outsiders[0]).Seal(block); err != nil {
+		t.Fatal(err)
+	}
+	verifier := newPoa(t, validators, nil)
+	if err := verifier.VerifySeal(block); err != ErrNotInTurn {
+		t.Fatalf("outsider seal: error = %v, want %v", err, ErrNotInTurn)
+	}
+	// Nor does its signature under the name of the validator in turn.
+	block.Signer = validators[1]
+	if err := verifier.VerifySeal(block); err != ErrInvalidSeal {
+		t.Fatalf("outsider signature for a validator: error = %v, want %v", err, ErrInvalidSeal)
+	}
+}
+
+func TestPoaChooseFork(t *testing.T) {
+	_, validators := newValidators(t, 1)
+	engine := newPoa(t, validators, nil)
+	current := make([]Block, 3)
+	for _, test := range []struct {
+		length int
+		want   bool
+	}{{2, false}, {3, false}, {4, true}} {
+		if got := engine.ChooseFork(current, make([]Block, test.length)); got != test.want {
+			t.Errorf("ChooseFork of %d blocks over 3 = %v, want %v", test.length, got, test.want)
+		}
+	}
+}
+
+func TestParseValidatorKey(t *testing.T) {
+	keys, _ := newValidators(t, 1)
+	key, err := ParseValidatorKey(hex.EncodeToString(keys[0].D.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.PublicKey.Equal(&keys[0].PublicKey) {
+		t.Fatal("parsed key has another public key")
+	}
+	n := elliptic.P256().Params().N
+	for _, d := range []*big.Int{big.NewInt(0), n, new(big.Int).Add(n, big.NewInt(1))} {
+		if _, err := ParseValidatorKey(hex.EncodeToString(d.Bytes())); err != ErrInvalidKey {
+			t.Errorf("ParseValidatorKey(%x) error = %v, want %v", d, err, ErrInvalidKey)
+		}
+	}
+	if _, err := ParseValidatorKey("zz"); err == nil {
+		t.Error("ParseValidatorKey accepted a non-hex key")
+	}
+}
@@ -0,0 +1,68 @@
+package blockchain
+
+import "time"
+
+const BlockDifficulty = 5
+
+type ProofOfWork struct {
+	params PowParams
+	hash   powHash
+	miner  *Miner
+}
+
+func NewProofOfWork(params PowParams) (*ProofOfWork, error) {
+	hash, err := params.hasher()
+	if err != nil {
+		return nil, err
+	}
+	miner := NewMiner(params.Difficulty, hash)
+	miner.SetCheckInterval(params.checkInterval())
+	return &ProofOfWork{
+		params: params,
+		hash:   hash,
+		miner:  miner,
+	}, nil
+}
+
+func (pow *ProofOfWork) Params() PowParams {
+	return pow.params
+}
+
+func (pow *ProofOfWork) Miner() *Miner {
+	return pow.miner
+}
+
+func (pow *ProofOfWork) Seal(block *Block) error {
+	start, hashes := time.Now(), pow.miner.Hashes()
+	timestamp, nonce, err := pow.miner.Mine(block.Timestamp, block.PreviousHash, block.MerkleHash)
+	if err != nil {
+		return err
+	}
+	block.Timestamp, block.Nonce = timestamp, nonce
+	if block.Hash, err = block.CalcHash(); err != nil {
+		return err
+	}
+	if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+		hashRate.Set(float64(pow.miner.Hashes()-hashes) / elapsed)
+	}
+	return nil
+}
+
+func (pow *ProofOfWork) VerifySeal(block *Block) error {
+	if err := verifyHash(block); err != nil {
+		return err
+	}
+	header, err := block.header()
+	if err != nil {
+		return err
+	}
+	hash := pow.hash(header)
+	if !meetsDifficulty(hash[:], pow.params.Difficulty) {
+		return ErrInvalidSeal
+	}
+	return nil
+}
+
+func (pow *ProofOfWork) ChooseFork(current []Block, candidate []Block) bool {
+	return longestChain(current, candidate)
+}
@@ -0,0 +1,54 @@
+package blockchain
+
+// SetPruneDepth makes the chain drop the transactions of blocks more than
+// depth blocks below the tip each time a snapshot is saved; the snapshot is
+// taken at that depth and holds the state they produced. Zero keeps all
+// transactions. Reorgs deeper than depth are refused once blocks are pruned.
+func (blockChain *BlockChain) SetPruneDepth(depth int) {
+	blockChain.mu.Lock()
+	defer blockChain.mu.Unlock()
+	blockChain.pruneDepth = depth
+}
+
+// PrunedHeight returns the height of the last block without transactions,
+// or -1 when no block is pruned.
+func (blockChain *BlockChain) PrunedHeight() int {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	return blockChain.prunedHeight
+}
+
+// prune removes the transactions covered by snapshot and older than the
+// prune depth. It is called with the lock held.
+func (blockChain *BlockChain) prune(snapshot *Snapshot) error {
+	if blockChain.pruneDepth <= 0 {
+		return nil
+	}
+	height := len(blockChain.Chain) - 1 - blockChain.pruneDepth
+	if snapshot.Height < height {
+		height = snapshot.Height
+	}
+	if height <= blockChain.prunedHeight {
+		return nil
+	}
+	if err := blockChain.store.Prune(height); err != nil {
+		return err
+	}
+	for i := blockChain.prunedHeight + 1; i <= height; i++ {
+		blockChain.Chain[i] = blockChain.Chain[i].Header()
+	}
+	blockChain.logger.Info("blocks pruned", "from", blockChain.prunedHeight+1, "to", height)
+	blockChain.prunedHeight = height
+	return nil
+}
+
+// Headers returns up to limit block headers starting at height from.
+func (blockChain *BlockChain) Headers(from int, limit int) []Block {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	var headers []Block
+	for height := from; height >= 0 && height < len(blockChain.Chain) && len(headers) < limit; height++ {
+		headers = append(headers, blockChain.Chain[height].Header())
+	}
+	return headers
+}
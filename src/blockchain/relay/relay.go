@@ -0,0 +1,186 @@
+// Package relay pushes new blocks and transactions to peers. Blocks are
+// announced as compact blocks, which peers rebuild from the transactions
+// already relayed into their pools.
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"blockchain"
+	"blockchain/trace"
+)
+
+// Statuses a peer answers a compact block announcement with.
+const (
+	StatusAccepted = "accepted"
+	StatusKnown    = "known"
+	StatusMissing  = "missing"
+	StatusOrphan   = "orphan"
+)
+
+const (
+	queueSize = 1024
+	workers   = 4
+)
+
+// Result is a peer's answer to a compact block. With StatusMissing,
+// Missing lists the transactions to send again as prefilled.
+type Result struct {
+	Hash    string `json:"hash"`
+	Status  string `json:"status"`
+	Missing []int  `json:"missing,omitempty"`
+}
+
+// Relay listens to the chain and sends each new tip and pooled
+// transaction to every peer.
+type Relay struct {
+	blockChain *blockchain.BlockChain
+	queue      chan blockchain.Event
+	client     *http.Client
+	apiKey     string
+}
+
+func NewRelay(blockChain *blockchain.BlockChain) *Relay {
+	relay := &Relay{
+		blockChain: blockChain,
+		queue:      make(chan blockchain.Event, queueSize),
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+	for i := 0; i < workers; i++ {
+		go relay.relayLoop()
+	}
+	blockChain.Listen(relay.handleEvent)
+	return relay
+}
+
+// SetAPIKey sends key in the X-Api-Key header to peers, which require the
+// peer role for relayed blocks and transactions.
+func (relay *Relay) SetAPIKey(key string) {
+	relay.apiKey = key
+}
+
+// SetTransport sets the transport of the requests to peers, such as one
+// signing them with the node identity.
+func (relay *Relay) SetTransport(transport http.RoundTripper) {
+	relay.client.Transport = transport
+}
+
+// handleEvent runs with the chain locked, so it only queues the event.
+func (relay *Relay) handleEvent(event blockchain.Event) {
+	if event.Type != blockchain.EventBlock && event.Type != blockchain.EventTransaction {
+		return
+	}
+	select {
+	case relay.queue <- event:
+	default:
+		slog.Warn("relay queue full, dropping event", "type", event.Type)
+	}
+}
+
+func (relay *Relay) relayLoop() {
+	for event := range relay.queue {
+		// Blocks of a replaced chain arrive in a burst; peers only need
+		// the new tip and fetch the rest when they resolve.
+		if event.Type == blockchain.EventBlock && event.Block.Hash != relay.blockChain.TipHash() {
+			continue
+		}
+		var wg sync.WaitGroup
+		for _, peer := range relay.blockChain.Peers() {
+			wg.Add(1)
+			go func(peer string) {
+				defer wg.Done()
+				relay.send(peer, &event)
+			}(peer)
+		}
+		wg.Wait()
+	}
+}
+
+func (relay *Relay) send(peer string, event *blockchain.Event) {
+	if event.Type == blockchain.EventTransaction {
+		if err := relay.post(context.Background(), peer, "/transactions/relay", event.Transaction, nil); err != nil {
+			slog.Debug("relaying transaction failed", "peer", peer, "txid", event.Transaction.Hash(), "error", err)
+		}
+		return
+	}
+	result, err := relay.AnnounceBlock(context.Background(), peer, event.Block)
+	if err != nil {
+		slog.Debug("announcing block failed", "peer", peer, "hash", event.Block.Hash, "error", err)
+		return
+	}
+	slog.Debug("block announced", "peer", peer, "hash", event.Block.Hash, "status", result.Status)
+}
+
+// AnnounceBlock sends block to peer as a compact block, followed by the
+// transactions the peer could not find in its pool.
+func (relay *Relay) AnnounceBlock(ctx context.Context, peer string, block *blockchain.Block) (result *Result, err error) {
+	ctx, span := trace.Start(ctx, "relay.AnnounceBlock", trace.SpanKindInternal,
+		trace.String("peer", peer), trace.String("hash", block.Hash))
+	defer func() {
+		span.RecordError(err)
+		if result != nil {
+			span.SetAttributes(trace.String("status", result.Status), trace.Int("missing", len(result.Missing)))
+		}
+		span.End()
+	}()
+	compact := blockchain.NewCompactBlock(block)
+	result = &Result{}
+	if err := relay.post(ctx, peer, "/blocks/compact", compact, result); err != nil {
+		return nil, err
+	}
+	if result.Status != StatusMissing {
+		return result, nil
+	}
+	if err := compact.Prefill(block, result.Missing); err != nil {
+		return nil, err
+	}
+	result = &Result{}
+	if err := relay.post(ctx, peer, "/blocks/compact", compact, result); err != nil {
+		return nil, err
+	}
+	if result.Status == StatusMissing {
+		return nil, fmt.Errorf("peer still misses %d transactions", len(result.Missing))
+	}
+	return result, nil
+}
+
+func (relay *Relay) post(ctx context.Context, peer string, path string, body interface{}, v interface{}) (err error) {
+	ctx, span := trace.Start(ctx, "POST "+path, trace.SpanKindClient, trace.String("peer", peer))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", peer+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if relay.apiKey != "" {
+		req.Header.Set("X-Api-Key", relay.apiKey)
+	}
+	trace.Inject(ctx, req.Header)
+	res, err := relay.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("http status code: %d", res.StatusCode)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,142 @@
+// Package scrypt implements the scrypt key derivation function as defined in
+// RFC 7914. It is used as a memory-hard proof-of-work hash.
+package scrypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+)
+
+var ErrInvalidParams = errors.New("scrypt: invalid parameters")
+
+func pbkdf2(password []byte, salt []byte, iter int, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+	var buf [4]byte
+	dk := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+		for n := 2; n <= iter; n++ {
+			u = sum(prf, u)
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
+
+func sum(prf hash.Hash, data []byte) []byte {
+	prf.Reset()
+	prf.Write(data)
+	return prf.Sum(data[:0])
+}
+
+func salsa208(b *[16]uint32) {
+	x := *b
+	for i := 0; i < 8; i += 2 {
+		x[4] ^= rotl(x[0]+x[12], 7)
+		x[8] ^= rotl(x[4]+x[0], 9)
+		x[12] ^= rotl(x[8]+x[4], 13)
+		x[0] ^= rotl(x[12]+x[8], 18)
+		x[9] ^= rotl(x[5]+x[1], 7)
+		x[13] ^= rotl(x[9]+x[5], 9)
+		x[1] ^= rotl(x[13]+x[9], 13)
+		x[5] ^= rotl(x[1]+x[13], 18)
+		x[14] ^= rotl(x[10]+x[6], 7)
+		x[2] ^= rotl(x[14]+x[10], 9)
+		x[6] ^= rotl(x[2]+x[14], 13)
+		x[10] ^= rotl(x[6]+x[2], 18)
+		x[3] ^= rotl(x[15]+x[11], 7)
+		x[7] ^= rotl(x[3]+x[15], 9)
+		x[11] ^= rotl(x[7]+x[3], 13)
+		x[15] ^= rotl(x[11]+x[7], 18)
+		x[1] ^= rotl(x[0]+x[3], 7)
+		x[2] ^= rotl(x[1]+x[0], 9)
+		x[3] ^= rotl(x[2]+x[1], 13)
+		x[0] ^= rotl(x[3]+x[2], 18)
+		x[6] ^= rotl(x[5]+x[4], 7)
+		x[7] ^= rotl(x[6]+x[5], 9)
+		x[4] ^= rotl(x[7]+x[6], 13)
+		x[5] ^= rotl(x[4]+x[7], 18)
+		x[11] ^= rotl(x[10]+x[9], 7)
+		x[8] ^= rotl(x[11]+x[10], 9)
+		x[9] ^= rotl(x[8]+x[11], 13)
+		x[10] ^= rotl(x[9]+x[8], 18)
+		x[12] ^= rotl(x[15]+x[14], 7)
+		x[13] ^= rotl(x[12]+x[15], 9)
+		x[14] ^= rotl(x[13]+x[12], 13)
+		x[15] ^= rotl(x[14]+x[13], 18)
+	}
+	for i := range b {
+		b[i] += x[i]
+	}
+}
+
+func rotl(x uint32, n uint) uint32 {
+	return x<<n | x>>(32-n)
+}
+
+// blockMix operates on 2*r 64-byte blocks stored as words in b, using y as
+// scratch space.
+func blockMix(b []uint32, y []uint32, r int) {
+	var x [16]uint32
+	copy(x[:], b[(2*r-1)*16:])
+	for i := 0; i < 2*r; i++ {
+		for j := range x {
+			x[j] ^= b[i*16+j]
+		}
+		salsa208(&x)
+		// Even blocks go to the first half of the output, odd to the second.
+		offset := (i/2)*16 + (i%2)*r*16
+		copy(y[offset:], x[:])
+	}
+	copy(b, y[:32*r])
+}
+
+func roMix(b []byte, r int, n int, v []uint32, x []uint32, y []uint32) {
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+	for i := 0; i < n; i++ {
+		copy(v[i*32*r:], x)
+		blockMix(x, y, r)
+	}
+	for i := 0; i < n; i++ {
+		j := int(x[(2*r-1)*16] & uint32(n-1))
+		for k := range x {
+			x[k] ^= v[j*32*r+k]
+		}
+		blockMix(x, y, r)
+	}
+	for i, w := range x {
+		binary.LittleEndian.PutUint32(b[i*4:], w)
+	}
+}
+
+// Key derives a key of keyLen bytes. N must be a power of two greater than 1.
+func Key(password []byte, salt []byte, N int, r int, p int, keyLen int) ([]byte, error) {
+	if N <= 1 || N&(N-1) != 0 || r <= 0 || p <= 0 || uint64(r)*uint64(p) >= 1<<30 || keyLen <= 0 {
+		return nil, ErrInvalidParams
+	}
+
+	b := pbkdf2(password, salt, 1, p*128*r)
+	v := make([]uint32, 32*r*N)
+	x := make([]uint32, 32*r)
+	y := make([]uint32, 32*r)
+	for i := 0; i < p; i++ {
+		roMix(b[i*128*r:], r, N, v, x, y)
+	}
+	return pbkdf2(password, b, 1, keyLen), nil
+}
@@ -0,0 +1,90 @@
+package scrypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func unhex(t testing.TB, s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestPBKDF2 checks the PBKDF2-HMAC-SHA256 vector of RFC 7914 section 11.
+func TestPBKDF2(t *testing.T) {
+	want := unhex(t, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"+
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783")
+	if got := pbkdf2([]byte("passwd"), []byte("salt"), 1, 64); !bytes.Equal(got, want) {
+		t.Fatalf("pbkdf2 = %x, want %x", got, want)
+	}
+}
+
+// TestKey checks the scrypt vectors of RFC 7914 section 12.
+func TestKey(t *testing.T) {
+	vectors := []struct {
+		password string
+		salt     string
+		N, r, p  int
+		want     string
+		slow     bool
+	}{
+		{"", "", 16, 1, 1,
+			"77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442" +
+				"fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906", false},
+		{"password", "NaCl", 1024, 8, 16,
+			"fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b373162" +
+				"2eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640", false},
+		{"pleaseletmein", "SodiumChloride", 16384, 8, 1,
+			"7023bdcb3afd7348461c06cd81fd38ebfda8fbba904f8e3ea9b543f6545da1f2" +
+				"d5432955613f0fcf62d49705242a9af9e61e85dc0d651e40dfcf017b45575887", true},
+	}
+	for _, v := range vectors {
+		if v.slow && testing.Short() {
+			continue
+		}
+		got, err := Key([]byte(v.password), []byte(v.salt), v.N, v.r, v.p, 64)
+		if err != nil {
+			t.Fatalf("Key(%q, %q): %v", v.password, v.salt, err)
+		}
+		if want := unhex(t, v.want); !bytes.Equal(got, want) {
+			t.Errorf("Key(%q, %q, N=%d, r=%d, p=%d) = %x, want %x", v.password, v.salt, v.N, v.r, v.p, got, want)
+		}
+	}
+}
+
+func TestKeyInvalidParams(t *testing.T) {
+	params := []struct{ N, r, p, keyLen int }{
+		{0, 1, 1, 32},
+		{1, 1, 1, 32},
+		{15, 1, 1, 32},
+		{16, 0, 1, 32},
+		{16, 1, 0, 32},
+		{16, 1 << 15, 1 << 15, 32},
+		{16, 1, 1, 0},
+	}
+	for _, p := range params {
+		if _, err := Key(nil, nil, p.N, p.r, p.p, p.keyLen); err != ErrInvalidParams {
+			t.Errorf("Key(N=%d, r=%d, p=%d, keyLen=%d) error = %v, want %v", p.N, p.r, p.p, p.keyLen, err, ErrInvalidParams)
+		}
+	}
+}
+
+func benchmarkKey(b *testing.B, N int, r int, p int) {
+	header := make([]byte, 80)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		header[0] = byte(i)
+		if _, err := Key(header, header, N, r, p, 32); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkKeyPow uses the parameters of the default scrypt chain.
+func BenchmarkKeyPow(b *testing.B)    { benchmarkKey(b, 1024, 1, 1) }
+func BenchmarkKeyN16384(b *testing.B) { benchmarkKey(b, 16384, 8, 1) }
@@ -0,0 +1,234 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrSnapshotHash       = errors.New("snapshot state hash mismatch")
+	ErrSnapshotHeaders    = errors.New("snapshot headers do not match its height")
+	ErrSnapshotBlock      = errors.New("snapshot does not match the chain at its height")
+	ErrSnapshotUntrusted  = errors.New("snapshot state hash is not the trusted hash")
+	ErrNoTrustedHash      = errors.New("bootstrapping requires the trusted state hash of the snapshot")
+	ErrStoreNotEmpty      = errors.New("cannot bootstrap into a store that has blocks")
+	ErrPrunedBlock        = errors.New("block transactions are pruned")
+	ErrIndexUnrecoverable = errors.New("pruned chain has no usable snapshot to rebuild its index from")
+)
+
+// Snapshot is the derived state of the chain at a height: balances and the
+// block, transaction and address indexes, together with the headers needed
+// to link it to genesis. StateHash commits to the state.
+type Snapshot struct {
+	Height    int     `json:"height"`
+	BlockHash string  `json:"block_hash"`
+	StateHash string  `json:"state_hash"`
+	Index     *Index  `json:"index"`
+	Headers   []Block `json:"headers"`
+}
+
+// stateHash hashes the height, block hash and canonical JSON of the index;
+// encoding/json writes map keys in sorted order.
+func stateHash(height int, blockHash string, index *Index) (string, error) {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%s\n", height, blockHash)
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify checks that the snapshot's headers form a sealed chain from the
+// genesis and that StateHash matches the state. A snapshot
+// with an empty trustedHash is only as trustworthy as its headers' seals.
+func (snapshot *Snapshot) Verify(engine ConsensusEngine, genesisHash string, trustedHash string) error {
+	headers := snapshot.Headers
+	if snapshot.Height < 0 || snapshot.Index == nil || len(headers) != snapshot.Height+1 ||
+		headers[0].Hash != genesisHash || headers[0].Height != 0 ||
+		headers[snapshot.Height].Hash != snapshot.BlockHash ||
+		snapshot.Index.Tip() != snapshot.BlockHash {
+		return ErrSnapshotHeaders
+	}
+	for i := 1; i < len(headers); i++ {
+		if err := validateBlock(engine, &headers[i-1], &headers[i]); err != nil {
+			return &ImportError{Height: i, Err: err}
+		}
+	}
+	hash, err := stateHash(snapshot.Height, snapshot.BlockHash, snapshot.Index)
+	if err != nil {
+		return err
+	}
+	if hash != snapshot.StateHash {
+		return ErrSnapshotHash
+	}
+	if trustedHash != "" && trustedHash != snapshot.StateHash {
+		return ErrSnapshotUntrusted
+	}
+	return nil
+}
+
+// Snapshot captures the state at the tip.
+func (blockChain *BlockChain) Snapshot() (*Snapshot, error) {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	return blockChain.snapshot()
+}
+
+func (blockChain *BlockChain) snapshot() (*Snapshot, error) {
+	return blockChain.snapshotAt(blockChain.lastBlock().Height)
+}
+
+// snapshotAt captures the state at height by undoing the blocks above it,
+// which must not be pruned.
+func (blockChain *BlockChain) snapshotAt(height int) (*Snapshot, error) {
+	index := blockChain.index.clone()
+	for i := len(blockChain.Chain) - 1; i > height; i-- {
+		index.removeBlock(&blockChain.Chain[i])
+	}
+	snapshot := &Snapshot{
+		Height:    height,
+		BlockHash: blockChain.Chain[height].Hash,
+		Index:     index,
+		Headers:   make([]Block, height+1),
+	}
+	for i := range snapshot.Headers {
+		snapshot.Headers[i] = blockChain.Chain[i].Header()
+	}
+	hash, err := stateHash(snapshot.Height, snapshot.BlockHash, snapshot.Index)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.StateHash = hash
+	return snapshot, nil
+}
+
+// LatestSnapshot returns the last snapshot saved to the store, or nil.
+func (blockChain *BlockChain) LatestSnapshot() (*Snapshot, error) {
+	return blockChain.store.LoadSnapshot()
+}
+
+// SaveSnapshot captures the state and saves it to the store. The state is
+// the tip's, or with a prune depth the state of the block that deep, which
+// is then pruned up to.
+func (blockChain *BlockChain) SaveSnapshot() (*Snapshot, error) {
+	blockChain.mu.Lock()
+	defer blockChain.mu.Unlock()
+	return blockChain.saveSnapshot()
+}
+
+func (blockChain *BlockChain) saveSnapshot() (*Snapshot, error) {
+	height := blockChain.lastBlock().Height
+	if blockChain.pruneDepth > 0 {
+		// Pruned blocks are rebuilt from the snapshot, so it must stay on
+		// the chain: reorgs are refused below the pruned height, while one
+		// above could orphan a snapshot taken at the tip.
+		height = max(height-blockChain.pruneDepth, blockChain.prunedHeight, 0)
+	}
+	snapshot, err := blockChain.snapshotAt(height)
+	if err != nil {
+		return nil, err
+	}
+	if err := blockChain.store.SaveSnapshot(snapshot); err != nil {
+		return nil, err
+	}
+	blockChain.snapshotHeight = snapshot.Height
+	blockChain.logger.Info("snapshot saved", "height", snapshot.Height, "state_hash", snapshot.StateHash)
+	if err := blockChain.prune(snapshot); err != nil {
+		blockChain.logger.Error("pruning failed", "error", err)
+	}
+	return snapshot, nil
+}
+
+// SetSnapshotInterval makes the chain save a snapshot whenever its height
+// reaches a multiple of interval. Zero disables snapshots.
+func (blockChain *BlockChain) SetSnapshotInterval(interval int) {
+	blockChain.mu.Lock()
+	defer blockChain.mu.Unlock()
+	blockChain.snapshotInterval = interval
+}
+
+// maybeSnapshot is called with the lock held after the tip changed.
+func (blockChain *BlockChain) maybeSnapshot() {
+	interval := blockChain.snapshotInterval
+	if interval <= 0 || blockChain.lastBlock().Height%interval != 0 {
+		return
+	}
+	if _, err := blockChain.saveSnapshot(); err != nil {
+		blockChain.logger.Error("saving snapshot failed", "error", err)
+	}
+}
+
+// VerifySnapshot checks a snapshot against this chain by rebuilding the
+// state at the snapshot's height from the stored blocks, and checks that the
+// snapshot's own state has the same hash.
+func (blockChain *BlockChain) VerifySnapshot(snapshot *Snapshot) error {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	if snapshot.Index == nil {
+		return ErrSnapshotHash
+	}
+	own, err := stateHash(snapshot.Height, snapshot.BlockHash, snapshot.Index)
+	if err != nil {
+		return err
+	}
+	if own != snapshot.StateHash {
+		return ErrSnapshotHash
+	}
+	if snapshot.Height < 0 || snapshot.Height >= len(blockChain.Chain) ||
+		blockChain.Chain[snapshot.Height].Hash != snapshot.BlockHash {
+		return ErrSnapshotBlock
+	}
+	if snapshot.Height <= blockChain.prunedHeight {
+		return ErrPrunedBlock
+	}
+	index, err := blockChain.buildIndex(blockChain.Chain[:snapshot.Height+1])
+	if err != nil {
+		return err
+	}
+	hash, err := stateHash(snapshot.Height, snapshot.BlockHash, index)
+	if err != nil {
+		return err
+	}
+	if hash != snapshot.StateHash {
+		return ErrSnapshotHash
+	}
+	return nil
+}
+
+// Bootstrap creates a chain in an empty store from a verified snapshot. The
+// snapshot's headers are stored as pruned blocks; later blocks are synced
+// from peers or imported as usual. Headers do not commit to the state, so
+// their seals say nothing about it and trustedHash is required.
+func Bootstrap(engine ConsensusEngine, store Store, snapshot *Snapshot, trustedHash string) (*BlockChain, error) {
+	if trustedHash == "" {
+		return nil, ErrNoTrustedHash
+	}
+	chain, err := store.LoadChain()
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) > 0 {
+		return nil, ErrStoreNotEmpty
+	}
+	if err := snapshot.Verify(engine, GenesisBlock().Hash, trustedHash); err != nil {
+		return nil, err
+	}
+	for i := range snapshot.Headers {
+		header := snapshot.Headers[i].Header()
+		if err := store.AppendBlock(&header); err != nil {
+			return nil, err
+		}
+	}
+	if err := store.SaveIndex(snapshot.Index); err != nil {
+		return nil, err
+	}
+	if err := store.SaveSnapshot(snapshot); err != nil {
+		return nil, err
+	}
+	return NewBlockChain(engine, store)
+}
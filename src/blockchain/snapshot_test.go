@@ -0,0 +1,96 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBootstrapRequiresTrustedHash(t *testing.T) {
+	chain := newTestChain(t)
+	mineBlocks(t, chain, GenesisTimestamp+1, GenesisTimestamp+2)
+	snapshot, err := chain.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Bootstrap(chain.engine, NewMemoryStore(), snapshot, ""); err != ErrNoTrustedHash {
+		t.Fatalf("Bootstrap without a trusted hash: error = %v, want %v", err, ErrNoTrustedHash)
+	}
+	if _, err := Bootstrap(chain.engine, NewMemoryStore(), snapshot, "other"); err != ErrSnapshotUntrusted {
+		t.Fatalf("Bootstrap with another hash: error = %v, want %v", err, ErrSnapshotUntrusted)
+	}
+	bootstrapped, err := Bootstrap(chain.engine, NewMemoryStore(), snapshot, snapshot.StateHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bootstrapped.TipHash() != chain.TipHash() {
+		t.Fatalf("bootstrapped tip = %s, want %s", bootstrapped.TipHash(), chain.TipHash())
+	}
+}
+
+// forkChain returns a chain starting with blocks and continuing with blocks
+// mined at timestamps.
+func forkChain(t *testing.T, blocks []Block, timestamps ...int64) []Block {
+	peer := newTestChain(t)
+	if err := peer.replaceChain(blocks); err != nil {
+		t.Fatal(err)
+	}
+	peer.AddTransaction(context.Background(), &Transaction{Sender: "carol", Recipient: "dave", Amount: 3})
+	mineBlocks(t, peer, timestamps...)
+	return peer.Blocks()
+}
+
+func TestPruneSnapshotSurvivesReorg(t *testing.T) {
+	chain := newTestChain(t)
+	chain.SetPruneDepth(2)
+	for i := int64(1); i <= 5; i++ {
+		chain.AddTransaction(context.Background(), &Transaction{Sender: "alice", Recipient: "bob", Amount: i})
+		mineBlocks(t, chain, GenesisTimestamp+i)
+	}
+	blocks := chain.Blocks()
+	snapshot, err := chain.SaveSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Height != 3 || chain.PrunedHeight() != 3 {
+		t.Fatalf("snapshot at %d, pruned to %d, want both at the prune height 3", snapshot.Height, chain.PrunedHeight())
+	}
+	if want, _ := stateHash(3, blocks[3].Hash, BuildIndex(blocks[:4])); snapshot.StateHash != want {
+		t.Fatalf("snapshot state hash = %s, want %s", snapshot.StateHash, want)
+	}
+
+	// Replace the blocks above the snapshot.
+	if err := chain.replaceChain(forkChain(t, blocks[:4], GenesisTimestamp+10, GenesisTimestamp+11, GenesisTimestamp+12)); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.Reindex(); err != nil {
+		t.Fatalf("Reindex after the reorg: %v", err)
+	}
+	if got := chain.index.balances["dave"]; got != 3 {
+		t.Fatalf("balance of dave = %d, want 3", got)
+	}
+	if _, err := NewBlockChain(chain.engine, chain.store); err != nil {
+		t.Fatalf("reopening the chain: %v", err)
+	}
+}
+
+func TestSnapshotRetakenAfterReorg(t *testing.T) {
+	chain := newTestChain(t)
+	chain.AddTransaction(context.Background(), &Transaction{Sender: "alice", Recipient: "bob", Amount: 5})
+	mineBlocks(t, chain, GenesisTimestamp+1, GenesisTimestamp+2, GenesisTimestamp+3)
+	if _, err := chain.SaveSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.replaceChain(forkChain(t, chain.Blocks()[:2], GenesisTimestamp+10, GenesisTimestamp+11, GenesisTimestamp+12)); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := chain.LatestSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.BlockHash != chain.TipHash() {
+		t.Fatalf("snapshot at %d %s, want the new tip %s", snapshot.Height, snapshot.BlockHash, chain.TipHash())
+	}
+	if err := chain.VerifySnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+}
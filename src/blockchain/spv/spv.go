@@ -0,0 +1,332 @@
+// Package spv implements a light client that keeps only block headers and
+// verifies payments to watched addresses with merkle proofs from full nodes.
+package spv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"blockchain"
+)
+
+const headersBatch = 500
+
+// maxResponseSize bounds the answers of peers.
+const maxResponseSize = 32 << 20
+
+var (
+	ErrNoPeers       = errors.New("spv: no peer could be synced from")
+	ErrProofMismatch = errors.New("spv: merkle proof does not match the header")
+	ErrUnknownBlock  = errors.New("spv: proof refers to a block not in the header chain")
+)
+
+// Payment is a transaction proven to be in the header chain.
+type Payment struct {
+	TxID          string                 `json:"txid"`
+	Transaction   blockchain.Transaction `json:"transaction"`
+	BlockHash     string                 `json:"block_hash"`
+	BlockHeight   int                    `json:"block_height"`
+	Confirmations int                    `json:"confirmations"`
+}
+
+type Client struct {
+	engine  blockchain.ConsensusEngine
+	peers   []string
+	http    *http.Client
+	logger  *slog.Logger
+	mu      sync.RWMutex
+	headers []blockchain.Block
+	watched map[string]bool
+	// payments are keyed by txid.
+	payments map[string]*Payment
+}
+
+// NewClient creates a client that verifies headers with engine and syncs
+// from the full nodes at peers.
+func NewClient(engine blockchain.ConsensusEngine, peers []string) *Client {
+	return &Client{
+		engine:   engine,
+		peers:    peers,
+		http:     &http.Client{Timeout: 30 * time.Second},
+		logger:   slog.Default(),
+		headers:  []blockchain.Block{blockchain.GenesisBlock().Header()},
+		watched:  make(map[string]bool),
+		payments: make(map[string]*Payment),
+	}
+}
+
+func (client *Client) SetLogger(logger *slog.Logger) {
+	client.logger = logger
+}
+
+func (client *Client) Watch(address string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.watched[address] = true
+}
+
+func (client *Client) Height() int {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	return len(client.headers) - 1
+}
+
+func (client *Client) Tip() blockchain.Block {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	return client.headers[len(client.headers)-1]
+}
+
+// Sync brings the header chain up to date and verifies the transactions of
+// watched addresses.
+func (client *Client) Sync(ctx context.Context) error {
+	if err := client.SyncHeaders(ctx); err != nil {
+		return err
+	}
+	return client.SyncPayments(ctx)
+}
+
+// SyncHeaders downloads headers from every peer and adopts the best valid
+// header chain according to the consensus engine.
+func (client *Client) SyncHeaders(ctx context.Context) error {
+	synced := false
+	for _, peer := range client.peers {
+		if err := client.syncHeadersFrom(ctx, peer); err != nil {
+			client.logger.Warn("header sync failed", "peer", peer, "error", err)
+			continue
+		}
+		synced = true
+	}
+	if !synced {
+		return ErrNoPeers
+	}
+	return nil
+}
+
+func (client *Client) syncHeadersFrom(ctx context.Context, peer string) error {
+	// The peer finds the last header both chains share from the locator,
+	// however deep the fork, and the rest follows by height. Headers are
+	// only ever replaced as a whole, so current stays valid unlocked.
+	client.mu.RLock()
+	current := client.headers
+	client.mu.RUnlock()
+	query := url.Values{"locator": {strings.Join(blockchain.BuildLocator(current), ",")}}
+
+	// chain is current up to the fork point followed by the headers of the
+	// peer, each validated as its batch arrives so that a peer sending an
+	// invalid header is dropped without downloading the rest.
+	var chain []blockchain.Block
+	fork := 0
+	for first := true; ; first = false {
+		var headers []blockchain.Block
+		query.Set("limit", fmt.Sprint(headersBatch))
+		if err := client.get(ctx, peer+"/headers?"+query.Encode(), &headers); err != nil {
+			return err
+		}
+		if first {
+			if len(headers) == 0 || headers[0].Height < 1 || headers[0].Height > len(current) {
+				return nil
+			}
+			fork = headers[0].Height
+		}
+		i := 0
+		if chain == nil {
+			// Skip the headers both chains share.
+			for i < len(headers) && fork < len(current) && headers[i].Hash == current[fork].Hash {
+				i++
+				fork++
+			}
+			if i < len(headers) {
+				chain = append([]blockchain.Block(nil), current[:fork]...)
+			}
+		}
+		for ; i < len(headers); i++ {
+			height := len(chain)
+			if headers[i].Height != height {
+				return fmt.Errorf("spv: header %d out of order", height)
+			}
+			if err := blockchain.ValidateHeader(client.engine, &chain[height-1], &headers[i]); err != nil {
+				return fmt.Errorf("spv: header %d: %v", height, err)
+			}
+			chain = append(chain, headers[i].Header())
+		}
+		if len(headers) < headersBatch {
+			break
+		}
+		query = url.Values{"from": {fmt.Sprint(headers[len(headers)-1].Height + 1)}}
+	}
+	if chain == nil {
+		return nil
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if len(client.headers) != len(current) || client.headers[len(current)-1].Hash != current[len(current)-1].Hash {
+		// Another sync moved the chain meanwhile; the next one catches up.
+		return nil
+	}
+	if !client.engine.ChooseFork(client.headers, chain) {
+		return nil
+	}
+	if fork < len(client.headers) {
+		client.logger.Info("header chain reorganized", "fork_height", fork, "height", len(chain)-1)
+		// Payments in blocks that left the chain must be proven again.
+		for txid, payment := range client.payments {
+			if payment.BlockHeight >= fork {
+				delete(client.payments, txid)
+			}
+		}
+	}
+	client.headers = chain
+	return nil
+}
+
+// SyncPayments asks peers for the transactions of watched addresses and
+// keeps those whose merkle proofs check out against the header chain.
+func (client *Client) SyncPayments(ctx context.Context) error {
+	client.mu.RLock()
+	addresses := make([]string, 0, len(client.watched))
+	for address := range client.watched {
+		addresses = append(addresses, address)
+	}
+	client.mu.RUnlock()
+
+	var lastErr error
+	for _, peer := range client.peers {
+		for _, address := range addresses {
+			if err := client.syncAddress(ctx, peer, address); err != nil {
+				client.logger.Warn("payment sync failed", "peer", peer, "address", address, "error", err)
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+type transactionInfo struct {
+	TxID        string `json:"txid"`
+	BlockHeight int    `json:"block_height"`
+}
+
+func (client *Client) syncAddress(ctx context.Context, peer string, address string) error {
+	var infos []transactionInfo
+	if err := client.get(ctx, peer+"/addresses/"+url.PathEscape(address)+"/transactions", &infos); err != nil {
+		return err
+	}
+	for _, info := range infos {
+		client.mu.RLock()
+		_, known := client.payments[info.TxID]
+		client.mu.RUnlock()
+		if known {
+			continue
+		}
+		var proof blockchain.TransactionProof
+		if err := client.get(ctx, peer+"/transactions/"+url.PathEscape(info.TxID)+"/proof", &proof); err != nil {
+			client.logger.Warn("proof unavailable", "peer", peer, "txid", info.TxID, "error", err)
+			continue
+		}
+		err := client.addPayment(&proof)
+		if err == ErrUnknownBlock {
+			// The peer is ahead of our headers; retry after the next sync.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("transaction %s: %v", info.TxID, err)
+		}
+	}
+	return nil
+}
+
+// addPayment checks proof against the header chain before recording it.
+func (client *Client) addPayment(proof *blockchain.TransactionProof) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if proof.BlockHeight < 0 || proof.BlockHeight >= len(client.headers) {
+		return ErrUnknownBlock
+	}
+	header := &client.headers[proof.BlockHeight]
+	if header.Hash != proof.BlockHash {
+		return ErrUnknownBlock
+	}
+	if proof.Transaction.Hash() != proof.TxID ||
+		!blockchain.VerifyMerkleProof(header.MerkleHash, &proof.Transaction, proof.Position, proof.Size, proof.Proof) {
+		return ErrProofMismatch
+	}
+	client.payments[proof.TxID] = &Payment{
+		TxID:        proof.TxID,
+		Transaction: proof.Transaction,
+		BlockHash:   header.Hash,
+		BlockHeight: header.Height,
+	}
+	return nil
+}
+
+// Payments returns the verified transactions of address, oldest first.
+func (client *Client) Payments(address string) []Payment {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	var payments []Payment
+	for _, payment := range client.payments {
+		if payment.Transaction.Sender != address && payment.Transaction.Recipient != address {
+			continue
+		}
+		p := *payment
+		p.Confirmations = len(client.headers) - p.BlockHeight
+		payments = append(payments, p)
+	}
+	sortPayments(payments)
+	return payments
+}
+
+// Balance sums the verified transactions of address. Transactions a peer
+// did not report, or whose blocks it has pruned, are not counted.
+func (client *Client) Balance(address string, confirmations int) int64 {
+	var balance int64
+	for _, payment := range client.Payments(address) {
+		if payment.Confirmations < confirmations {
+			continue
+		}
+		if payment.Transaction.Recipient == address {
+			balance += payment.Transaction.Amount
+		}
+		if payment.Transaction.Sender == address {
+			balance -= payment.Transaction.Amount
+		}
+	}
+	return balance
+}
+
+func sortPayments(payments []Payment) {
+	sort.Slice(payments, func(i, j int) bool {
+		if payments[i].BlockHeight != payments[j].BlockHeight {
+			return payments[i].BlockHeight < payments[j].BlockHeight
+		}
+		return payments[i].TxID < payments[j].TxID
+	})
+}
+
+func (client *Client) get(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: http status code: %d", u, res.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(v)
+}
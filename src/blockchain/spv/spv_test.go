@@ -0,0 +1,127 @@
+package spv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"blockchain"
+)
+
+func newTestEngine(t *testing.T) blockchain.ConsensusEngine {
+	params := blockchain.DefaultPowParams
+	params.Difficulty = 1
+	engine, err := blockchain.NewProofOfWork(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+// newTestChain mines a chain of height blocks, with timestamps from start.
+func newTestChain(t *testing.T, engine blockchain.ConsensusEngine, height int, start int64) *blockchain.BlockChain {
+	chain, err := blockchain.NewBlockChain(engine, blockchain.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < height; i++ {
+		if _, err := chain.Mine(context.Background(), start+int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return chain
+}
+
+// peer serves /headers from a chain that can be swapped.
+type peer struct {
+	mu    sync.Mutex
+	chain *blockchain.BlockChain
+}
+
+func (p *peer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p.mu.Lock()
+	chain := p.chain
+	p.mu.Unlock()
+	query := req.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	var headers []blockchain.Block
+	if locator := query.Get("locator"); locator != "" {
+		headers = chain.HeadersAfter(strings.Split(locator, ","), limit)
+	} else {
+		from, _ := strconv.Atoi(query.Get("from"))
+		headers = chain.Headers(from, limit)
+	}
+	json.NewEncoder(w).Encode(headers)
+}
+
+func TestSyncHeadersFollowsDeepFork(t *testing.T) {
+	engine := newTestEngine(t)
+	p := &peer{chain: newTestChain(t, engine, 15, blockchain.GenesisTimestamp+1)}
+	server := httptest.NewServer(p)
+	defer server.Close()
+	client := NewClient(engine, []string{server.URL})
+	ctx := context.Background()
+	if err := client.SyncHeaders(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if tip := client.Tip(); tip.Hash != p.chain.TipHash() {
+		t.Fatalf("tip = %d %s, want %s", tip.Height, tip.Hash, p.chain.TipHash())
+	}
+
+	// The peer moves to a longer chain forking at the genesis.
+	fork := newTestChain(t, engine, 20, blockchain.GenesisTimestamp+100)
+	p.mu.Lock()
+	p.chain = fork
+	p.mu.Unlock()
+	if err := client.SyncHeaders(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if tip := client.Tip(); tip.Hash != fork.TipHash() || client.Height() != 20 {
+		t.Fatalf("tip after the fork = %d %s, want 20 %s", tip.Height, tip.Hash, fork.TipHash())
+	}
+}
+
+func TestSyncHeadersInBatches(t *testing.T) {
+	engine := newTestEngine(t)
+	chain := newTestChain(t, engine, headersBatch+3, blockchain.GenesisTimestamp+1)
+	server := httptest.NewServer(&peer{chain: chain})
+	defer server.Close()
+	client := NewClient(engine, []string{server.URL})
+	if err := client.SyncHeaders(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if client.Height() != headersBatch+3 || client.Tip().Hash != chain.TipHash() {
+		t.Fatalf("synced to %d %s, want %d %s", client.Height(), client.Tip().Hash, headersBatch+3, chain.TipHash())
+	}
+}
+
+func TestSyncHeadersStopsAtInvalidBatch(t *testing.T) {
+	engine := newTestEngine(t)
+	p := &peer{chain: newTestChain(t, engine, 2*headersBatch+3, blockchain.GenesisTimestamp+1)}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		recorder := httptest.NewRecorder()
+		p.ServeHTTP(recorder, req)
+		var headers []blockchain.Block
+		json.Unmarshal(recorder.Body.Bytes(), &headers)
+		headers[10].Timestamp++
+		json.NewEncoder(w).Encode(headers)
+	}))
+	defer server.Close()
+	client := NewClient(engine, []string{server.URL})
+	if err := client.SyncHeaders(context.Background()); err != ErrNoPeers {
+		t.Fatalf("error = %v, want %v", err, ErrNoPeers)
+	}
+	if requests != 1 {
+		t.Fatalf("%d batches downloaded, want 1", requests)
+	}
+	if client.Height() != 0 {
+		t.Fatalf("height = %d, want 0", client.Height())
+	}
+}
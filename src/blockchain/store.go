@@ -0,0 +1,322 @@
+package blockchain
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+type Store interface {
+	LoadChain() ([]Block, error)
+	AppendBlock(block *Block) error
+	// ReplaceBlocks replaces all blocks at height and above with blocks in
+	// one step, so that a failure leaves the stored chain as it was.
+	ReplaceBlocks(height int, blocks []Block) error
+	// Prune replaces the blocks up to height with their headers.
+	Prune(height int) error
+	LoadIndex() (*Index, error)
+	SaveIndex(index *Index) error
+	// LoadSnapshot returns the latest saved snapshot, or nil if there is
+	// none.
+	LoadSnapshot() (*Snapshot, error)
+	SaveSnapshot(snapshot *Snapshot) error
+	// Ping reports whether the store can currently be written.
+	Ping() error
+}
+
+type MemoryStore struct {
+	chain    []Block
+	index    []byte
+	snapshot []byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (store *MemoryStore) LoadChain() ([]Block, error) {
+	return append([]Block(nil), store.chain...), nil
+}
+
+func (store *MemoryStore) AppendBlock(block *Block) error {
+	store.chain = append(store.chain, *block)
+	return nil
+}
+
+func (store *MemoryStore) ReplaceBlocks(height int, blocks []Block) error {
+	if height < len(store.chain) {
+		store.chain = store.chain[:height]
+	}
+	store.chain = append(store.chain, blocks...)
+	return nil
+}
+
+func (store *MemoryStore) LoadSnapshot() (*Snapshot, error) {
+	if store.snapshot == nil {
+		return nil, nil
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(store.snapshot, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func (store *MemoryStore) SaveSnapshot(snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	store.snapshot = data
+	return nil
+}
+
+func (store *MemoryStore) Ping() error {
+	return nil
+}
+
+func (store *MemoryStore) Prune(height int) error {
+	for i := 0; i <= height && i < len(store.chain); i++ {
+		store.chain[i] = store.chain[i].Header()
+	}
+	return nil
+}
+
+func (store *MemoryStore) LoadIndex() (*Index, error) {
+	if store.index == nil {
+		return nil, nil
+	}
+	index := NewIndex()
+	if err := json.Unmarshal(store.index, index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func (store *MemoryStore) SaveIndex(index *Index) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	store.index = data
+	return nil
+}
+
+// ErrStoreLocked is returned when another process has the data directory
+// open, such as chainctl while the node runs.
+var ErrStoreLocked = errors.New("data directory is in use by another process")
+
+// FileStore keeps the chain as newline-delimited JSON blocks and the index
+// as a JSON document in a directory. Writes are synced to disk before they
+// return.
+type FileStore struct {
+	dir string
+	// lock holds the lock on the directory until Close.
+	lock *os.File
+}
+
+// NewFileStore opens the store in dir, locking it against other processes.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(filepath.Join(dir, "LOCK"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return &FileStore{dir: dir, lock: lock}, nil
+}
+
+// Close releases the lock on the directory.
+func (store *FileStore) Close() error {
+	return store.lock.Close()
+}
+
+func (store *FileStore) chainPath() string {
+	return filepath.Join(store.dir, "blocks.jsonl")
+}
+
+func (store *FileStore) indexPath() string {
+	return filepath.Join(store.dir, "index.json")
+}
+
+func (store *FileStore) snapshotPath() string {
+	return filepath.Join(store.dir, "snapshot.json")
+}
+
+func (store *FileStore) LoadChain() ([]Block, error) {
+	file, err := os.Open(store.chainPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var chain []Block
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for decoder.More() {
+		var block Block
+		if err := decoder.Decode(&block); err != nil {
+			return nil, err
+		}
+		chain = append(chain, block)
+	}
+	return chain, nil
+}
+
+func (store *FileStore) AppendBlock(block *Block) error {
+	file, err := os.OpenFile(store.chainPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(file).Encode(block); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ReplaceBlocks writes the new chain to a temporary file and renames it over
+// the old one.
+func (store *FileStore) ReplaceBlocks(height int, blocks []Block) error {
+	chain, err := store.LoadChain()
+	if err != nil {
+		return err
+	}
+	if height < len(chain) {
+		chain = chain[:height]
+	}
+	chain = append(chain, blocks...)
+	return store.writeFile(store.chainPath(), func(encoder *json.Encoder) error {
+		for i := range chain {
+			if err := encoder.Encode(&chain[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (store *FileStore) Prune(height int) error {
+	chain, err := store.LoadChain()
+	if err != nil {
+		return err
+	}
+	return store.writeFile(store.chainPath(), func(encoder *json.Encoder) error {
+		for i := range chain {
+			block := chain[i]
+			if i <= height {
+				block = block.Header()
+			}
+			if err := encoder.Encode(&block); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Ping creates and removes a file in the data directory.
+func (store *FileStore) Ping() error {
+	file, err := ioutil.TempFile(store.dir, ".ping")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+func (store *FileStore) LoadIndex() (*Index, error) {
+	data, err := ioutil.ReadFile(store.indexPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	index := NewIndex()
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func (store *FileStore) SaveIndex(index *Index) error {
+	return store.writeFile(store.indexPath(), func(encoder *json.Encoder) error {
+		return encoder.Encode(index)
+	})
+}
+
+func (store *FileStore) LoadSnapshot() (*Snapshot, error) {
+	data, err := ioutil.ReadFile(store.snapshotPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func (store *FileStore) SaveSnapshot(snapshot *Snapshot) error {
+	return store.writeFile(store.snapshotPath(), func(encoder *json.Encoder) error {
+		return encoder.Encode(snapshot)
+	})
+}
+
+// writeFile replaces path atomically with the output of write. The file is
+// synced before the rename and the directory after it, so that a crash
+// leaves either the old or the new file.
+func (store *FileStore) writeFile(path string, write func(encoder *json.Encoder) error) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	if err := write(json.NewEncoder(writer)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
@@ -0,0 +1,124 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var errStoreFailed = errors.New("store failed")
+
+// failingStore is a MemoryStore whose writes can be made to fail.
+type failingStore struct {
+	*MemoryStore
+	failReplace   bool
+	failSaveIndex bool
+}
+
+func (store *failingStore) ReplaceBlocks(height int, blocks []Block) error {
+	if store.failReplace {
+		return errStoreFailed
+	}
+	return store.MemoryStore.ReplaceBlocks(height, blocks)
+}
+
+func (store *failingStore) SaveIndex(index *Index) error {
+	if store.failSaveIndex {
+		return errStoreFailed
+	}
+	return store.MemoryStore.SaveIndex(index)
+}
+
+func newFailingChain(t *testing.T) (*BlockChain, *failingStore) {
+	store := &failingStore{MemoryStore: NewMemoryStore()}
+	chain, err := NewBlockChain(newTestChain(t).engine, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain, store
+}
+
+func mineBlocks(t *testing.T, chain *BlockChain, timestamps ...int64) {
+	for _, timestamp := range timestamps {
+		if _, err := chain.Mine(context.Background(), timestamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReplaceChainKeepsChainWhenStoreFails(t *testing.T) {
+	chain, store := newFailingChain(t)
+	mineBlocks(t, chain, GenesisTimestamp+1, GenesisTimestamp+2)
+	other := newTestChain(t)
+	mineBlocks(t, other, GenesisTimestamp+3, GenesisTimestamp+4, GenesisTimestamp+5)
+
+	tip := chain.TipHash()
+	store.failReplace = true
+	if err := chain.replaceChain(other.Blocks()); err != errStoreFailed {
+		t.Fatalf("replaceChain error = %v, want %v", err, errStoreFailed)
+	}
+	if chain.TipHash() != tip || chain.Height() != 2 {
+		t.Fatalf("chain changed after a failed replace: height %d, tip %s", chain.Height(), chain.TipHash())
+	}
+	stored, _ := store.LoadChain()
+	if len(stored) != 3 || stored[2].Hash != tip {
+		t.Fatalf("stored chain changed after a failed replace: %d blocks", len(stored))
+	}
+
+	store.failReplace = false
+	if err := chain.replaceChain(other.Blocks()); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = store.LoadChain()
+	if chain.TipHash() != other.TipHash() || len(stored) != 4 || stored[3].Hash != other.TipHash() {
+		t.Fatalf("chain not replaced: tip %s, %d stored blocks", chain.TipHash(), len(stored))
+	}
+}
+
+func TestMineReturnsBlockWhenIndexSaveFails(t *testing.T) {
+	chain, store := newFailingChain(t)
+	store.failSaveIndex = true
+	block, err := chain.Mine(context.Background(), GenesisTimestamp+1)
+	if err != nil {
+		t.Fatalf("Mine error = %v, want the committed block", err)
+	}
+	if chain.TipHash() != block.Hash {
+		t.Fatalf("tip = %s, want mined block %s", chain.TipHash(), block.Hash)
+	}
+
+	// The stale index is rebuilt when the chain is opened again.
+	store.failSaveIndex = false
+	reopened, err := NewBlockChain(chain.engine, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reopened.index.blocks[block.Hash]; !ok {
+		t.Fatal("reopened index is missing the mined block")
+	}
+}
+
+func TestFileStoreLocksDirectory(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStore(dir); err != ErrStoreLocked {
+		t.Fatalf("second open: error = %v, want %v", err, ErrStoreLocked)
+	}
+	block := GenesisBlock()
+	if err := store.AppendBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("open after Close: %v", err)
+	}
+	defer store.Close()
+	if chain, err := store.LoadChain(); err != nil || len(chain) != 1 || chain[0].Hash != block.Hash {
+		t.Fatalf("LoadChain = %d blocks, %v, want the genesis block", len(chain), err)
+	}
+}
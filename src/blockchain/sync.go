@@ -0,0 +1,123 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+var ErrUnconnectedHeaders = errors.New("headers do not follow a block of the chain")
+
+// SyncStatus compares the local height with the height of the network, the
+// highest height reached by a majority of the peers with a known height.
+// Taking the majority keeps one peer that claims too much from holding the
+// node back.
+type SyncStatus struct {
+	Height         int  `json:"height"`
+	BestPeerHeight int  `json:"best_peer_height"`
+	Synced         bool `json:"synced"`
+}
+
+// SetPeerHeight records the height of node's chain. A height above the local
+// tip must have been checked with VerifyHeaders, so that a peer cannot
+// claim blocks it does not have.
+func (blockChain *BlockChain) SetPeerHeight(node string, height int) {
+	blockChain.mu.Lock()
+	defer blockChain.mu.Unlock()
+	blockChain.peerHeights[node] = height
+}
+
+// ForgetPeerHeight drops the height of node, for a peer that stopped
+// answering or sent headers that did not verify.
+func (blockChain *BlockChain) ForgetPeerHeight(node string) {
+	blockChain.mu.Lock()
+	defer blockChain.mu.Unlock()
+	delete(blockChain.peerHeights, node)
+}
+
+// SyncStatus reports the node as synced when it is at most tolerance blocks
+// behind the network. A node without peer heights is synced.
+func (blockChain *BlockChain) SyncStatus(tolerance int) SyncStatus {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	status := SyncStatus{Height: len(blockChain.Chain) - 1}
+	var heights []int
+	for _, node := range blockChain.Nodes {
+		if height, ok := blockChain.peerHeights[node]; ok {
+			heights = append(heights, height)
+		}
+	}
+	status.BestPeerHeight = status.Height
+	if len(heights) > 0 {
+		sort.Sort(sort.Reverse(sort.IntSlice(heights)))
+		if height := heights[len(heights)/2]; height > status.Height {
+			status.BestPeerHeight = height
+		}
+	}
+	status.Synced = status.BestPeerHeight-status.Height <= tolerance
+	return status
+}
+
+// HeadersAfter returns up to limit headers following the first locator hash
+// found in the chain, or following the genesis when none is, so that a peer
+// on a fork receives the headers of this chain from the fork point.
+func (blockChain *BlockChain) HeadersAfter(locator []string, limit int) []Block {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	from := 0
+	for _, hash := range locator {
+		if height, ok := blockChain.index.blocks[hash]; ok {
+			from = height
+			break
+		}
+	}
+	var headers []Block
+	for height := from + 1; height < len(blockChain.Chain) && len(headers) < limit; height++ {
+		headers = append(headers, blockChain.Chain[height].Header())
+	}
+	return headers
+}
+
+// VerifyHeaders checks headers received from a peer, as returned by
+// HeadersAfter for this chain's locator, and returns the height of the last
+// one. The first must follow a block of the chain, and each must be sealed
+// and extend the one before.
+func (blockChain *BlockChain) VerifyHeaders(headers []Block) (int, error) {
+	if len(headers) == 0 {
+		return 0, ErrUnconnectedHeaders
+	}
+	blockChain.mu.RLock()
+	height, ok := blockChain.index.blocks[headers[0].PreviousHash]
+	var parent Block
+	if ok {
+		parent = blockChain.Chain[height].Header()
+	}
+	blockChain.mu.RUnlock()
+	if !ok {
+		return 0, ErrUnconnectedHeaders
+	}
+	for i := range headers {
+		if err := ValidateHeader(blockChain.engine, &parent, &headers[i]); err != nil {
+			return 0, fmt.Errorf("header %d: %w", headers[i].Height, err)
+		}
+		parent = headers[i]
+	}
+	return parent.Height, nil
+}
+
+// Mining reports whether a block is being sealed.
+func (blockChain *BlockChain) Mining() bool {
+	return atomic.LoadInt32(&blockChain.mining) > 0
+}
+
+func (blockChain *BlockChain) TipHash() string {
+	blockChain.mu.RLock()
+	defer blockChain.mu.RUnlock()
+	return blockChain.previousHash()
+}
+
+// PingStore checks that the underlying store is reachable.
+func (blockChain *BlockChain) PingStore() error {
+	return blockChain.store.Ping()
+}
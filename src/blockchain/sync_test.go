@@ -0,0 +1,59 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSyncStatusIgnoresOutliers(t *testing.T) {
+	chain := newTestChain(t)
+	mineBlocks(t, chain, GenesisTimestamp+1)
+	for _, node := range []string{"http://a", "http://b", "http://c"} {
+		chain.AddNode(node)
+	}
+	chain.SetPeerHeight("http://a", 1000)
+	chain.SetPeerHeight("http://b", 1)
+	chain.SetPeerHeight("http://c", 2)
+	if status := chain.SyncStatus(2); !status.Synced || status.BestPeerHeight != 2 {
+		t.Fatalf("one peer far ahead: status = %+v, want synced at 2", status)
+	}
+	chain.SetPeerHeight("http://b", 10)
+	if status := chain.SyncStatus(2); status.Synced || status.BestPeerHeight != 10 {
+		t.Fatalf("most peers ahead: status = %+v, want not synced behind 10", status)
+	}
+	chain.ForgetPeerHeight("http://b")
+	chain.ForgetPeerHeight("http://c")
+	if status := chain.SyncStatus(2); status.Synced {
+		t.Fatalf("only peer ahead: status = %+v, want not synced", status)
+	}
+}
+
+func TestVerifyHeadersFromFork(t *testing.T) {
+	chain := newTestChain(t)
+	mineBlocks(t, chain, GenesisTimestamp+1, GenesisTimestamp+2)
+	peer := newTestChain(t)
+	mineBlocks(t, peer, GenesisTimestamp+1)
+	if err := peer.replaceChain(chain.Blocks()[:2]); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, peer, GenesisTimestamp+5, GenesisTimestamp+6, GenesisTimestamp+7)
+
+	// The chains share block 1; the peer's headers start after it.
+	headers := peer.HeadersAfter(chain.Locator(), 100)
+	if len(headers) != 3 || headers[0].Height != 2 {
+		t.Fatalf("HeadersAfter returned %d headers from %d, want 3 from 2", len(headers), headers[0].Height)
+	}
+	height, err := chain.VerifyHeaders(headers)
+	if err != nil || height != 4 {
+		t.Fatalf("VerifyHeaders = %d, %v, want 4", height, err)
+	}
+
+	forged := append([]Block(nil), headers...)
+	forged[1].Nonce++
+	if _, err := chain.VerifyHeaders(forged); err == nil {
+		t.Fatal("VerifyHeaders accepted a header with a wrong seal")
+	}
+	if _, err := chain.VerifyHeaders(headers[1:]); !errors.Is(err, ErrUnconnectedHeaders) {
+		t.Fatalf("VerifyHeaders of unconnected headers: error = %v, want %v", err, ErrUnconnectedHeaders)
+	}
+}
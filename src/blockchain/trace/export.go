@@ -0,0 +1,227 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriterExporter writes each span as one JSON line, for example to stdout.
+type WriterExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+type jsonSpan struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Name       string                 `json:"name"`
+	Kind       string                 `json:"kind"`
+	Start      time.Time              `json:"start"`
+	DurationMS float64                `json:"duration_ms"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+func (exporter *WriterExporter) ExportSpan(span *SpanData) {
+	out := jsonSpan{
+		TraceID:    span.Context.TraceID.String(),
+		SpanID:     span.Context.SpanID.String(),
+		Name:       span.Name,
+		Kind:       span.Kind.String(),
+		Start:      span.Start,
+		DurationMS: float64(span.End.Sub(span.Start)) / float64(time.Millisecond),
+		Error:      span.Error,
+	}
+	if span.Parent.IsValid() {
+		out.ParentID = span.Parent.String()
+	}
+	if len(span.Attributes) > 0 {
+		out.Attributes = make(map[string]interface{}, len(span.Attributes))
+		for _, attribute := range span.Attributes {
+			out.Attributes[attribute.Key] = attribute.Value
+		}
+	}
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	json.NewEncoder(exporter.w).Encode(out)
+}
+
+const (
+	otlpBatchSize     = 256
+	otlpQueueSize     = 4096
+	otlpFlushInterval = 2 * time.Second
+)
+
+// OTLPExporter sends spans in batches to an OpenTelemetry collector with
+// OTLP over HTTP using the JSON encoding. Spans are dropped when the queue
+// is full so that tracing never slows the node down.
+type OTLPExporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+	queue       chan *SpanData
+}
+
+// NewOTLPExporter exports to endpoint, the collector's base URL such as
+// http://localhost:4318.
+func NewOTLPExporter(endpoint string, serviceName string) *OTLPExporter {
+	exporter := &OTLPExporter{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan *SpanData, otlpQueueSize),
+	}
+	go exporter.run()
+	return exporter
+}
+
+func (exporter *OTLPExporter) ExportSpan(span *SpanData) {
+	select {
+	case exporter.queue <- span:
+	default:
+	}
+}
+
+func (exporter *OTLPExporter) run() {
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+	var batch []*SpanData
+	for {
+		select {
+		case span := <-exporter.queue:
+			batch = append(batch, span)
+			if len(batch) < otlpBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := exporter.send(batch); err != nil {
+			slog.Warn("exporting spans failed", "spans", len(batch), "error", err)
+		}
+		batch = nil
+	}
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func otlpAttributeOf(attribute Attribute) otlpAttribute {
+	var value otlpValue
+	switch v := attribute.Value.(type) {
+	case string:
+		value.StringValue = &v
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		value.IntValue = &s
+	case bool:
+		value.BoolValue = &v
+	case float64:
+		value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		value.StringValue = &s
+	}
+	return otlpAttribute{Key: attribute.Key, Value: value}
+}
+
+func (exporter *OTLPExporter) send(batch []*SpanData) error {
+	scope := otlpScopeSpans{}
+	scope.Scope.Name = "blockchain"
+	for _, span := range batch {
+		out := otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			Name:              span.Name,
+			Kind:              int(span.Kind),
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		}
+		if span.Parent.IsValid() {
+			out.ParentSpanID = span.Parent.String()
+		}
+		for _, attribute := range span.Attributes {
+			out.Attributes = append(out.Attributes, otlpAttributeOf(attribute))
+		}
+		if span.Error != "" {
+			out.Status = otlpStatus{Code: 2, Message: span.Error}
+		}
+		scope.Spans = append(scope.Spans, out)
+	}
+	resource := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	resource.Resource.Attributes = []otlpAttribute{otlpAttributeOf(String("service.name", exporter.serviceName))}
+
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{resource}})
+	if err != nil {
+		return err
+	}
+	res, err := exporter.client.Post(exporter.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("http status code: %d", res.StatusCode)
+	}
+	return nil
+}
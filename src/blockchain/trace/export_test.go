@@ -0,0 +1,66 @@
+package trace
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOTLPRequest(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/traces" || req.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request to %s with type %s", req.URL.Path, req.Header.Get("Content-Type"))
+		}
+		body, _ = io.ReadAll(req.Body)
+	}))
+	defer server.Close()
+	exporter := &OTLPExporter{url: server.URL + "/v1/traces", serviceName: "node", client: server.Client()}
+
+	start := time.Unix(1700000000, 5)
+	var parent SpanID
+	copy(parent[:], "\x00\xf0\x67\xaa\x0b\xa9\x02\xb7")
+	span := &SpanData{
+		Name:   "mine",
+		Kind:   SpanKindServer,
+		Parent: parent,
+		Start:  start,
+		End:    start.Add(time.Millisecond),
+		Attributes: []Attribute{
+			String("peer", "http://8.8.8.8"),
+			Int("height", 3),
+			Bool("sealed", true),
+			{"ratio", 0.5},
+		},
+		Error: "sealing failed",
+	}
+	copy(span.Context.TraceID[:], "0123456789abcdef")
+	copy(span.Context.SpanID[:], "spanid01")
+	if err := exporter.send([]*SpanData{span}); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"node"}}]},` +
+		`"scopeSpans":[{"scope":{"name":"blockchain"},"spans":[{` +
+		`"traceId":"30313233343536373839616263646566","spanId":"7370616e69643031","parentSpanId":"00f067aa0ba902b7",` +
+		`"name":"mine","kind":2,"startTimeUnixNano":"1700000000000000005","endTimeUnixNano":"1700000000001000005",` +
+		`"attributes":[{"key":"peer","value":{"stringValue":"http://8.8.8.8"}},{"key":"height","value":{"intValue":"3"}},` +
+		`{"key":"sealed","value":{"boolValue":true}},{"key":"ratio","value":{"doubleValue":0.5}}],` +
+		`"status":{"code":2,"message":"sealing failed"}}]}]}]}`
+	if string(body) != want {
+		t.Fatalf("request body:\n%s\nwant:\n%s", body, want)
+	}
+}
+
+func TestOTLPSendReportsStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	exporter := &OTLPExporter{url: server.URL + "/v1/traces", serviceName: "node", client: server.Client()}
+	err := exporter.send([]*SpanData{{Name: "mine", Kind: SpanKindInternal}})
+	if err == nil || err.Error() != "http status code: 503" {
+		t.Fatalf("error = %v, want http status code: 503", err)
+	}
+}
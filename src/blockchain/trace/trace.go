@@ -0,0 +1,200 @@
+// Package trace records spans of node operations and propagates trace
+// context between nodes with the W3C traceparent header.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext identifies a span within a trace, possibly one in another
+// process.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota + 1
+	SpanKindServer
+	SpanKindClient
+)
+
+func (kind SpanKind) String() string {
+	switch kind {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key string, value string) Attribute {
+	return Attribute{key, value}
+}
+
+func Int(key string, value int) Attribute {
+	return Attribute{key, int64(value)}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{key, value}
+}
+
+// SpanData is a finished span as handed to the exporter.
+type SpanData struct {
+	Name       string
+	Kind       SpanKind
+	Context    SpanContext
+	Parent     SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Error      string
+}
+
+// Exporter receives every ended span. Implementations must not block.
+type Exporter interface {
+	ExportSpan(span *SpanData)
+}
+
+var (
+	exporterMu sync.RWMutex
+	exporter   Exporter
+)
+
+// SetExporter installs the exporter for ended spans. Without one, spans
+// still carry trace context but are discarded.
+func SetExporter(e Exporter) {
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	exporter = e
+}
+
+func currentExporter() Exporter {
+	exporterMu.RLock()
+	defer exporterMu.RUnlock()
+	return exporter
+}
+
+type Span struct {
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// Start begins a span that is a child of the span in ctx, or of a remote
+// span extracted into ctx, and returns a context carrying the new span.
+func Start(ctx context.Context, name string, kind SpanKind, attributes ...Attribute) (context.Context, *Span) {
+	span := &Span{data: SpanData{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: attributes,
+	}}
+	parent := SpanContextFromContext(ctx)
+	if parent.IsValid() {
+		span.data.Context.TraceID = parent.TraceID
+		span.data.Parent = parent.SpanID
+		span.data.Context.Sampled = parent.Sampled
+	} else {
+		rand.Read(span.data.Context.TraceID[:])
+		span.data.Context.Sampled = true
+	}
+	rand.Read(span.data.Context.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// FromContext returns the span in ctx or nil.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SpanContextFromContext returns the context of the current span in ctx,
+// falling back to a remote parent set by Extract.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := FromContext(ctx); span != nil {
+		return span.SpanContext()
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+func (span *Span) SpanContext() SpanContext {
+	return span.data.Context
+}
+
+func (span *Span) SetAttributes(attributes ...Attribute) {
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	span.data.Attributes = append(span.data.Attributes, attributes...)
+}
+
+// RecordError marks the span as failed. A nil err is ignored.
+func (span *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	span.data.Error = err.Error()
+}
+
+// End finishes the span and exports it. Calls after the first are ignored.
+func (span *Span) End() {
+	span.mu.Lock()
+	if span.ended {
+		span.mu.Unlock()
+		return
+	}
+	span.ended = true
+	span.data.End = time.Now()
+	data := span.data
+	data.Attributes = append([]Attribute(nil), span.data.Attributes...)
+	span.mu.Unlock()
+
+	if e := currentExporter(); e != nil && data.Context.Sampled {
+		e.ExportSpan(&data)
+	}
+}
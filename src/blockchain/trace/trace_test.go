@@ -0,0 +1,109 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		valid  bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		// Later versions may add fields.
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0z", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"", false},
+	} {
+		sc, ok := parseTraceparent(test.header)
+		if ok != test.valid {
+			t.Errorf("parseTraceparent(%q) = %v, want %v", test.header, ok, test.valid)
+		}
+		if ok && sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("parseTraceparent(%q) trace ID = %s", test.header, sc.TraceID)
+		}
+	}
+}
+
+func TestInjectExtractRoundTrip(t *testing.T) {
+	ctx, span := Start(context.Background(), "client", SpanKindClient)
+	header := make(http.Header)
+	Inject(ctx, header)
+	remote := SpanContextFromContext(Extract(context.Background(), header))
+	if remote != span.SpanContext() {
+		t.Fatalf("extracted %+v, want %+v", remote, span.SpanContext())
+	}
+
+	// Unsampled traces stay unsampled.
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx, _ = Start(Extract(context.Background(), header), "server", SpanKindServer)
+	out := make(http.Header)
+	Inject(ctx, out)
+	if got := out.Get(TraceparentHeader); got[len(got)-3:] != "-00" || got[3:35] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("injected %q into the unsampled trace", got)
+	}
+
+	// Nothing is injected without a span, and bad headers are ignored.
+	header = make(http.Header)
+	Inject(context.Background(), header)
+	if len(header) != 0 {
+		t.Fatalf("injected %v without a span", header)
+	}
+	header.Set(TraceparentHeader, "garbage")
+	if sc := SpanContextFromContext(Extract(context.Background(), header)); sc.IsValid() {
+		t.Fatalf("extracted %+v from garbage", sc)
+	}
+}
+
+// recorder keeps the exported spans.
+type recorder struct {
+	spans []*SpanData
+}
+
+func (r *recorder) ExportSpan(span *SpanData) {
+	r.spans = append(r.spans, span)
+}
+
+func TestChildSpans(t *testing.T) {
+	r := &recorder{}
+	SetExporter(r)
+	defer SetExporter(nil)
+
+	ctx, parent := Start(context.Background(), "parent", SpanKindServer)
+	_, child := Start(ctx, "child", SpanKindInternal, Int("height", 3))
+	child.End()
+	child.End()
+	parent.End()
+	if len(r.spans) != 2 {
+		t.Fatalf("%d spans exported, want 2", len(r.spans))
+	}
+	childData, parentData := r.spans[0], r.spans[1]
+	if childData.Context.TraceID != parentData.Context.TraceID {
+		t.Errorf("child trace %s, parent trace %s", childData.Context.TraceID, parentData.Context.TraceID)
+	}
+	if childData.Parent != parentData.Context.SpanID || childData.Context.SpanID == parentData.Context.SpanID {
+		t.Errorf("child %s has parent %s, want %s", childData.Context.SpanID, childData.Parent, parentData.Context.SpanID)
+	}
+	if parentData.Parent.IsValid() {
+		t.Errorf("root span has parent %s", parentData.Parent)
+	}
+
+	// A span under a remote parent continues its trace.
+	header := make(http.Header)
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, span := Start(Extract(context.Background(), header), "server", SpanKindServer)
+	if sc := span.SpanContext(); sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || span.data.Parent.String() != "00f067aa0ba902b7" {
+		t.Fatalf("span under a remote parent: trace %s, parent %s", sc.TraceID, span.data.Parent)
+	}
+}
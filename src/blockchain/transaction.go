@@ -4,6 +4,20 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+
+	"blockchain/merkle"
+)
+
+var (
+	ErrMissingSender    = errors.New("transaction sender is required")
+	ErrMissingRecipient = errors.New("transaction recipient is required")
+	ErrInvalidAmount    = errors.New("transaction amount must be positive")
+	ErrInvalidTimestamp = errors.New("transaction timestamp is out of range")
+	// ErrDuplicateTransaction rejects a transaction whose txid is already
+	// pooled or on the chain. The txid covers the timestamp, so the same
+	// payment can be sent again a second later.
+	ErrDuplicateTransaction = errors.New("transaction is already pooled or on the chain")
 )
 
 type Transaction struct {
@@ -13,48 +27,78 @@ type Transaction struct {
 	Amount    int64  `json:"amount"`
 }
 
+func (transaction *Transaction) Validate() error {
+	if transaction.Sender == "" {
+		return ErrMissingSender
+	}
+	if transaction.Recipient == "" {
+		return ErrMissingRecipient
+	}
+	if transaction.Amount <= 0 {
+		return ErrInvalidAmount
+	}
+	return nil
+}
+
 func (transaction *Transaction) Hash() string {
+	return hex.EncodeToString(transaction.hashBytes())
+}
+
+func (transaction *Transaction) hashBytes() []byte {
 	marshal, err := json.Marshal(transaction)
 	if err != nil {
-		return ""
+		return nil
 	}
 
 	bytes := sha256.Sum256([]byte(marshal))
-	return hex.EncodeToString(bytes[:])
+	return bytes[:]
 }
 
-func roundupPowerOf2(n int) int {
-	// Bit Twiddling Hacks
-	x := uint32(n - 1)
-	x |= x >> 1
-	x |= x >> 2
-	x |= x >> 4
-	x |= x >> 8
-	x |= x >> 16
-	return int(x + 1)
+func (transaction *Transaction) addresses() []string {
+	var addresses []string
+	if transaction.Sender != "" {
+		addresses = append(addresses, transaction.Sender)
+	}
+	if transaction.Recipient != "" && transaction.Recipient != transaction.Sender {
+		addresses = append(addresses, transaction.Recipient)
+	}
+	return addresses
 }
 
 func CalcMerkleHash(transactions []Transaction) string {
-	len := len(transactions)
-	if len == 0 {
-		return ""
+	leaves := make([][]byte, len(transactions))
+	for i := range transactions {
+		leaves[i] = transactions[i].hashBytes()
 	}
-	size := roundupPowerOf2(len)
-	return calcMarkleRoot(transactions, size)
+	return hex.EncodeToString(merkle.Root(leaves))
 }
 
-func calcMarkleRoot(transactions []Transaction, size int) string {
-	if size == 1 {
-		return transactions[0].Hash()
+func MerkleProof(transactions []Transaction, index int) ([]string, error) {
+	leaves := make([][]byte, len(transactions))
+	for i := range transactions {
+		leaves[i] = transactions[i].hashBytes()
 	}
-
-	len := len(transactions)
-	h1 := calcMarkleRoot(transactions[:size/2], size/2)
-	h2 := h1
-	if len > size/2 {
-		h2 = calcMarkleRoot(transactions[size/2:], size/2)
+	proof, err := merkle.Proof(leaves, index)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(proof))
+	for i, hash := range proof {
+		hashes[i] = hex.EncodeToString(hash)
 	}
-	bytes := sha256.Sum256([]byte(h1 + h2))
+	return hashes, nil
+}
 
-	return hex.EncodeToString(bytes[:])
+func VerifyMerkleProof(merkleHash string, transaction *Transaction, index int, size int, proof []string) bool {
+	root, err := hex.DecodeString(merkleHash)
+	if err != nil {
+		return false
+	}
+	hashes := make([][]byte, len(proof))
+	for i, hash := range proof {
+		if hashes[i], err = hex.DecodeString(hash); err != nil {
+			return false
+		}
+	}
+	return merkle.VerifyProof(root, transaction.hashBytes(), index, size, hashes)
 }
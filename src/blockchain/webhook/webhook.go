@@ -0,0 +1,415 @@
+// Package webhook notifies registered URLs about activity on watched
+// addresses.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"blockchain"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	EventReceived  = "address.received"
+	EventConfirmed = "address.confirmed"
+	EventReverted  = "address.reverted"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	IDHeader        = "X-Webhook-Id"
+)
+
+const (
+	MaxAttempts  = 6
+	retryBackoff = 2 * time.Second
+	queueSize    = 1024
+	workers      = 4
+	// revertWindow is how many blocks past its confirmation a transaction
+	// is still watched, so that a reorg taking it out is reported.
+	revertWindow  = 100
+	lookupTimeout = 5 * time.Second
+)
+
+var (
+	ErrInvalidURL     = errors.New("webhook url must be http or https")
+	ErrMissingAddress = errors.New("webhook address is required")
+	ErrNotFound       = errors.New("webhook not found")
+	ErrPrivateURL     = errors.New("webhook url must not point to a loopback, private or link-local address")
+	ErrUnresolvedURL  = errors.New("webhook url host cannot be resolved")
+)
+
+type Webhook struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Address       string `json:"address"`
+	Confirmations int    `json:"confirmations"`
+	Secret        string `json:"secret,omitempty"`
+}
+
+type Payload struct {
+	Event         string                 `json:"event"`
+	WebhookID     string                 `json:"webhook_id"`
+	Address       string                 `json:"address"`
+	TxID          string                 `json:"txid"`
+	Transaction   blockchain.Transaction `json:"transaction"`
+	BlockHash     string                 `json:"block_hash"`
+	BlockHeight   int                    `json:"block_height"`
+	Confirmations int                    `json:"confirmations"`
+}
+
+type delivery struct {
+	webhook  Webhook
+	payload  Payload
+	attempts int
+}
+
+type pending struct {
+	webhookID   string
+	txid        string
+	transaction blockchain.Transaction
+	blockHash   string
+	height      int
+	confirmed   bool
+}
+
+type Config struct {
+	// Path is the file the webhooks are saved in; they are only kept in
+	// memory when it is empty.
+	Path string
+	// AllowPrivate allows webhook urls on loopback, private and link-local
+	// addresses, which are refused by default so that webhooks cannot be
+	// used to reach the node's own network.
+	AllowPrivate bool
+}
+
+// Manager keeps the registry and tracks transactions until they reach the
+// confirmation threshold of each webhook, and for revertWindow blocks after
+// that to report them when a reorg takes them out.
+type Manager struct {
+	mu       sync.Mutex
+	config   Config
+	webhooks map[string]Webhook
+	pending  []*pending
+	tip      int
+	queue    chan *delivery
+	client   *http.Client
+}
+
+// NewManager loads the webhooks saved at config.Path and starts watching
+// blockChain.
+func NewManager(blockChain *blockchain.BlockChain, config Config) (*Manager, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !config.AllowPrivate {
+		dialer = blockchain.PublicDialer(dialer)
+	}
+	manager := &Manager{
+		config:   config,
+		webhooks: make(map[string]Webhook),
+		tip:      blockChain.Height(),
+		queue:    make(chan *delivery, queueSize),
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+		},
+	}
+	if err := manager.load(); err != nil {
+		return nil, err
+	}
+	for i := 0; i < workers; i++ {
+		go manager.deliverLoop()
+	}
+	blockChain.Listen(manager.handleEvent)
+	return manager, nil
+}
+
+func (manager *Manager) load() error {
+	if manager.config.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(manager.config.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var webhooks []Webhook
+	if err := json.Unmarshal(data, &webhooks); err != nil {
+		return err
+	}
+	for _, webhook := range webhooks {
+		manager.webhooks[webhook.ID] = webhook
+	}
+	return nil
+}
+
+// save writes the webhooks, secrets included, atomically to config.Path.
+// The caller holds mu.
+func (manager *Manager) save() error {
+	if manager.config.Path == "" {
+		return nil
+	}
+	webhooks := make([]Webhook, 0, len(manager.webhooks))
+	for _, webhook := range manager.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+	data, err := json.Marshal(webhooks)
+	if err != nil {
+		return err
+	}
+	tmp := manager.config.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, manager.config.Path)
+}
+
+// checkURL refuses webhook urls whose host resolves to an address that is
+// not public, unless config.AllowPrivate is set. Deliveries check again
+// when they dial.
+func (manager *Manager) checkURL(u *url.URL) error {
+	if manager.config.AllowPrivate {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	if _, err := blockchain.ResolvePublic(ctx, u.Hostname()); err != nil {
+		if err == blockchain.ErrPrivateAddress {
+			return ErrPrivateURL
+		}
+		return fmt.Errorf("%w: %v", ErrUnresolvedURL, err)
+	}
+	return nil
+}
+
+func (manager *Manager) Register(webhook Webhook) (Webhook, error) {
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, ErrInvalidURL
+	}
+	if err := manager.checkURL(u); err != nil {
+		return Webhook{}, err
+	}
+	if webhook.Address == "" {
+		return Webhook{}, ErrMissingAddress
+	}
+	if webhook.Confirmations < 1 {
+		webhook.Confirmations = 1
+	}
+	if webhook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return Webhook{}, err
+		}
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+	webhook.ID = uuid.NewV4().String()
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	manager.webhooks[webhook.ID] = webhook
+	if err := manager.save(); err != nil {
+		delete(manager.webhooks, webhook.ID)
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (manager *Manager) Unregister(id string) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	webhook, ok := manager.webhooks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(manager.webhooks, id)
+	if err := manager.save(); err != nil {
+		manager.webhooks[id] = webhook
+		return err
+	}
+	return nil
+}
+
+// List returns the registered webhooks without their secrets.
+func (manager *Manager) List() []Webhook {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	webhooks := make([]Webhook, 0, len(manager.webhooks))
+	for _, webhook := range manager.webhooks {
+		webhook.Secret = ""
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks
+}
+
+func (manager *Manager) handleEvent(event blockchain.Event) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	switch event.Type {
+	case blockchain.EventBlock:
+		manager.connectBlock(event.Block)
+	case blockchain.EventReorg:
+		manager.disconnectBlocks(event.Reorg)
+	}
+}
+
+func (manager *Manager) connectBlock(block *blockchain.Block) {
+	manager.tip = block.Height
+	for _, transaction := range block.Transactions {
+		txid := transaction.Hash()
+		for _, webhook := range manager.webhooks {
+			if transaction.Recipient != webhook.Address {
+				continue
+			}
+			p := &pending{
+				webhookID:   webhook.ID,
+				txid:        txid,
+				transaction: transaction,
+				blockHash:   block.Hash,
+				height:      block.Height,
+			}
+			manager.enqueue(webhook, EventReceived, p)
+			manager.pending = append(manager.pending, p)
+		}
+	}
+
+	remaining := manager.pending[:0]
+	for _, p := range manager.pending {
+		webhook, ok := manager.webhooks[p.webhookID]
+		if !ok {
+			continue
+		}
+		confirmations := manager.confirmations(p)
+		if !p.confirmed && confirmations >= webhook.Confirmations {
+			manager.enqueue(webhook, EventConfirmed, p)
+			p.confirmed = true
+		}
+		if p.confirmed && confirmations > webhook.Confirmations+revertWindow {
+			continue
+		}
+		remaining = append(remaining, p)
+	}
+	manager.pending = remaining
+}
+
+func (manager *Manager) disconnectBlocks(reorg *blockchain.Reorg) {
+	manager.tip = reorg.ForkHeight - 1
+	removed := make(map[string]bool)
+	for _, block := range reorg.Removed {
+		removed[block.Hash] = true
+	}
+
+	remaining := manager.pending[:0]
+	for _, p := range manager.pending {
+		if !removed[p.blockHash] {
+			remaining = append(remaining, p)
+			continue
+		}
+		if webhook, ok := manager.webhooks[p.webhookID]; ok {
+			manager.enqueue(webhook, EventReverted, p)
+		}
+	}
+	manager.pending = remaining
+}
+
+func (manager *Manager) confirmations(p *pending) int {
+	return manager.tip - p.height + 1
+}
+
+func (manager *Manager) enqueue(webhook Webhook, event string, p *pending) {
+	d := &delivery{
+		webhook: webhook,
+		payload: Payload{
+			Event:         event,
+			WebhookID:     webhook.ID,
+			Address:       webhook.Address,
+			TxID:          p.txid,
+			Transaction:   p.transaction,
+			BlockHash:     p.blockHash,
+			BlockHeight:   p.height,
+			Confirmations: manager.confirmations(p),
+		},
+	}
+	select {
+	case manager.queue <- d:
+	default:
+		slog.Warn("webhook queue full, dropping delivery", "webhook", webhook.ID, "event", event)
+	}
+}
+
+func (manager *Manager) deliverLoop() {
+	for d := range manager.queue {
+		err := manager.deliver(d)
+		if err == nil {
+			continue
+		}
+		d.attempts++
+		if d.attempts >= MaxAttempts {
+			slog.Warn("webhook delivery failed, giving up", "webhook", d.webhook.ID, "attempts", d.attempts, "error", err)
+			continue
+		}
+		retry := d
+		time.AfterFunc(retryBackoff<<uint(d.attempts-1), func() {
+			select {
+			case manager.queue <- retry:
+			default:
+				slog.Warn("webhook queue full, dropping retry", "webhook", retry.webhook.ID)
+			}
+		})
+	}
+}
+
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+func (manager *Manager) deliver(d *delivery) error {
+	body, err := json.Marshal(d.payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", d.webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(d.webhook.Secret, body))
+	req.Header.Set(EventHeader, d.payload.Event)
+	req.Header.Set(IDHeader, d.webhook.ID)
+
+	res, err := manager.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook: http status code: %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"blockchain"
+)
+
+func newTestManager(t *testing.T, config Config) *Manager {
+	engine, err := blockchain.NewProofOfWork(blockchain.DefaultPowParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.NewBlockChain(engine, blockchain.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := NewManager(chain, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager
+}
+
+// newReceiver returns the url of a server passing the payloads it receives
+// to the returned channel.
+func newReceiver(t *testing.T) (string, chan Payload) {
+	payloads := make(chan Payload, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var payload Payload
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		payloads <- payload
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, payloads
+}
+
+func receive(t *testing.T, payloads chan Payload, event string) Payload {
+	select {
+	case payload := <-payloads:
+		if payload.Event != event {
+			t.Fatalf("event = %s, want %s", payload.Event, event)
+		}
+		return payload
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %s delivery", event)
+		return Payload{}
+	}
+}
+
+func TestRegisterRejectsPrivateURL(t *testing.T) {
+	manager := newTestManager(t, Config{})
+	for _, u := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://10.1.2.3/hook",
+		"http://192.168.0.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		if _, err := manager.Register(Webhook{URL: u, Address: "bob"}); err != ErrPrivateURL {
+			t.Errorf("Register(%s) error = %v, want %v", u, err, ErrPrivateURL)
+		}
+	}
+	if _, err := manager.Register(Webhook{URL: "ftp://example.com", Address: "bob"}); err != ErrInvalidURL {
+		t.Errorf("Register of an ftp url: error = %v, want %v", err, ErrInvalidURL)
+	}
+}
+
+func TestDeliveryRefusesPrivateAddress(t *testing.T) {
+	// A name may resolve to a public address at registration and to a
+	// private one at delivery; the dialer checks again.
+	url, _ := newReceiver(t)
+	manager := newTestManager(t, Config{})
+	err := manager.deliver(&delivery{webhook: Webhook{URL: url, Secret: "secret"}})
+	if !errors.Is(err, blockchain.ErrPrivateAddress) {
+		t.Fatalf("deliver error = %v, want %v", err, blockchain.ErrPrivateAddress)
+	}
+}
+
+func TestWebhooksPersist(t *testing.T) {
+	config := Config{Path: filepath.Join(t.TempDir(), "webhooks.json"), AllowPrivate: true}
+	manager := newTestManager(t, config)
+	kept, err := manager.Register(Webhook{URL: "http://127.0.0.1/kept", Address: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := manager.Register(Webhook{URL: "http://127.0.0.1/removed", Address: "carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Unregister(removed.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := newTestManager(t, config)
+	if len(reopened.webhooks) != 1 {
+		t.Fatalf("reopened manager has %d webhooks, want 1", len(reopened.webhooks))
+	}
+	if got := reopened.webhooks[kept.ID]; got != kept {
+		t.Fatalf("reopened webhook = %+v, want %+v", got, kept)
+	}
+}
+
+func TestRevertedAfterConfirmation(t *testing.T) {
+	url, payloads := newReceiver(t)
+	manager := newTestManager(t, Config{AllowPrivate: true})
+	if _, err := manager.Register(Webhook{URL: url, Address: "bob", Confirmations: 2}); err != nil {
+		t.Fatal(err)
+	}
+	transaction := blockchain.Transaction{Timestamp: 1, Sender: "alice", Recipient: "bob", Amount: 5}
+	first := blockchain.Block{Height: 1, Hash: "first", Transactions: []blockchain.Transaction{transaction}}
+	second := blockchain.Block{Height: 2, Hash: "second"}
+
+	manager.handleEvent(blockchain.Event{Type: blockchain.EventBlock, Block: &first})
+	receive(t, payloads, EventReceived)
+	manager.handleEvent(blockchain.Event{Type: blockchain.EventBlock, Block: &second})
+	if payload := receive(t, payloads, EventConfirmed); payload.TxID != transaction.Hash() || payload.Confirmations != 2 {
+		t.Fatalf("confirmed payload = %+v", payload)
+	}
+
+	manager.handleEvent(blockchain.Event{Type: blockchain.EventReorg, Reorg: &blockchain.Reorg{
+		ForkHeight: 1,
+		Removed:    []blockchain.Block{first, second},
+	}})
+	if payload := receive(t, payloads, EventReverted); payload.TxID != transaction.Hash() || payload.BlockHash != "first" {
+		t.Fatalf("reverted payload = %+v", payload)
+	}
+}
+
+func TestConfirmedTransactionsForgottenAfterWindow(t *testing.T) {
+	manager := newTestManager(t, Config{AllowPrivate: true})
+	if _, err := manager.Register(Webhook{URL: "http://127.0.0.1/hook", Address: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	transaction := blockchain.Transaction{Timestamp: 1, Sender: "alice", Recipient: "bob", Amount: 5}
+	manager.handleEvent(blockchain.Event{Type: blockchain.EventBlock, Block: &blockchain.Block{
+		Height: 1, Hash: "first", Transactions: []blockchain.Transaction{transaction},
+	}})
+	for height := 2; height <= revertWindow+2; height++ {
+		manager.handleEvent(blockchain.Event{Type: blockchain.EventBlock, Block: &blockchain.Block{Height: height}})
+	}
+	if len(manager.pending) != 0 {
+		t.Fatalf("%d transactions still watched past the revert window", len(manager.pending))
+	}
+}
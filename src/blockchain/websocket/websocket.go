@@ -0,0 +1,244 @@
+// Package websocket implements the server side of RFC 6455, enough to push
+// text messages to browsers and tooling.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xa
+)
+
+const maxFrameSize = 1 << 20
+
+const closeTimeout = time.Second
+
+var (
+	ErrNotWebSocket  = errors.New("websocket: not a websocket handshake")
+	ErrFrameTooLarge = errors.New("websocket: frame too large")
+	ErrBadOrigin     = errors.New("websocket: origin not allowed")
+	ErrUnmasked      = errors.New("websocket: client frame is not masked")
+)
+
+type Conn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+}
+
+func IsWebSocketUpgrade(req *http.Request) bool {
+	return headerContains(req.Header, "Connection", "upgrade") &&
+		headerContains(req.Header, "Upgrade", "websocket")
+}
+
+func headerContains(header http.Header, key string, value string) bool {
+	for _, v := range header[key] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// checkOrigin allows requests without an Origin header, which browsers
+// always send, requests from the same host and requests from one of
+// origins; "*" allows any origin.
+func checkOrigin(req *http.Request, origins []string) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range origins {
+		if allowed == "*" || strings.EqualFold(strings.TrimRight(allowed, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// Upgrade performs the opening handshake and takes over the connection.
+// Browser requests from another site are refused unless their origin is in
+// origins, so that a page cannot use the credentials of its visitor.
+func Upgrade(w http.ResponseWriter, req *http.Request, origins []string) (*Conn, error) {
+	key := req.Header.Get("Sec-Websocket-Key")
+	if req.Method != "GET" || !IsWebSocketUpgrade(req) || key == "" {
+		http.Error(w, ErrNotWebSocket.Error(), http.StatusBadRequest)
+		return nil, ErrNotWebSocket
+	}
+	if !checkOrigin(req, origins) {
+		http.Error(w, ErrBadOrigin.Error(), http.StatusForbidden)
+		return nil, ErrBadOrigin
+	}
+	if req.Header.Get("Sec-Websocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, ErrNotWebSocket
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, ErrNotWebSocket
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, reader: rw.Reader}, nil
+}
+
+func (c *Conn) WriteMessage(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = append(header, byte(n>>8), byte(n))
+	default:
+		header[1] = 127
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(n))
+		header = append(header, buf[:]...)
+	}
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+func (c *Conn) WriteText(payload []byte) error {
+	return c.WriteMessage(OpText, payload)
+}
+
+// ReadMessage returns the next data message. Ping frames are answered and
+// a close frame is echoed before io.EOF is returned.
+func (c *Conn) ReadMessage() (byte, []byte, error) {
+	var message []byte
+	var messageOp byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case OpPing:
+			if err := c.WriteMessage(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			c.WriteMessage(OpClose, payload)
+			return 0, nil, io.EOF
+		case opContinuation:
+		default:
+			messageOp = opcode
+		}
+		message = append(message, payload...)
+		if len(message) > maxFrameSize {
+			return 0, nil, ErrFrameTooLarge
+		}
+		if fin {
+			return messageOp, message, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	opcode := head[0] & 0x0f
+	// Clients must mask every frame (RFC 6455 section 5.1).
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, ErrUnmasked
+	}
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var buf [2]byte
+		if _, err := io.ReadFull(c.reader, buf[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(buf[:]))
+	case 127:
+		var buf [8]byte
+		if _, err := io.ReadFull(c.reader, buf[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(buf[:])
+	}
+	if length > maxFrameSize {
+		return false, 0, nil, ErrFrameTooLarge
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// SetWriteDeadline sets the deadline of the writes to the connection, after
+// which they fail instead of waiting for a client that stopped reading.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// Close sends a close frame, waiting at most closeTimeout for it to be
+// written, and closes the connection.
+func (c *Conn) Close() error {
+	c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	c.WriteMessage(OpClose, nil)
+	return c.conn.Close()
+}
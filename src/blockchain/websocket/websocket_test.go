@@ -0,0 +1,231 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The handshake example of RFC 6455 section 1.3.
+func TestAcceptKey(t *testing.T) {
+	if got, want := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Fatalf("acceptKey = %s, want %s", got, want)
+	}
+}
+
+// newServer upgrades every request and hands the connection to serve.
+func newServer(t *testing.T, origins []string, serve func(*Conn)) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := Upgrade(w, req, origins)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// handshake sends an upgrade request with headers and returns the response
+// and the connection.
+func handshake(t *testing.T, server *httptest.Server, headers map[string]string) (*http.Response, net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	req, _ := http.NewRequest("GET", server.URL+"/events", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, conn, reader
+}
+
+func frame(opcode byte, fin bool, masked bool, payload []byte) []byte {
+	head := opcode
+	if fin {
+		head |= 0x80
+	}
+	buf := []byte{head, 0}
+	switch n := len(payload); {
+	case n < 126:
+		buf[1] = byte(n)
+	case n <= 0xffff:
+		buf[1] = 126
+		buf = append(buf, byte(n>>8), byte(n))
+	default:
+		buf[1] = 127
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(n))
+		buf = append(buf, length[:]...)
+	}
+	if !masked {
+		return append(buf, payload...)
+	}
+	buf[1] |= 0x80
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	buf = append(buf, mask...)
+	for i, b := range payload {
+		buf = append(buf, b^mask[i%4])
+	}
+	return buf
+}
+
+// readFrame reads an unmasked server frame.
+func readFrame(t *testing.T, reader *bufio.Reader) (byte, []byte) {
+	var head [2]byte
+	if _, err := io.ReadFull(reader, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("server frame is masked")
+	}
+	length := int(head[1] & 0x7f)
+	if length == 126 {
+		var buf [2]byte
+		io.ReadFull(reader, buf[:])
+		length = int(binary.BigEndian.Uint16(buf[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0f, payload
+}
+
+func TestUpgradeHandshake(t *testing.T) {
+	server := newServer(t, nil, func(conn *Conn) {})
+	res, _, _ := handshake(t, server, nil)
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", res.StatusCode)
+	}
+	if got, want := res.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Fatalf("Sec-WebSocket-Accept = %s, want %s", got, want)
+	}
+}
+
+func TestUpgradeRejectsBadHandshake(t *testing.T) {
+	server := newServer(t, nil, func(conn *Conn) {})
+	res, _, _ := handshake(t, server, map[string]string{"Sec-WebSocket-Version": "8"})
+	if res.StatusCode != http.StatusUpgradeRequired || res.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Fatalf("old version: status = %d, want 426 with version 13", res.StatusCode)
+	}
+	res, _, _ = handshake(t, server, map[string]string{"Sec-WebSocket-Key": ""})
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("missing key: status = %d, want 400", res.StatusCode)
+	}
+}
+
+func TestUpgradeChecksOrigin(t *testing.T) {
+	server := newServer(t, []string{"https://explorer.example"}, func(conn *Conn) {})
+	host := strings.TrimPrefix(server.URL, "http://")
+	tests := []struct {
+		origin string
+		status int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"http://" + host, http.StatusSwitchingProtocols},
+		{"https://explorer.example", http.StatusSwitchingProtocols},
+		{"https://evil.example", http.StatusForbidden},
+		{"http://" + host + ".evil.example", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		headers := map[string]string{}
+		if test.origin != "" {
+			headers["Origin"] = test.origin
+		}
+		res, _, _ := handshake(t, server, headers)
+		if res.StatusCode != test.status {
+			t.Errorf("origin %q: status = %d, want %d", test.origin, res.StatusCode, test.status)
+		}
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	messages := make(chan []byte, 1)
+	server := newServer(t, nil, func(conn *Conn) {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			close(messages)
+			return
+		}
+		messages <- message
+		conn.ReadMessage()
+	})
+	_, conn, reader := handshake(t, server, nil)
+
+	// A ping between the fragments of a message is answered with a pong.
+	conn.Write(frame(OpText, false, true, []byte("hello, ")))
+	conn.Write(frame(OpPing, true, true, []byte("ping")))
+	conn.Write(frame(opContinuation, true, true, bytes.Repeat([]byte("x"), 200)))
+	if opcode, payload := readFrame(t, reader); opcode != OpPong || string(payload) != "ping" {
+		t.Fatalf("got frame %#x %q, want pong", opcode, payload)
+	}
+	if got, want := string(<-messages), "hello, "+strings.Repeat("x", 200); got != want {
+		t.Fatalf("message = %q, want %q", got, want)
+	}
+
+	// A close frame is echoed.
+	conn.Write(frame(OpClose, true, true, []byte{0x03, 0xe8}))
+	if opcode, _ := readFrame(t, reader); opcode != OpClose {
+		t.Fatalf("got frame %#x, want close", opcode)
+	}
+}
+
+func TestReadMessageRejectsUnmaskedFrame(t *testing.T) {
+	errs := make(chan error, 1)
+	server := newServer(t, nil, func(conn *Conn) {
+		_, _, err := conn.ReadMessage()
+		errs <- err
+	})
+	_, conn, _ := handshake(t, server, nil)
+	conn.Write(frame(OpText, true, false, []byte("hello")))
+	if err := <-errs; err != ErrUnmasked {
+		t.Fatalf("ReadMessage error = %v, want %v", err, ErrUnmasked)
+	}
+}
+
+func TestReadMessageRejectsLargeFrame(t *testing.T) {
+	errs := make(chan error, 1)
+	server := newServer(t, nil, func(conn *Conn) {
+		_, _, err := conn.ReadMessage()
+		errs <- err
+	})
+	_, conn, _ := handshake(t, server, nil)
+	head := []byte{0x80 | OpBinary, 0x80 | 127, 0, 0, 0, 0, 0, 0x20, 0, 0}
+	conn.Write(head)
+	if err := <-errs; err != ErrFrameTooLarge {
+		t.Fatalf("ReadMessage error = %v, want %v", err, ErrFrameTooLarge)
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	payload := bytes.Repeat([]byte("y"), 300)
+	server := newServer(t, nil, func(conn *Conn) {
+		conn.WriteText(payload)
+		conn.ReadMessage()
+	})
+	_, _, reader := handshake(t, server, nil)
+	if opcode, got := readFrame(t, reader); opcode != OpText || !bytes.Equal(got, payload) {
+		t.Fatalf("got frame %#x of %d bytes, want text of %d", opcode, len(got), len(payload))
+	}
+}